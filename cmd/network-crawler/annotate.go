@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/netindex"
+)
+
+const annotateCommandName = "annotate"
+
+const (
+	// vpcFlowLogsFormat is the default text format of AWS VPC Flow Logs version 2
+	vpcFlowLogsFormat = "vpc-flow-logs"
+	csvFormat         = "csv"
+	jsonLinesFormat   = "jsonl"
+)
+
+const (
+	// vpcFlowLogsNoData is the value of the fields of VPC Flow Logs that have no value
+	vpcFlowLogsNoData = "-"
+	// annotationSeparator separates the values of the matches of the same prefix
+	annotationSeparator = "|"
+)
+
+// vpcFlowLogsFields are the fields of the default VPC Flow Logs version 2 format
+var vpcFlowLogsFields = []string{
+	"version", "account-id", "interface-id", "srcaddr", "dstaddr", "srcport", "dstport",
+	"protocol", "packets", "bytes", "start", "end", "action", "log-status",
+}
+
+// annotation is the provider, region and service of an IP address. Values of several
+// matches of the longest prefix are joined with annotationSeparator, in the same order
+// in the three values, so that the n-th provider, region and service form a match.
+type annotation struct {
+	provider, region, service string
+}
+
+func (a annotation) isEmpty() bool {
+	return a.provider == ""
+}
+
+// runAnnotate adds the provider, region and service of IP addresses to every record of
+// connection logs
+func runAnnotate(args []string) error {
+	flags := flag.NewFlagSet(annotateCommandName, flag.ExitOnError)
+	var (
+		flagBucketName  = flags.String("bucket-name", "", "GCS bucket name to read published networks from. Shorthand for --destination gs://<bucket name>")
+		flagDestination = flags.String("destination", "", "URL of the storage to read published networks from. EX: gs://<bucket name>, s3://<bucket name>, file://<directory>")
+		flagNetworks    = flags.String("networks", latestSnapshot, fmt.Sprintf(
+			"Networks to annotate with. Either a local networks file, a run folder of the destination, %q or %q",
+			latestSnapshot, previousSnapshot))
+		flagFormat = flags.String("format", vpcFlowLogsFormat, fmt.Sprintf("Format of the logs, %q, %q or %q",
+			vpcFlowLogsFormat, csvFormat, jsonLinesFormat))
+		flagFields = flags.String("fields", "srcaddr,dstaddr", "Comma separated list of the fields holding IP addresses. "+
+			"Column names for CSV, or 0-based column indices with --csv-header=false. Dot separated paths for JSON lines. "+
+			"VPC Flow Logs always annotate srcaddr and dstaddr")
+		flagCSVHeader = flags.Bool("csv-header", true, "Whether the first row of CSV logs is a header")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] [<log file>...]\n\n", os.Args[0], annotateCommandName)
+		fmt.Fprintf(flags.Output(), "Adds the provider, region and service of the longest published prefix "+
+			"containing each IP address to every record, and writes the records to stdout. Reads stdin if no log "+
+			"file is given. Files ending with .gz are decompressed.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	fields := strings.Split(*flagFields, ",")
+	var annotateLogs func(a *annotator, r io.Reader, w io.Writer) error
+	switch *flagFormat {
+	case vpcFlowLogsFormat:
+		annotateLogs = annotateVPCFlowLogs
+	case csvFormat:
+		annotateLogs = func(a *annotator, r io.Reader, w io.Writer) error {
+			return annotateCSV(a, r, w, fields, *flagCSVHeader)
+		}
+	case jsonLinesFormat:
+		annotateLogs = func(a *annotator, r io.Reader, w io.Writer) error {
+			return annotateJSONLines(a, r, w, fields)
+		}
+	default:
+		return errors.Errorf("unknown log format %q", *flagFormat)
+	}
+	store, err := newStore(*flagBucketName, *flagDestination)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	networks, err := loadSnapshot(ctx, store, *flagNetworks)
+	if err != nil {
+		return err
+	}
+	index, err := netindex.New(networks)
+	if err != nil {
+		return err
+	}
+	a := &annotator{index: index}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if flags.NArg() == 0 {
+		return annotateLogs(a, bufio.NewReader(os.Stdin), out)
+	}
+	for _, path := range flags.Args() {
+		if err := annotateFile(path, func(r io.Reader) error { return annotateLogs(a, r, out) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotateFile calls annotateLogs with the content of the file, decompressed if it is gzipped
+func annotateFile(path string, annotateLogs func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decompress %s", path)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return errors.Wrapf(annotateLogs(r), "failed to annotate %s", path)
+}
+
+// annotator looks up the annotations of IP addresses
+type annotator struct {
+	index *netindex.Index
+}
+
+// annotate returns the annotation of the value. It is empty if the value is not an
+// IP address, or no published prefix contains it.
+func (a *annotator) annotate(value string) annotation {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return annotation{}
+	}
+	matches := a.index.Lookup(addr)
+	if len(matches) == 0 {
+		return annotation{}
+	}
+	providers := make([]string, 0, len(matches))
+	regions := make([]string, 0, len(matches))
+	services := make([]string, 0, len(matches))
+	for i, match := range matches {
+		// Matches are sorted, so identical ones are next to each other
+		if i > 0 && match.Provider == matches[i-1].Provider && match.Region == matches[i-1].Region &&
+			match.Service == matches[i-1].Service {
+			continue
+		}
+		providers = append(providers, match.Provider)
+		regions = append(regions, match.Region)
+		services = append(services, match.Service)
+	}
+	return annotation{
+		provider: strings.Join(providers, annotationSeparator),
+		region:   strings.Join(regions, annotationSeparator),
+		service:  strings.Join(services, annotationSeparator),
+	}
+}
+
+// annotateVPCFlowLogs appends the provider, region and service of srcaddr and dstaddr to
+// every record of VPC Flow Logs. The positions of the fields are read from the header, if
+// any, to support custom formats. Fields without value are written as "-".
+func annotateVPCFlowLogs(a *annotator, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	positions := fieldPositions(vpcFlowLogsFields)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if first && isVPCFlowLogsHeader(fields) {
+			positions = fieldPositions(fields)
+			if _, err := fmt.Fprintf(w, "%s %s\n", line, strings.Join([]string{
+				"src-provider", "src-region", "src-service", "dst-provider", "dst-region", "dst-service",
+			}, " ")); err != nil {
+				return err
+			}
+			first = false
+			continue
+		}
+		first = false
+		if len(fields) == 0 {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		values := make([]string, 0, 6)
+		for _, field := range []string{"srcaddr", "dstaddr"} {
+			var value string
+			if i, ok := positions[field]; ok && i < len(fields) {
+				value = fields[i]
+			}
+			annotation := a.annotate(value)
+			if annotation.isEmpty() {
+				values = append(values, vpcFlowLogsNoData, vpcFlowLogsNoData, vpcFlowLogsNoData)
+				continue
+			}
+			// Values of VPC Flow Logs cannot contain spaces
+			values = append(values,
+				strings.ReplaceAll(annotation.provider, " ", "_"),
+				strings.ReplaceAll(annotation.region, " ", "_"),
+				strings.ReplaceAll(annotation.service, " ", "_"))
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", line, strings.Join(values, " ")); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "failed to read VPC Flow Logs")
+}
+
+// isVPCFlowLogsHeader returns true if the fields are the names of the fields of VPC Flow Logs
+func isVPCFlowLogsHeader(fields []string) bool {
+	for _, field := range fields {
+		if field == "srcaddr" || field == "dstaddr" {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldPositions(fields []string) map[string]int {
+	positions := make(map[string]int, len(fields))
+	for i, field := range fields {
+		positions[field] = i
+	}
+	return positions
+}
+
+// annotateCSV appends the provider, region and service of every column of ipColumns to
+// every row. Columns are given by name if the CSV has a header, by 0-based index otherwise.
+func annotateCSV(a *annotator, r io.Reader, w io.Writer, ipColumns []string, hasHeader bool) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	writer := csv.NewWriter(w)
+
+	var positions []int
+	if hasHeader {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read CSV header")
+		}
+		headerPositions := fieldPositions(header)
+		annotatedHeader := append([]string(nil), header...)
+		for _, column := range ipColumns {
+			position, ok := headerPositions[column]
+			if !ok {
+				return errors.Errorf("column %q not found in CSV header", column)
+			}
+			positions = append(positions, position)
+			annotatedHeader = append(annotatedHeader, column+"_provider", column+"_region", column+"_service")
+		}
+		if err := writer.Write(annotatedHeader); err != nil {
+			return err
+		}
+	} else {
+		for _, column := range ipColumns {
+			position, err := strconv.Atoi(column)
+			if err != nil || position < 0 {
+				return errors.Errorf("invalid column index %q. Columns are given by index without a CSV header", column)
+			}
+			positions = append(positions, position)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read CSV")
+		}
+		for _, position := range positions {
+			var value string
+			if position < len(record) {
+				value = record[position]
+			}
+			annotation := a.annotate(value)
+			record = append(record, annotation.provider, annotation.region, annotation.service)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// annotateJSONLines adds the provider, region and service of every field of ipFields to
+// every JSON object, next to the field as <field>_provider, <field>_region and
+// <field>_service. Fields are given by dot separated paths. Nothing is added for IP
+// addresses no published prefix contains. Objects are written compacted, with their
+// fields in their original order.
+func annotateJSONLines(a *annotator, r io.Reader, w io.Writer, ipFields []string) error {
+	paths := make([][]string, 0, len(ipFields))
+	for _, field := range ipFields {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read JSON lines")
+		}
+		var record bytes.Buffer
+		if err := json.Compact(&record, raw); err != nil {
+			return errors.Wrap(err, "failed to read JSON lines")
+		}
+		annotated, err := annotateJSONObject(a, record.Bytes(), paths)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(annotated, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// annotateJSONObject inserts the annotations of the fields of the paths right after
+// the fields, leaving the rest of the object as it is
+func annotateJSONObject(a *annotator, object []byte, paths [][]string) ([]byte, error) {
+	// Insertions are made from the end of the object, so that the offsets of the
+	// fields before them are still valid
+	type insertion struct {
+		offset int
+		fields []byte
+	}
+	var insertions []insertion
+	for _, path := range paths {
+		offset, value, err := lookupJSONPath(object, path)
+		if err != nil {
+			return nil, err
+		}
+		annotation := a.annotate(value)
+		if offset < 0 || annotation.isEmpty() {
+			continue
+		}
+		name := path[len(path)-1]
+		var fields []byte
+		for _, field := range []struct{ name, value string }{
+			{name + "_provider", annotation.provider},
+			{name + "_region", annotation.region},
+			{name + "_service", annotation.service},
+		} {
+			nameData, _ := json.Marshal(field.name)
+			valueData, _ := json.Marshal(field.value)
+			fields = append(fields, ',')
+			fields = append(fields, nameData...)
+			fields = append(fields, ':')
+			fields = append(fields, valueData...)
+		}
+		insertions = append(insertions, insertion{offset: offset, fields: fields})
+	}
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return insertions[i].offset > insertions[j].offset
+	})
+	for _, insertion := range insertions {
+		annotated := make([]byte, 0, len(object)+len(insertion.fields))
+		annotated = append(annotated, object[:insertion.offset]...)
+		annotated = append(annotated, insertion.fields...)
+		object = append(annotated, object[insertion.offset:]...)
+	}
+	return object, nil
+}
+
+// lookupJSONPath returns the offset right after the value of the field of the path in
+// the JSON object, and the value if it is a string. The offset is -1 if the object has
+// no such field.
+func lookupJSONPath(object []byte, path []string) (int, string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return -1, "", errors.Errorf("JSON line is not an object: %s", object)
+	}
+	for i, name := range path {
+		found := false
+		for !found && decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return -1, "", errors.Wrap(err, "failed to read JSON lines")
+			}
+			if token != name {
+				var skipped json.RawMessage
+				if err := decoder.Decode(&skipped); err != nil {
+					return -1, "", errors.Wrap(err, "failed to read JSON lines")
+				}
+				continue
+			}
+			found = true
+		}
+		if !found {
+			return -1, "", nil
+		}
+		if i < len(path)-1 {
+			// Descend into the object of the field
+			if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+				return -1, "", nil
+			}
+			continue
+		}
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return -1, "", errors.Wrap(err, "failed to read JSON lines")
+		}
+		str, _ := value.(string)
+		return int(decoder.InputOffset()), str, nil
+	}
+	return -1, "", nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/netindex"
+	"github.com/stretchr/testify/require"
+)
+
+func newAnnotatorForTest(t *testing.T) *annotator {
+	index, err := netindex.New(newLookupNetworks(t))
+	require.Nil(t, err)
+	return &annotator{index: index}
+}
+
+func TestAnnotate(t *testing.T) {
+	a := newAnnotatorForTest(t)
+	require.Equal(t, annotation{provider: "Google", region: "global", service: "Google Cloud"}, a.annotate("34.120.5.9"))
+	require.Equal(t, annotation{provider: "Amazon|Amazon", region: "us-east-1|us-east-1", service: "AMAZON|EC2"}, a.annotate("34.80.1.2"))
+	require.Equal(t, annotation{provider: "Amazon", region: "us-west-1", service: "S3"}, a.annotate("2600:1f15::1"))
+	require.True(t, a.annotate("10.0.0.1").isEmpty())
+	require.True(t, a.annotate("-").isEmpty())
+}
+
+func TestAnnotateSeveralProviders(t *testing.T) {
+	cloudflare := common.NewProviderNetworkRanges("Cloudflare")
+	require.Nil(t, cloudflare.AddIPPrefix("global", "cdn", "198.41.128.0/17", common.GetDefaultRegionServicePairRedundancyCheck()))
+	partner := common.NewProviderNetworkRanges("Partner")
+	require.Nil(t, partner.AddIPPrefix("us", "vpn", "198.41.128.0/17", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, partner.AddIPPrefix("eu", "vpn", "198.41.128.0/17", common.GetDefaultRegionServicePairRedundancyCheck()))
+	index, err := netindex.New(newNetworks(cloudflare, partner))
+	require.Nil(t, err)
+	a := &annotator{index: index}
+
+	// The values of every match are at the same position
+	require.Equal(t, annotation{
+		provider: "Cloudflare|Partner|Partner",
+		region:   "global|eu|us",
+		service:  "cdn|vpn|vpn",
+	}, a.annotate("198.41.128.1"))
+}
+
+func TestAnnotateVPCFlowLogs(t *testing.T) {
+	a := newAnnotatorForTest(t)
+	input := strings.Join([]string{
+		"version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status",
+		"2 123456789010 eni-1235b8ca123456789 10.0.1.5 34.120.5.9 49761 443 6 20 4249 1418530010 1418530070 ACCEPT OK",
+		"2 123456789010 eni-1235b8ca123456789 - - - - - - - 1431280876 1431280934 - NODATA",
+		"",
+	}, "\n")
+	var out strings.Builder
+	require.Nil(t, annotateVPCFlowLogs(a, strings.NewReader(input), &out))
+	require.Equal(t, strings.Join([]string{
+		"version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status " +
+			"src-provider src-region src-service dst-provider dst-region dst-service",
+		"2 123456789010 eni-1235b8ca123456789 10.0.1.5 34.120.5.9 49761 443 6 20 4249 1418530010 1418530070 ACCEPT OK " +
+			"- - - Google global Google_Cloud",
+		"2 123456789010 eni-1235b8ca123456789 - - - - - - - 1431280876 1431280934 - NODATA - - - - - -",
+		"",
+	}, "\n"), out.String())
+
+	// Custom formats are read from the header
+	out.Reset()
+	input = "dstaddr srcaddr\n10.0.1.5 2600:1f15::1\n"
+	require.Nil(t, annotateVPCFlowLogs(a, strings.NewReader(input), &out))
+	require.Equal(t, "dstaddr srcaddr src-provider src-region src-service dst-provider dst-region dst-service\n"+
+		"10.0.1.5 2600:1f15::1 Amazon us-west-1 S3 - - -\n", out.String())
+
+	// The default format is assumed without a header
+	out.Reset()
+	input = "2 123456789010 eni-1235b8ca123456789 34.80.1.2 10.0.1.5 443 49761 6 20 4249 1418530010 1418530070 ACCEPT OK\n"
+	require.Nil(t, annotateVPCFlowLogs(a, strings.NewReader(input), &out))
+	require.Equal(t, strings.TrimSuffix(input, "\n")+" Amazon|Amazon us-east-1|us-east-1 AMAZON|EC2 - - -\n", out.String())
+}
+
+func TestAnnotateCSV(t *testing.T) {
+	a := newAnnotatorForTest(t)
+	input := "time,src,dst\n2020-10-22T01:02:03Z,10.0.1.5,34.120.5.9\n2020-10-22T01:02:04Z,34.80.1.2\n"
+	var out strings.Builder
+	require.Nil(t, annotateCSV(a, strings.NewReader(input), &out, []string{"src", "dst"}, true))
+	require.Equal(t, strings.Join([]string{
+		"time,src,dst,src_provider,src_region,src_service,dst_provider,dst_region,dst_service",
+		"2020-10-22T01:02:03Z,10.0.1.5,34.120.5.9,,,,Google,global,Google Cloud",
+		"2020-10-22T01:02:04Z,34.80.1.2,Amazon|Amazon,us-east-1|us-east-1,AMAZON|EC2,,,",
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	require.Nil(t, annotateCSV(a, strings.NewReader("10.0.1.5,34.120.5.9\n"), &out, []string{"1"}, false))
+	require.Equal(t, "10.0.1.5,34.120.5.9,Google,global,Google Cloud\n", out.String())
+
+	require.Error(t, annotateCSV(a, strings.NewReader(input), &out, []string{"source"}, true))
+	require.Error(t, annotateCSV(a, strings.NewReader(input), &out, []string{"src"}, false))
+}
+
+func TestAnnotateJSONLines(t *testing.T) {
+	a := newAnnotatorForTest(t)
+	input := strings.Join([]string{
+		`{"bytes": 4249, "source": {"ip": "10.0.1.5"}, "destination": {"ip": "34.120.5.9"}}`,
+		`{"bytes": 12, "source": {"ip": "2600:1f15::1"}, "destination": "unknown"}`,
+	}, "\n")
+	var out strings.Builder
+	require.Nil(t, annotateJSONLines(a, strings.NewReader(input), &out, []string{"source.ip", "destination.ip"}))
+	// Fields keep their order, and the annotations follow the annotated fields
+	require.Equal(t, strings.Join([]string{
+		`{"bytes":4249,"source":{"ip":"10.0.1.5"},"destination":{"ip":"34.120.5.9",` +
+			`"ip_provider":"Google","ip_region":"global","ip_service":"Google Cloud"}}`,
+		`{"bytes":12,"source":{"ip":"2600:1f15::1","ip_provider":"Amazon","ip_region":"us-west-1","ip_service":"S3"},` +
+			`"destination":"unknown"}`,
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	input = `{"z": 1, "src": "34.80.1.2", "a": {"b": [1, 2]}, "dst": "34.120.5.9", "m": 1.50}`
+	require.Nil(t, annotateJSONLines(a, strings.NewReader(input), &out, []string{"dst", "src", "a.b"}))
+	require.Equal(t, `{"z":1,"src":"34.80.1.2","src_provider":"Amazon|Amazon","src_region":"us-east-1|us-east-1","src_service":"AMAZON|EC2",`+
+		`"a":{"b":[1,2]},"dst":"34.120.5.9","dst_provider":"Google","dst_region":"global","dst_service":"Google Cloud",`+
+		`"m":1.50}`+"\n", out.String())
+
+	require.Error(t, annotateJSONLines(a, strings.NewReader("{not json"), &out, []string{"ip"}))
+	require.Error(t, annotateJSONLines(a, strings.NewReader(`["10.0.1.5"]`), &out, []string{"ip"}))
+}
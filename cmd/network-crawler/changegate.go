@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+)
+
+// changeGate limits how much the networks of a provider can change from one published
+// run to the next. Unlike the minimum number of prefixes of every crawler, it catches an
+// upstream that suddenly drops or adds a large share of its ranges.
+type changeGate struct {
+	// maxChangePercent is the percentage of prefixes a provider, region or service can gain
+	// or lose since the latest published networks. 0 disables the gate.
+	maxChangePercent float64
+	// minPrefixes is the number of previously published prefixes below which a region or
+	// service is not checked, since small ones routinely change by large ratios
+	minPrefixes int
+	// allowedProviders are the providers whose changes are not checked
+	allowedProviders []common.Provider
+}
+
+// checkChangeRatio returns an error if a provider, region or service gained or lost more
+// prefixes than allowed by the gate since the previous networks. Providers, regions and
+// services that were not in the previous networks are not checked. Prefixes that moved
+// between the regions or services of a provider are neither gained nor lost, so that
+// reshuffles such as Azure reassigning prefixes to other regions do not trip the gate.
+func checkChangeRatio(previous, current *common.ExternalNetworkSources, gate changeGate) error {
+	if previous == nil || gate.maxChangePercent <= 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(gate.allowedProviders))
+	for _, provider := range gate.allowedProviders {
+		allowed[provider.String()] = true
+	}
+	changes := diff.Compute(previous, current)
+	// Otherwise moves count as removed from and added to regions and services
+	changes.Moved = nil
+	for _, change := range changes.ScopeChanges() {
+		if allowed[change.Provider] || change.Previous == 0 {
+			continue
+		}
+		// Whole providers are always checked
+		if change.Region != "" && change.Previous < gate.minPrefixes {
+			continue
+		}
+		maxChange := float64(change.Previous) * gate.maxChangePercent / 100
+		if float64(change.Added) > maxChange || float64(change.Removed) > maxChange {
+			return common.ChangeRatioExceededError(
+				change.Scope.String(), change.Previous, change.Added, change.Removed, gate.maxChangePercent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// newProviderWithPrefixes returns a provider with a single region, and a service with
+// the given number of prefixes for each of the numbers of prefixes
+func newProviderWithPrefixes(t *testing.T, providerName string, numPrefixesPerService ...int) *common.ProviderNetworkRanges {
+	provider := common.NewProviderNetworkRanges(providerName)
+	for i, numPrefixes := range numPrefixesPerService {
+		service := fmt.Sprintf("service%d", i+1)
+		for j := 0; j < numPrefixes; j++ {
+			ipPrefix := fmt.Sprintf("20.%d.%d.0/24", i, j)
+			require.Nil(t, provider.AddIPPrefix("region", service, ipPrefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+		}
+	}
+	return provider
+}
+
+func newNetworks(providers ...*common.ProviderNetworkRanges) *common.ExternalNetworkSources {
+	return &common.ExternalNetworkSources{ProviderNetworks: providers}
+}
+
+func TestCheckChangeRatio(t *testing.T) {
+	gate := changeGate{maxChangePercent: 50, minPrefixes: 20}
+	previous := newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100, 100),
+		newProviderWithPrefixes(t, "Google", 10))
+
+	// Nothing published yet
+	require.Nil(t, checkChangeRatio(nil, previous, gate))
+	require.Nil(t, checkChangeRatio(previous, previous, gate))
+
+	// Losing half of a service's prefixes is within the limit
+	current := newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100, 50),
+		newProviderWithPrefixes(t, "Google", 10))
+	require.Nil(t, checkChangeRatio(previous, current, gate))
+
+	// Losing a whole service is not
+	current = newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100),
+		newProviderWithPrefixes(t, "Google", 10))
+	err := checkChangeRatio(previous, current, gate)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service service2 in region region of provider Amazon")
+
+	// Unless the provider is explicitly allowed to change
+	allowingGate := gate
+	allowingGate.allowedProviders = []common.Provider{common.Amazon}
+	require.Nil(t, checkChangeRatio(previous, current, allowingGate))
+
+	// Or the gate is disabled
+	require.Nil(t, checkChangeRatio(previous, current, changeGate{}))
+
+	// Small services are not checked, but whole providers are checked regardless of their size
+	current = newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100, 100),
+		newProviderWithPrefixes(t, "Google", 30))
+	err = checkChangeRatio(previous, current, gate)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "provider Google changed")
+
+	// Moving prefixes to another region is neither a gain nor a loss
+	current = newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100, 100),
+		newProviderWithPrefixes(t, "Google", 10))
+	require.Nil(t, current.ProviderNetworks[0].MoveService("region", "service1", "region2", "service1"))
+	require.Nil(t, current.ProviderNetworks[0].MoveService("region", "service2", "region2", "service2"))
+	require.Nil(t, checkChangeRatio(previous, current, gate))
+
+	// New providers are not checked
+	current = newNetworks(
+		newProviderWithPrefixes(t, "Amazon", 100, 100),
+		newProviderWithPrefixes(t, "Google", 10),
+		newProviderWithPrefixes(t, "Oracle", 10))
+	require.Nil(t, checkChangeRatio(previous, current, gate))
+}
+
+func TestPublishChangeGateWhenSummarizationChanges(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	crawlers := func(numPrefixes int) []common.NetworkCrawler {
+		return []common.NetworkCrawler{
+			&fakeCrawler{provider: common.Google, networks: newProviderWithPrefixes(t, common.Google.String(), numPrefixes)},
+		}
+	}
+	opts := publishOptions{
+		maxConcurrentCrawlers: 1,
+		crawlerTimeout:        time.Minute,
+		overlapPolicy:         overlapPolicyWarn,
+		bogonOptions:          common.BogonOptions{Policy: common.BogonPolicyDrop},
+		changeGate:            changeGate{maxChangePercent: 50},
+	}
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers(4), opts))
+	latestRun, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.False(t, latestRun.summarized)
+
+	// Summarizing folds the 4 prefixes into a single one, which is not a change of the networks
+	opts.summarize = true
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers(4), opts))
+	latestRun, err = readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.True(t, latestRun.summarized)
+	require.Equal(t, []string{"20.0.0.0/22"}, latestRun.networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+
+	// Runs summarized as the latest run are checked
+	err = publishExternalNetworks(ctx, store, crawlers(8), opts)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "changed more than 50%")
+
+	// Turning summarization off is not checked either
+	opts.summarize = false
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers(4), opts))
+	latestRun, err = readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.False(t, latestRun.summarized)
+	require.Len(t, latestRun.networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes, 4)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+type crawlResult struct {
+	index    int
+	networks *common.ProviderNetworkRanges
+	err      error
+}
+
+// crawlProviders crawls all the providers concurrently, with at most maxConcurrency
+// crawlers running at the same time. Each crawler has timeout to finish crawling.
+// Results are returned in the same order as crawlers regardless of which crawler
+// finished first. Returns on the first error encountered, in which case all the
+// other crawlers are cancelled.
+func crawlProviders(
+	ctx context.Context,
+	crawlers []common.NetworkCrawler,
+	maxConcurrency int,
+	timeout time.Duration,
+) ([]*common.ProviderNetworkRanges, error) {
+	if maxConcurrency <= 0 {
+		maxConcurrency = len(crawlers)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Buffered so that crawlers finishing after an early return do not block forever
+	results := make(chan crawlResult, len(crawlers))
+	semaphore := make(chan struct{}, maxConcurrency)
+	for i, crawler := range crawlers {
+		go func(index int, crawler common.NetworkCrawler) {
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			log.Printf("Crawing from provider %s...", crawler.GetHumanReadableProviderName())
+			networks, err := crawlWithTimeout(ctx, crawler, timeout)
+			if err != nil {
+				log.Printf("Failed to crawl networks for %s: %v", crawler.GetHumanReadableProviderName(), err)
+			} else {
+				log.Printf("Successfully crawled provider %s", crawler.GetHumanReadableProviderName())
+			}
+			results <- crawlResult{index: index, networks: networks, err: err}
+		}(i, crawler)
+	}
+
+	allNetworks := make([]*common.ProviderNetworkRanges, len(crawlers))
+	for range crawlers {
+		result := <-results
+		if result.err != nil {
+			// Hard stop to make the info stored in bucket absolutely correct
+			return nil, result.err
+		}
+		allNetworks[result.index] = result.networks
+	}
+	return allNetworks, nil
+}
+
+// crawlWithTimeout returns an error if the crawler does not finish within timeout
+func crawlWithTimeout(
+	ctx context.Context,
+	crawler common.NetworkCrawler,
+	timeout time.Duration,
+) (*common.ProviderNetworkRanges, error) {
+	if err := ctx.Err(); err != nil {
+		// Another crawler failed or the run has been cancelled while waiting for our turn
+		return nil, err
+	}
+	if timeout <= 0 {
+		return crawler.CrawlPublicNetworkRanges(ctx)
+	}
+
+	crawlerCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	networks, err := crawler.CrawlPublicNetworkRanges(crawlerCtx)
+	if err != nil && ctx.Err() == nil && crawlerCtx.Err() == context.DeadlineExceeded {
+		return nil, common.CrawlerTimeoutError(crawler.GetHumanReadableProviderName(), timeout)
+	}
+	return networks, err
+}
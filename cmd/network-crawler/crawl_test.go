@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCrawler struct {
+	provider common.Provider
+	delay    time.Duration
+	err      error
+	// networks are the crawled networks. A provider without networks is crawled if nil.
+	networks *common.ProviderNetworkRanges
+	// numRequired is the number of prefixes required. One prefix is required if zero.
+	numRequired int
+
+	running    *int32
+	maxRunning *int32
+}
+
+func (c *fakeCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	if c.running != nil {
+		running := atomic.AddInt32(c.running, 1)
+		defer atomic.AddInt32(c.running, -1)
+		for {
+			maxRunning := atomic.LoadInt32(c.maxRunning)
+			if running <= maxRunning || atomic.CompareAndSwapInt32(c.maxRunning, maxRunning, running) {
+				break
+			}
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(c.delay):
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.networks != nil {
+		return c.networks, nil
+	}
+	return common.NewProviderNetworkRanges(c.provider.String()), nil
+}
+
+func (c *fakeCrawler) GetHumanReadableProviderName() string {
+	return c.provider.String()
+}
+
+func (c *fakeCrawler) GetProviderKey() common.Provider {
+	return c.provider
+}
+
+func (c *fakeCrawler) GetNumRequiredIPPrefixes() int {
+	if c.numRequired > 0 {
+		return c.numRequired
+	}
+	return 1
+}
+
+func TestCrawlProviders(t *testing.T) {
+	var running, maxRunning int32
+	crawlers := []common.NetworkCrawler{
+		&fakeCrawler{provider: common.Google, delay: 40 * time.Millisecond, running: &running, maxRunning: &maxRunning},
+		&fakeCrawler{provider: common.Azure, delay: 10 * time.Millisecond, running: &running, maxRunning: &maxRunning},
+		&fakeCrawler{provider: common.Amazon, delay: 30 * time.Millisecond, running: &running, maxRunning: &maxRunning},
+		&fakeCrawler{provider: common.Oracle, delay: 0, running: &running, maxRunning: &maxRunning},
+		&fakeCrawler{provider: common.Cloudflare, delay: 20 * time.Millisecond, running: &running, maxRunning: &maxRunning},
+	}
+
+	networks, err := crawlProviders(context.Background(), crawlers, 2, time.Minute)
+	require.Nil(t, err)
+	require.Len(t, networks, len(crawlers))
+	// Results should be in the order of crawlers, not in the order they finished
+	for i, crawler := range crawlers {
+		require.Equal(t, crawler.GetProviderKey().String(), networks[i].ProviderName)
+	}
+	require.LessOrEqual(t, maxRunning, int32(2))
+	require.Equal(t, int32(0), atomic.LoadInt32(&running))
+}
+
+func TestCrawlProvidersFailures(t *testing.T) {
+	crawlErr := errors.New("crawl failed")
+	crawlers := []common.NetworkCrawler{
+		&fakeCrawler{provider: common.Google},
+		&fakeCrawler{provider: common.Azure, err: crawlErr},
+	}
+	_, err := crawlProviders(context.Background(), crawlers, 0, time.Minute)
+	require.Equal(t, crawlErr, err)
+
+	crawlers = []common.NetworkCrawler{
+		&fakeCrawler{provider: common.Google},
+		&fakeCrawler{provider: common.Azure, delay: time.Second},
+	}
+	_, err = crawlProviders(context.Background(), crawlers, 0, 10*time.Millisecond)
+	require.NotNil(t, err)
+	require.Equal(t, common.CrawlerTimeoutError(common.Azure.String(), 10*time.Millisecond).Error(), err.Error())
+}
+
+func TestCrawlProvidersCancellation(t *testing.T) {
+	crawlers := []common.NetworkCrawler{
+		&fakeCrawler{provider: common.Google, delay: time.Minute},
+		&fakeCrawler{provider: common.Azure, delay: time.Minute},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := crawlProviders(ctx, crawlers, 1, time.Hour)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Less(t, time.Since(start), time.Minute)
+}
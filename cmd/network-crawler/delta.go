@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/delta"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+)
+
+// readDeltaBaseRuns returns the runs to publish deltas from: the latest published run
+// followed by the runs published before it, up to numRuns runs in total. Older runs
+// that cannot be read are skipped, since consumers can always fall back to the full
+// networks.
+func readDeltaBaseRuns(
+	ctx context.Context,
+	store storage.Store,
+	latestRun *publishedRun,
+	numRuns int,
+) ([]*publishedRun, error) {
+	if latestRun == nil || numRuns <= 0 {
+		return nil, nil
+	}
+	baseRuns := []*publishedRun{latestRun}
+	if numRuns == 1 {
+		return baseRuns, nil
+	}
+
+	runFolders, err := listRunFolders(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(runFolders) - 1; i >= 0 && len(baseRuns) < numRuns; i-- {
+		runFolder := runFolders[i]
+		if runFolder >= latestRun.runFolder {
+			continue
+		}
+		networks, err := readRunNetworks(ctx, store, runFolder)
+		if err != nil {
+			log.Print(color.YellowString("Not publishing a delta from run %s: %v", runFolder, err))
+			continue
+		}
+		baseRuns = append(baseRuns, &publishedRun{runFolder: runFolder, networks: networks})
+	}
+	return baseRuns, nil
+}
+
+// buildDeltas returns the delta files from the networks of each of the base runs to
+// the networks of this run, by file name
+func buildDeltas(
+	networks *common.ExternalNetworkSources,
+	networkFilesPrefix string,
+	baseRuns []*publishedRun,
+) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(baseRuns))
+	for _, baseRun := range baseRuns {
+		d, err := delta.New(baseRun.runFolder, baseRun.networks, networkFilesPrefix, networks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compute delta from run %s", baseRun.runFolder)
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to marshal delta from run %s", baseRun.runFolder)
+		}
+		files[delta.FileName(baseRun.runFolder)] = data
+	}
+	return files, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+)
+
+const diffCommandName = "diff"
+
+const (
+	// latestSnapshot refers to the latest published networks
+	latestSnapshot = "latest"
+	// previousSnapshot refers to the networks published before the latest ones
+	previousSnapshot = "previous"
+)
+
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+// runDiff compares two networks snapshots and prints the prefixes added, removed and
+// moved between them.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet(diffCommandName, flag.ExitOnError)
+	var (
+		flagBucketName  = flags.String("bucket-name", "", "GCS bucket name to read published networks from. Shorthand for --destination gs://<bucket name>")
+		flagDestination = flags.String("destination", "", "URL of the storage to read published networks from. EX: gs://<bucket name>, s3://<bucket name>, file://<directory>")
+		flagOutput      = flags.String("output", textOutput, fmt.Sprintf("Output format, %q or %q", textOutput, jsonOutput))
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] <old snapshot> <new snapshot>\n\n", os.Args[0], diffCommandName)
+		fmt.Fprintf(flags.Output(), "A snapshot is either a local networks file, a run folder of the destination, "+
+			"%q for the latest published networks or %q for the networks published before them.\n\n",
+			latestSnapshot, previousSnapshot)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.Errorf("expected 2 snapshots to compare, got %d", flags.NArg())
+	}
+	if *flagOutput != textOutput && *flagOutput != jsonOutput {
+		return errors.Errorf("unknown output format %q", *flagOutput)
+	}
+	store, err := newStore(*flagBucketName, *flagDestination)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	previous, err := loadSnapshot(ctx, store, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	current, err := loadSnapshot(ctx, store, flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	d := diff.Compute(previous, current)
+	if *flagOutput == jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff.NewChangelog(flags.Arg(0), flags.Arg(1), d))
+	}
+	return writeDiffText(os.Stdout, d)
+}
+
+// loadSnapshot returns the networks of a local networks file, a run folder of the
+// store, or the latest or previous published networks
+func loadSnapshot(ctx context.Context, store storage.Store, snapshot string) (*common.ExternalNetworkSources, error) {
+	if info, err := os.Stat(snapshot); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(snapshot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", snapshot)
+		}
+		var networks common.ExternalNetworkSources
+		if err := json.Unmarshal(data, &networks); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s", snapshot)
+		}
+		return &networks, nil
+	}
+
+	if store == nil {
+		return nil, errors.Errorf(
+			"%s is not a local file. A destination is required to read published networks", snapshot)
+	}
+	var runFolder string
+	switch snapshot {
+	case latestSnapshot:
+		var err error
+		runFolder, err = readLatestRunFolder(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+	case previousSnapshot:
+		var err error
+		runFolder, err = readPreviousRunFolder(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		runFolder = snapshot
+		if !strings.HasPrefix(runFolder, common.MasterBucketPrefix+"/") {
+			runFolder = getObjectPrefix(runFolder)
+		}
+	}
+	if runFolder == "" {
+		return nil, errors.Errorf("no %s published networks found in %s", snapshot, store)
+	}
+	return readRunNetworks(ctx, store, runFolder)
+}
+
+// writeDiffText writes a human readable summary of the diff, followed by the prefixes
+// added, removed and moved for every provider
+func writeDiffText(w io.Writer, d *diff.Diff) error {
+	added := make(map[string][]*diff.Entry)
+	for _, entry := range d.Added {
+		added[entry.Provider] = append(added[entry.Provider], entry)
+	}
+	removed := make(map[string][]*diff.Entry)
+	for _, entry := range d.Removed {
+		removed[entry.Provider] = append(removed[entry.Provider], entry)
+	}
+	moved := make(map[string][]*diff.Move)
+	for _, move := range d.Moved {
+		moved[move.Provider] = append(moved[move.Provider], move)
+	}
+
+	var b strings.Builder
+	var totalAdded, totalRemoved int
+	for _, change := range d.ProviderChanges() {
+		provider := change.Provider
+		totalAdded += change.Added
+		totalRemoved += change.Removed
+		fmt.Fprintf(&b, "%s: %d prefixes before, %d after. %d added, %d removed, %d moved\n",
+			provider, change.Previous, change.Current, change.Added, change.Removed, change.Moved)
+		for _, entry := range added[provider] {
+			fmt.Fprintf(&b, "  + %s (%s/%s)\n", entry.Prefix, entry.Region, entry.Service)
+		}
+		for _, entry := range removed[provider] {
+			fmt.Fprintf(&b, "  - %s (%s/%s)\n", entry.Prefix, entry.Region, entry.Service)
+		}
+		for _, move := range moved[provider] {
+			fmt.Fprintf(&b, "  ~ %s (%s -> %s)\n", move.Prefix, joinLocations(move.From), joinLocations(move.To))
+		}
+	}
+	fmt.Fprintf(&b, "Total: %d added, %d removed, %d moved\n", totalAdded, totalRemoved, len(d.Moved))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func joinLocations(locations []diff.Location) string {
+	if len(locations) == 0 {
+		return "none"
+	}
+	strs := make([]string, 0, len(locations))
+	for _, location := range locations {
+		strs = append(strs, location.String())
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// putRun publishes the networks in the run folder, and points the latest prefix file to it
+func putRun(t *testing.T, store storage.Store, runFolder string, networks *common.ExternalNetworkSources) {
+	ctx := context.Background()
+	data, err := json.Marshal(networks)
+	require.Nil(t, err)
+	require.Nil(t, store.Put(ctx, storage.JoinPath(runFolder, common.NetworkFileName), data))
+	require.Nil(t, store.Put(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName), []byte(runFolder)))
+}
+
+// requireSameNetworks compares networks as published, ignoring the internal state of decoded networks
+func requireSameNetworks(t *testing.T, expected, actual *common.ExternalNetworkSources) {
+	expectedData, err := json.Marshal(expected)
+	require.Nil(t, err)
+	actualData, err := json.Marshal(actual)
+	require.Nil(t, err)
+	require.JSONEq(t, string(expectedData), string(actualData))
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	first := newNetworks(newProviderWithPrefixes(t, "Amazon", 1))
+	second := newNetworks(newProviderWithPrefixes(t, "Amazon", 2))
+	third := newNetworks(newProviderWithPrefixes(t, "Amazon", 3))
+
+	_, err := loadSnapshot(ctx, store, latestSnapshot)
+	require.Error(t, err)
+
+	putRun(t, store, getObjectPrefix("2020-10-20 00-00-00__1"), first)
+	_, err = loadSnapshot(ctx, store, previousSnapshot)
+	require.Error(t, err)
+	putRun(t, store, getObjectPrefix("2020-10-21 00-00-00__2"), second)
+	putRun(t, store, getObjectPrefix("2020-10-22 00-00-00__3"), third)
+
+	loaded, err := loadSnapshot(ctx, store, latestSnapshot)
+	require.Nil(t, err)
+	requireSameNetworks(t, third, loaded)
+	loaded, err = loadSnapshot(ctx, store, previousSnapshot)
+	require.Nil(t, err)
+	requireSameNetworks(t, second, loaded)
+	loaded, err = loadSnapshot(ctx, store, "2020-10-20 00-00-00__1")
+	require.Nil(t, err)
+	requireSameNetworks(t, first, loaded)
+	loaded, err = loadSnapshot(ctx, store, getObjectPrefix("2020-10-20 00-00-00__1"))
+	require.Nil(t, err)
+	requireSameNetworks(t, first, loaded)
+	_, err = loadSnapshot(ctx, store, "2020-10-19 00-00-00__0")
+	require.Error(t, err)
+
+	// Local files do not need a destination
+	data, err := json.Marshal(first)
+	require.Nil(t, err)
+	networksFile := filepath.Join(t.TempDir(), "networks.json")
+	require.Nil(t, os.WriteFile(networksFile, data, 0644))
+	loaded, err = loadSnapshot(ctx, nil, networksFile)
+	require.Nil(t, err)
+	requireSameNetworks(t, first, loaded)
+	_, err = loadSnapshot(ctx, nil, latestSnapshot)
+	require.Error(t, err)
+}
+
+func TestWriteDiffText(t *testing.T) {
+	previous := common.NewProviderNetworkRanges("Amazon")
+	require.Nil(t, previous.AddIPPrefix("us-east-1", "EC2", "3.5.140.0/22", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, previous.AddIPPrefix("us-east-1", "EC2", "35.180.0.0/16", common.GetDefaultRegionServicePairRedundancyCheck()))
+	current := common.NewProviderNetworkRanges("Amazon")
+	require.Nil(t, current.AddIPPrefix("us-west-1", "EC2", "3.5.140.0/22", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, current.AddIPPrefix("us-east-1", "EC2", "52.93.178.234/32", common.GetDefaultRegionServicePairRedundancyCheck()))
+
+	var b strings.Builder
+	require.Nil(t, writeDiffText(&b, diff.Compute(newNetworks(previous), newNetworks(current))))
+	require.Equal(t,
+		"Amazon: 2 prefixes before, 2 after. 1 added, 1 removed, 1 moved\n"+
+			"  + 52.93.178.234/32 (us-east-1/EC2)\n"+
+			"  - 35.180.0.0/16 (us-east-1/EC2)\n"+
+			"  ~ 3.5.140.0/22 (us-east-1/EC2 -> us-west-1/EC2)\n"+
+			"Total: 1 added, 1 removed, 1 moved\n",
+		b.String())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/netindex"
+)
+
+const lookupCommandName = "lookup"
+
+// lookupResult are the matches of a queried IP address or prefix
+type lookupResult struct {
+	Query   string           `json:"query"`
+	Matches []netindex.Match `json:"matches"`
+}
+
+// runLookup prints the providers, regions and services every queried IP address or
+// prefix belongs to. Queries are read from stdin if none are given as arguments.
+func runLookup(args []string) error {
+	flags := flag.NewFlagSet(lookupCommandName, flag.ExitOnError)
+	var (
+		flagBucketName  = flags.String("bucket-name", "", "GCS bucket name to read published networks from. Shorthand for --destination gs://<bucket name>")
+		flagDestination = flags.String("destination", "", "URL of the storage to read published networks from. EX: gs://<bucket name>, s3://<bucket name>, file://<directory>")
+		flagNetworks    = flags.String("networks", latestSnapshot, fmt.Sprintf(
+			"Networks to look up in. Either a local networks file, a run folder of the destination, %q or %q",
+			latestSnapshot, previousSnapshot))
+		flagOutput = flags.String("output", textOutput, fmt.Sprintf("Output format, %q or %q (one JSON object per line)", textOutput, jsonOutput))
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [options] [<ip|cidr>...]\n\n", os.Args[0], lookupCommandName)
+		fmt.Fprintf(flags.Output(), "Prints every provider, region and service whose prefixes contain the IP "+
+			"addresses or prefixes, longest match first. Reads one IP address or prefix per line from stdin "+
+			"if none are given.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *flagOutput != textOutput && *flagOutput != jsonOutput {
+		return errors.Errorf("unknown output format %q", *flagOutput)
+	}
+	store, err := newStore(*flagBucketName, *flagDestination)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	networks, err := loadSnapshot(ctx, store, *flagNetworks)
+	if err != nil {
+		return err
+	}
+	index, err := netindex.New(networks)
+	if err != nil {
+		return err
+	}
+
+	writeResult := writeLookupText
+	if *flagOutput == jsonOutput {
+		writeResult = writeLookupJSON
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	if flags.NArg() > 0 {
+		for _, query := range flags.Args() {
+			result, err := lookup(index, query)
+			if err != nil {
+				return err
+			}
+			if err := writeResult(out, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return lookupAll(index, os.Stdin, out, writeResult)
+}
+
+// lookupAll looks up every line of r. Empty lines and lines starting with # are
+// skipped. Invalid queries are logged and counted, but do not stop the lookup.
+func lookupAll(index *netindex.Index, r io.Reader, w io.Writer, writeResult func(io.Writer, *lookupResult) error) error {
+	scanner := bufio.NewScanner(r)
+	numInvalid := 0
+	for scanner.Scan() {
+		query := strings.TrimSpace(scanner.Text())
+		if query == "" || strings.HasPrefix(query, "#") {
+			continue
+		}
+		result, err := lookup(index, query)
+		if err != nil {
+			log.Print(color.YellowString("%v", err))
+			numInvalid++
+			continue
+		}
+		if err := writeResult(w, result); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read queries")
+	}
+	if numInvalid > 0 {
+		return errors.Errorf("%d queries are not valid IP addresses or prefixes", numInvalid)
+	}
+	return nil
+}
+
+// lookup returns the matches of an IP address or prefix
+func lookup(index *netindex.Index, query string) (*lookupResult, error) {
+	prefix, err := parseLookupQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return &lookupResult{Query: query, Matches: index.Covering(prefix)}, nil
+}
+
+// parseLookupQuery parses an IP address, as a single address prefix, or a prefix
+func parseLookupQuery(query string) (netip.Prefix, error) {
+	if strings.Contains(query, "/") {
+		prefix, err := netip.ParsePrefix(query)
+		if err != nil {
+			return netip.Prefix{}, errors.Wrapf(err, "invalid prefix %q", query)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(query)
+	if err != nil {
+		return netip.Prefix{}, errors.Wrapf(err, "invalid IP address %q", query)
+	}
+	addr = addr.WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func writeLookupText(w io.Writer, result *lookupResult) error {
+	if len(result.Matches) == 0 {
+		_, err := fmt.Fprintf(w, "%s: no match\n", result.Query)
+		return err
+	}
+	for _, match := range result.Matches {
+		_, err := fmt.Fprintf(w, "%s: %s %s (%s/%s)\n", result.Query, match.Prefix, match.Provider, match.Region, match.Service)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLookupJSON(w io.Writer, result *lookupResult) error {
+	return json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/netindex"
+	"github.com/stretchr/testify/require"
+)
+
+func newLookupNetworks(t *testing.T) *common.ExternalNetworkSources {
+	google := common.NewProviderNetworkRanges("Google")
+	require.Nil(t, google.AddIPPrefix("global", "Google Cloud", "34.64.0.0/10", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, google.AddIPPrefix("asia-east1", "Google Cloud", "34.80.0.0/15", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, google.AddIPPrefix("us-central1", "Google Cloud", "2600:1900:4000::/44", common.GetDefaultRegionServicePairRedundancyCheck()))
+	amazon := common.NewProviderNetworkRanges("Amazon")
+	require.Nil(t, amazon.AddIPPrefix("us-east-1", "EC2", "34.80.0.0/16", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, amazon.AddIPPrefix("us-east-1", "AMAZON", "34.80.0.0/16", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, amazon.AddIPPrefix("us-west-1", "S3", "2600:1f15::/32", common.GetDefaultRegionServicePairRedundancyCheck()))
+	return newNetworks(google, amazon)
+}
+
+func match(prefix, provider, region, service string) netindex.Match {
+	return netindex.Match{Prefix: netip.MustParsePrefix(prefix), Provider: provider, Region: region, Service: service}
+}
+
+func TestLookup(t *testing.T) {
+	index, err := netindex.New(newLookupNetworks(t))
+	require.Nil(t, err)
+
+	for _, c := range []struct {
+		query    string
+		expected []netindex.Match
+	}{
+		{
+			query: "34.80.1.2",
+			expected: []netindex.Match{
+				match("34.80.0.0/16", "Amazon", "us-east-1", "AMAZON"),
+				match("34.80.0.0/16", "Amazon", "us-east-1", "EC2"),
+				match("34.80.0.0/15", "Google", "asia-east1", "Google Cloud"),
+				match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+			},
+		},
+		{
+			query: "34.120.5.9",
+			expected: []netindex.Match{
+				match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+			},
+		},
+		{
+			// IPv4-mapped addresses match the IPv4 prefixes
+			query: "::ffff:34.120.5.9",
+			expected: []netindex.Match{
+				match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+			},
+		},
+		{
+			// Prefixes only match the published prefixes containing all of them
+			query: "34.80.0.0/15",
+			expected: []netindex.Match{
+				match("34.80.0.0/15", "Google", "asia-east1", "Google Cloud"),
+				match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+			},
+		},
+		{
+			query: "2600:1900:4001::1",
+			expected: []netindex.Match{
+				match("2600:1900:4000::/44", "Google", "us-central1", "Google Cloud"),
+			},
+		},
+		{
+			query:    "8.8.8.8",
+			expected: []netindex.Match{},
+		},
+		{
+			query:    "2001:db8::/32",
+			expected: []netindex.Match{},
+		},
+	} {
+		result, err := lookup(index, c.query)
+		require.Nil(t, err, c.query)
+		require.Equal(t, c.query, result.Query)
+		require.Equal(t, c.expected, result.Matches, c.query)
+	}
+
+	for _, query := range []string{"34.80.1", "34.80.0.0/33", "example.com"} {
+		_, err := lookup(index, query)
+		require.Error(t, err, query)
+	}
+}
+
+func TestLookupAll(t *testing.T) {
+	index, err := netindex.New(newLookupNetworks(t))
+	require.Nil(t, err)
+	input := strings.Join([]string{
+		"# Comments and empty lines are skipped",
+		"34.120.5.9",
+		"",
+		"  2600:1f15::1  ",
+		"8.8.8.8",
+	}, "\n")
+
+	var out strings.Builder
+	require.Nil(t, lookupAll(index, strings.NewReader(input), &out, writeLookupText))
+	require.Equal(t, strings.Join([]string{
+		"34.120.5.9: 34.64.0.0/10 Google (global/Google Cloud)",
+		"2600:1f15::1: 2600:1f15::/32 Amazon (us-west-1/S3)",
+		"8.8.8.8: no match",
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	require.Nil(t, lookupAll(index, strings.NewReader("34.120.5.9\n"), &out, writeLookupJSON))
+	require.JSONEq(t, `{"query": "34.120.5.9", "matches": [
+		{"prefix": "34.64.0.0/10", "provider": "Google", "region": "global", "service": "Google Cloud"}
+	]}`, out.String())
+
+	// Invalid queries do not stop the lookup
+	out.Reset()
+	err = lookupAll(index, strings.NewReader("not an ip\n8.8.8.8\n"), &out, writeLookupText)
+	require.Error(t, err)
+	require.Equal(t, "8.8.8.8: no match\n", out.String())
+}
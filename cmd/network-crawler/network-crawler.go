@@ -1,16 +1,17 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
@@ -18,19 +19,26 @@ import (
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/common/utils"
 	"github.com/stackrox/external-network-pusher/pkg/crawlers"
+	"github.com/stackrox/external-network-pusher/pkg/delta"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+	"github.com/stackrox/external-network-pusher/pkg/overrides"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stackrox/external-network-pusher/pkg/version"
 )
 
 // This program crawls a set of external network providers (Google, Amazon, etc.)
-// and push crawled IP ranges to a specified Google Cloud bucket.
+// and push crawled IP ranges to a specified destination (Google Cloud bucket, etc.).
 //
 // For every run it creates a header file as per the structure defined
 // in common/constants.go, and a folder with list of files containing
 // each provider's IP ranges.
 
-// skippedProviderFlag is a flag that takes in a list of Provider names
-type skippedProviderFlag []common.Provider
+// providersFlag is a flag that takes in a list of Provider names. The names are
+// resolved with resolve once all the providers are registered, since providers of
+// --provider-specs are only registered after the flags are parsed.
+type providersFlag []common.Provider
 
-func (f *skippedProviderFlag) String() string {
+func (f *providersFlag) String() string {
 	strs := make([]string, 0, len(*f))
 	for _, p := range *f {
 		strs = append(strs, p.String())
@@ -38,20 +46,44 @@ func (f *skippedProviderFlag) String() string {
 	return strings.Join(strs, ",")
 }
 
-func (f *skippedProviderFlag) Set(value string) error {
+func (f *providersFlag) Set(value string) error {
 	splitted := strings.Split(value, ",")
 	for _, s := range splitted {
-		p, err := common.ToProvider(s)
+		*f = append(*f, common.Provider(s))
+	}
+	return nil
+}
+
+// resolve checks that every provider of the flag exists
+func (f *providersFlag) resolve() error {
+	for i, p := range *f {
+		resolved, err := common.ToProvider(p.String())
 		if err != nil {
 			return err
 		}
-		*f = append(*f, p)
+		(*f)[i] = resolved
 	}
 	return nil
 }
 
 func main() {
-	if err := run(); err != nil {
+	// Subcommands are dispatched on the first argument, crawling and publishing is the default
+	var err error
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	switch command {
+	case diffCommandName:
+		err = runDiff(os.Args[2:])
+	case lookupCommandName:
+		err = runLookup(os.Args[2:])
+	case annotateCommandName:
+		err = runAnnotate(os.Args[2:])
+	default:
+		err = run()
+	}
+	if err != nil {
 		log.Fatalf("External network pusher failed: %v", err)
 		os.Exit(1)
 	}
@@ -59,22 +91,84 @@ func main() {
 
 func run() error {
 	var (
-		flagBucketName       = flag.String("bucket-name", "", "GCS bucket name to upload external networks to")
-		flagDryRun           = flag.Bool("dry-run", false, "Skip uploading external networks to GCS")
-		flagSkippedProviders skippedProviderFlag
+		flagBucketName       = flag.String("bucket-name", "", "GCS bucket name to upload external networks to. Shorthand for --destination gs://<bucket name>")
+		flagDestination      = flag.String("destination", "", "URL of the storage to upload external networks to. EX: gs://<bucket name>, s3://<bucket name>, file://<directory>")
+		flagDryRun           = flag.Bool("dry-run", false, "Skip uploading external networks to the destination")
+		flagSkippedProviders providersFlag
+		flagAllowedProviders providersFlag
+		flagRollbackPolicy   = rollbackPolicyFail
+		flagOverlapPolicy    = overlapPolicyWarn
+		flagBogonPolicy      = common.BogonPolicyDrop
+		flagProviderPriority providersFlag
 		flagVerbose          bool
 		flagVerboseUsage     = "Prints extra debug message"
-		flagOutputDir        = flag.String("output-dir", "", "If provided, write files to disk. Also works on dry-run.")
+		flagForcePublish     = flag.Bool("force-publish", false, "Publish even if the crawled networks are identical to the latest published networks")
+		flagMaxConcurrency   = flag.Int("max-concurrent-crawlers", 3, "Maximum number of providers crawled at the same time. 0 means no limit")
+		flagCrawlerTimeout   = flag.Duration("crawler-timeout", 15*time.Minute, "Time each provider has to finish crawling. 0 means no timeout")
+		flagTimeout          = flag.Duration("timeout", 0, "Deadline of the whole run. 0 means no deadline")
+		flagMaxChange        = flag.Float64("max-change-percent", 50, "Maximum percentage of prefixes a provider, region or service can gain or lose "+
+			"since the latest published networks. Prefixes moving between regions or services are not counted. 0 disables the check")
+		flagMinChangeSize = flag.Int("min-change-check-prefixes", 20, "Regions and services with fewer previously published prefixes "+
+			"are not checked against --max-change-percent")
+		flagDeltaRuns = flag.Int("delta-runs", 1, "Number of previously published runs to publish a delta from, newest first. "+
+			"0 disables deltas")
+		flagSummarize = flag.Bool("summarize", false, "Merge adjacent prefixes and drop prefixes contained in another prefix "+
+			"of the same region and service before publishing. The published prefixes cover the same addresses")
+		flagMinIPv4PrefixLen = flag.Int("min-ipv4-prefix-length", 8, "IPv4 prefixes shorter than this length are "+
+			"handled as not publicly routable, see --on-bogon. 0 disables the check")
+		flagMinIPv6PrefixLen = flag.Int("min-ipv6-prefix-length", 16, "IPv6 prefixes shorter than this length are "+
+			"handled as not publicly routable, see --on-bogon. 0 disables the check")
+		flagOverrides = flag.String("overrides", "", "Path to a YAML or JSON file of rules excluding, renaming or injecting "+
+			"prefixes, regions and services, applied to the crawled networks before they are validated")
+		flagProviderSpecs = flag.String("provider-specs", "", "Comma separated list of provider spec files, each adding "+
+			"a provider publishing its networks as a JSON document. See pkg/crawlers/generic")
+		flagOutputDir = flag.String("output-dir", "", "If provided, write networks.json and checksum.sha256 to disk. Also works on dry-run. "+
+			"Use --destination file://<directory> to write the full bucket layout instead.")
 	)
 	skippedProvidersUsage :=
 		fmt.Sprintf("Comma separated list of providers. Currently acceptable providers are: %v", common.AllProviders())
 	flag.Var(&flagSkippedProviders, "skipped-providers", skippedProvidersUsage)
+	flag.Var(&flagAllowedProviders, "allow-change-providers",
+		"Comma separated list of providers whose changes are not checked against --max-change-percent. "+
+			"Use it when a large change is expected")
+	flag.Var(&flagRollbackPolicy, "on-upstream-rollback", fmt.Sprintf(
+		"What to do when a provider's upstream feed is older than in the latest published run. "+
+			"%q refuses to publish, %q publishes the provider's networks of the latest published run instead",
+		rollbackPolicyFail, rollbackPolicyKeepPrevious))
+	flag.Var(&flagOverlapPolicy, "on-provider-overlap", fmt.Sprintf(
+		"What to do when a prefix of a provider overlaps a prefix of another provider. "+
+			"%q only reports the overlaps, %q refuses to publish, %q removes the overlapping addresses "+
+			"from the provider coming later in --provider-priority",
+		overlapPolicyWarn, overlapPolicyFail, overlapPolicyPriority))
+	flag.Var(&flagBogonPolicy, "on-bogon", fmt.Sprintf(
+		"What to do when a crawled prefix is not publicly routable: private, loopback, link-local, CGNAT, "+
+			"documentation, multicast or other special-purpose ranges, default routes and too broad prefixes. "+
+			"%q removes the prefix before publishing, %q refuses to publish",
+		common.BogonPolicyDrop, common.BogonPolicyFail))
+	flag.Var(&flagProviderPriority, "provider-priority",
+		"Comma separated list of providers, highest priority first, used to resolve overlaps across providers. "+
+			"Providers not listed come last")
 	flag.BoolVar(&flagVerbose, "verbose", flagVerbose, flagVerboseUsage)
 	flag.BoolVar(&flagVerbose, "v", flagVerbose, flagVerboseUsage+" (shorthand)")
 	flag.Parse()
 
-	// Bucket name is optional on dry runs
-	if (flagBucketName == nil || *flagBucketName == "") && !*flagDryRun {
+	if *flagProviderSpecs != "" {
+		if err := crawlers.RegisterFromSpecs(strings.Split(*flagProviderSpecs, ",")); err != nil {
+			return err
+		}
+	}
+	for _, providers := range []*providersFlag{&flagSkippedProviders, &flagAllowedProviders, &flagProviderPriority} {
+		if err := providers.resolve(); err != nil {
+			return err
+		}
+	}
+
+	store, err := newStore(*flagBucketName, *flagDestination)
+	if err != nil {
+		return err
+	}
+	// Destination is optional on dry runs
+	if store == nil && !*flagDryRun {
 		return common.NoBucketNameSpecified()
 	}
 
@@ -82,8 +176,27 @@ func run() error {
 		common.SetVerbose()
 	}
 
+	if flagOverlapPolicy == overlapPolicyPriority && len(flagProviderPriority) == 0 {
+		return errors.Errorf("--provider-priority is required with --on-provider-overlap %s", overlapPolicyPriority)
+	}
+
+	if *flagMinIPv4PrefixLen < 0 || *flagMinIPv4PrefixLen > 32 {
+		return errors.Errorf("--min-ipv4-prefix-length must be between 0 and 32, got %d", *flagMinIPv4PrefixLen)
+	}
+	if *flagMinIPv6PrefixLen < 0 || *flagMinIPv6PrefixLen > 128 {
+		return errors.Errorf("--min-ipv6-prefix-length must be between 0 and 128, got %d", *flagMinIPv6PrefixLen)
+	}
+
+	var overridesFile *overrides.File
+	if *flagOverrides != "" {
+		overridesFile, err = overrides.Load(*flagOverrides)
+		if err != nil {
+			return err
+		}
+	}
+
 	if *flagDryRun {
-		log.Print("Dry run specified. Instead of uploading the content to bucket will just print to stdout.")
+		log.Print("Dry run specified. Instead of uploading the content to destination will just print to stdout.")
 	}
 
 	if flagOutputDir == nil {
@@ -101,13 +214,44 @@ func run() error {
 	}
 	log.Printf("Crawling from this list of providers: %s", strings.Join(crawlingProviders, ", "))
 
-	err := publishExternalNetworks(*flagBucketName, crawlerImpls, *flagDryRun, *flagOutputDir)
+	// Cancel in-flight fetches and retries cleanly when asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *flagTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *flagTimeout)
+		defer cancel()
+	}
+	opts := publishOptions{
+		isDryRun:              *flagDryRun,
+		outputDir:             *flagOutputDir,
+		forcePublish:          *flagForcePublish,
+		maxConcurrentCrawlers: *flagMaxConcurrency,
+		crawlerTimeout:        *flagCrawlerTimeout,
+		rollbackPolicy:        flagRollbackPolicy,
+		deltaRuns:             *flagDeltaRuns,
+		summarize:             *flagSummarize,
+		overlapPolicy:         flagOverlapPolicy,
+		providerPriority:      flagProviderPriority,
+		overrides:             overridesFile,
+		bogonOptions: common.BogonOptions{
+			Policy:           flagBogonPolicy,
+			MinIPv4PrefixLen: *flagMinIPv4PrefixLen,
+			MinIPv6PrefixLen: *flagMinIPv6PrefixLen,
+		},
+		changeGate: changeGate{
+			maxChangePercent: *flagMaxChange,
+			minPrefixes:      *flagMinChangeSize,
+			allowedProviders: flagAllowedProviders,
+		},
+	}
+	err = publishExternalNetworks(ctx, store, crawlerImpls, opts)
 	if err != nil {
 		return errors.Wrap(err, "failed publishing external network ranges")
 	}
 
-	// After uploading new data, we should keep the total number of entries in bucket to be under a limit
-	err = truncateOutdatedExternalNetworksDefnitions(*flagBucketName, *flagDryRun)
+	// After uploading new data, we should keep the total number of entries in destination to be under a limit
+	err = truncateOutdatedExternalNetworksDefnitions(ctx, store, *flagDryRun)
 	if err != nil {
 		return errors.Wrap(err, "failed to check remove oldest networks definitions")
 	}
@@ -115,15 +259,59 @@ func run() error {
 	return nil
 }
 
+// newStore returns the store of the destination given by either a bucket name or a
+// destination URL. Nil is returned if neither is given.
+func newStore(bucketName, destination string) (storage.Store, error) {
+	if destination != "" && bucketName != "" {
+		return nil, common.DestinationAndBucketNameSpecified()
+	}
+	if bucketName != "" {
+		destination = "gs://" + bucketName
+	}
+	if destination == "" {
+		return nil, nil
+	}
+	return storage.New(destination)
+}
+
+// publishOptions contains the options of a publishing run
+type publishOptions struct {
+	isDryRun  bool
+	outputDir string
+	// forcePublish publishes even if nothing changed since the latest published run
+	forcePublish bool
+	// maxConcurrentCrawlers is the maximum number of providers crawled at the same time
+	maxConcurrentCrawlers int
+	// crawlerTimeout is the time each provider has to finish crawling
+	crawlerTimeout time.Duration
+	// rollbackPolicy decides what to do when an upstream feed went backwards
+	rollbackPolicy rollbackPolicy
+	// changeGate limits how much the networks can change since the latest published run
+	changeGate changeGate
+	// deltaRuns is the number of previously published runs to publish a delta from
+	deltaRuns int
+	// summarize folds adjacent and contained prefixes of every service before publishing
+	summarize bool
+	// overlapPolicy decides what to do when prefixes of different providers overlap
+	overlapPolicy overlapPolicy
+	// providerPriority are the providers by decreasing priority, to resolve overlaps
+	providerPriority []common.Provider
+	// overrides are the operator's rules applied to the crawled networks, if any
+	overrides *overrides.File
+	// bogonOptions decide which prefixes are not publicly routable and what to do with them
+	bogonOptions common.BogonOptions
+}
+
 func publishExternalNetworks(
-	bucketName string,
+	ctx context.Context,
+	store storage.Store,
 	crawlerImpls []common.NetworkCrawler,
-	isDryRun bool,
-	outputDir string,
+	opts publishOptions,
 ) error {
 	// We use the folder name as object prefix so that all the objects
 	// uploaded as part of this run appears under the same folder
-	timestamp := getCurrentTimestamp()
+	now := time.Now().UTC()
+	timestamp := getTimestamp(now)
 	uniquifiedTimestamp, err := utils.Uniquify(timestamp)
 	if err != nil {
 		return err
@@ -131,25 +319,97 @@ func publishExternalNetworks(
 	networkFilesPrefix := getObjectPrefix(uniquifiedTimestamp)
 	latestPrefixFilePrefix := getLatestPrefixFilePrefix()
 
-	var allExternalNetworks common.ExternalNetworkSources
-	for _, crawler := range crawlerImpls {
+	log.Print("=======")
+	providerNetworks, err := crawlProviders(ctx, crawlerImpls, opts.maxConcurrentCrawlers, opts.crawlerTimeout)
+	if err != nil {
+		return err
+	}
+	allExternalNetworks := common.ExternalNetworkSources{ProviderNetworks: providerNetworks}
+	log.Print("Finished crawling all providers.")
+
+	var latestRun *publishedRun
+	var latestNetworks *common.ExternalNetworkSources
+	if store != nil {
+		latestRun, err = readLatestRun(ctx, store)
+		if err != nil {
+			return errors.Wrap(err, "failed to read latest published run")
+		}
+	}
+	if latestRun != nil {
+		latestNetworks = latestRun.networks
+	}
+
+	var keptProviders []string
+	if latestNetworks != nil {
 		log.Print("=======")
-		log.Printf("Crawing from provider %s...", crawler.GetHumanReadableProviderName())
-		providerNetworkRanges, err := crawler.CrawlPublicNetworkRanges()
+		log.Print("Checking upstream feed versions against the latest published run...")
+		keptProviders, err = guardAgainstFeedRollback(&allExternalNetworks, latestNetworks, opts.rollbackPolicy)
 		if err != nil {
-			log.Printf("Failed to crawl networks for %s: %v", crawler.GetHumanReadableProviderName(), err)
-			// Hard stop to make the info stored in bucket absolutely correct
-			return err
+			return errors.Wrap(err, "upstream feed rollback detected")
+		}
+	}
+	// The networks kept from the latest published run went through the overrides and the
+	// checks below when they were published, so they are set aside until they are validated
+	keptNetworks := setAsideProviders(&allExternalNetworks, keptProviders)
+
+	var injectedProviders []string
+	if opts.overrides != nil {
+		log.Print("=======")
+		log.Print("Applying overrides...")
+		report, err := opts.overrides.Apply(&allExternalNetworks, now)
+		if err != nil {
+			return errors.Wrap(err, "failed to apply overrides")
 		}
-		allExternalNetworks.ProviderNetworks = append(allExternalNetworks.ProviderNetworks, providerNetworkRanges)
+		log.Printf("Applied %d override rules, %d rules did not match anything, skipped %d expired rules",
+			report.NumApplied, report.NumUnmatched, report.NumExpired)
+		injectedProviders = report.InjectedProviders
+	}
 
-		log.Printf("Successfully crawled provider %s", crawler.GetHumanReadableProviderName())
+	// Drop the prefixes that are not publicly routable before validating, so that the
+	// minimum numbers of prefixes only count publishable prefixes
+	log.Print("=======")
+	log.Print("Checking for prefixes that are not publicly routable...")
+	err = filterBogons(&allExternalNetworks, opts.bogonOptions)
+	if err != nil {
+		return errors.Wrap(err, "external network sources validation failed")
+	}
+
+	// Resolving overlaps removes prefixes from the lower priority providers, so also do
+	// it before validating, which catches providers left with too few prefixes
+	log.Print("=======")
+	log.Print("Checking prefix overlaps across providers...")
+	err = checkProviderOverlaps(&allExternalNetworks, opts.overlapPolicy, opts.providerPriority)
+	if err != nil {
+		return errors.Wrap(err, "external network sources validation failed")
 	}
-	log.Print("Finished crawling all providers.")
 
 	log.Print("=======")
 	log.Print("Validating crawl results...")
-	err = validateExternalNetworks(crawlerImpls, &allExternalNetworks)
+	err = validateExternalNetworks(crawlersExcept(crawlerImpls, keptProviders), &allExternalNetworks, injectedProviders...)
+	if err != nil {
+		return errors.Wrap(err, "external network sources validation failed")
+	}
+	allExternalNetworks.ProviderNetworks = append(allExternalNetworks.ProviderNetworks, keptNetworks...)
+
+	// Summarize after the minimum numbers of prefixes are checked, since they are
+	// numbers of crawled prefixes
+	if opts.summarize {
+		log.Print("=======")
+		log.Print("Summarizing networks...")
+		summarizeExternalNetworks(&allExternalNetworks)
+	}
+
+	// The minimum numbers of prefixes cannot catch a provider that suddenly loses or
+	// gains a large share of its prefixes, so also compare with the latest published networks.
+	// Turning summarization on or off changes the numbers of prefixes by itself.
+	changeGateNetworks := latestNetworks
+	if latestRun != nil && latestRun.summarized != opts.summarize {
+		log.Print(color.YellowString(
+			"The latest published run was summarized: %t, this run is summarized: %t. Not checking the change ratios",
+			latestRun.summarized, opts.summarize))
+		changeGateNetworks = nil
+	}
+	err = checkChangeRatio(changeGateNetworks, &allExternalNetworks, opts.changeGate)
 	if err != nil {
 		return errors.Wrap(err, "external network sources validation failed")
 	}
@@ -158,33 +418,44 @@ func publishExternalNetworks(
 	log.Print("Uploading external networks...")
 	// Create and upload the object file
 	err = uploadExternalNetworkSources(
+		ctx,
 		&allExternalNetworks,
-		isDryRun,
-		bucketName,
+		latestRun,
+		store,
 		networkFilesPrefix,
 		latestPrefixFilePrefix,
-		timestamp,
-		outputDir)
+		now,
+		opts)
 	if err != nil {
-		return errors.Wrap(err, "failed to upload data to bucket")
+		return errors.Wrap(err, "failed to upload data to destination")
 	}
 
 	log.Print("Processing done.")
 	return nil
 }
 
-func validateExternalNetworks(crawlers []common.NetworkCrawler, networks *common.ExternalNetworkSources) error {
+// validateExternalNetworks validates the networks of every crawler, and of every
+// provider no crawler published that was injected by the overrides
+func validateExternalNetworks(
+	crawlers []common.NetworkCrawler,
+	networks *common.ExternalNetworkSources,
+	injectedProviders ...string,
+) error {
 	// Validate that for each provider we at least have 1 IP prefix so that we are
 	// not uploading empty data.
 	// Each crawler should be responsible for its own validation of its network
 	// ranges.
-	if len(networks.ProviderNetworks) != len(crawlers) {
-		return common.NumProvidersError(len(networks.ProviderNetworks), len(crawlers))
+	if numProviders := len(crawlers) + len(injectedProviders); len(networks.ProviderNetworks) != numProviders {
+		return common.NumProvidersError(len(networks.ProviderNetworks), numProviders)
 	}
 	numRequiredPrefixesPerProvider := make(map[string]int)
 	for _, c := range crawlers {
 		numRequiredPrefixesPerProvider[c.GetProviderKey().String()] = c.GetNumRequiredIPPrefixes()
 	}
+	// Injected providers only need the prefixes every provider needs
+	for _, providerName := range injectedProviders {
+		numRequiredPrefixesPerProvider[providerName] = 1
+	}
 	for _, provider := range networks.ProviderNetworks {
 		providerName := provider.ProviderName
 		if providerName == "" {
@@ -223,42 +494,108 @@ func validateExternalNetworks(crawlers []common.NetworkCrawler, networks *common
 	return nil
 }
 
+// filterBogons drops the prefixes that are not publicly routable or fails according to
+// the policy, and logs every offending prefix
+func filterBogons(networks *common.ExternalNetworkSources, opts common.BogonOptions) error {
+	report, err := common.FilterBogons(networks, opts)
+	if report != nil {
+		for _, bogon := range report.Bogons {
+			log.Print(color.YellowString("Prefix is not publicly routable: %s", bogon))
+		}
+		if err == nil && len(report.Bogons) > 0 {
+			log.Print(color.YellowString("Dropped %d prefixes that are not publicly routable", len(report.Bogons)))
+		}
+	}
+	return err
+}
+
+// summarizeExternalNetworks summarizes the networks of every provider, and logs how
+// much was folded
+func summarizeExternalNetworks(networks *common.ExternalNetworkSources) {
+	for _, provider := range networks.ProviderNetworks {
+		report := provider.Summarize()
+		log.Printf("Summarized provider %s: folded %d of %d prefixes, covering %s addresses",
+			report.ProviderName,
+			report.NumPrefixesFolded(),
+			report.NumPrefixesBefore,
+			report.NumAddressesFolded)
+	}
+}
+
 func uploadExternalNetworkSources(
+	ctx context.Context,
 	networks *common.ExternalNetworkSources,
-	isDryRun bool,
-	bucketName, networkFilesPrefix, latestPrefixFilePrefix, timestamp string, outputDir string,
+	latestRun *publishedRun,
+	store storage.Store,
+	networkFilesPrefix, latestPrefixFilePrefix string,
+	createdAt time.Time,
+	opts publishOptions,
 ) error {
 	log.Printf("Uploading crawled networks...")
-	data, cksum, err := marshalAndGetCksum(networks)
+	// Sort everything so that identical networks always give identical files and checksums
+	networks.Canonicalize()
+	data, cksum, err := common.MarshalAndChecksum(networks)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal external networks")
 	}
 
-	if !isDryRun {
-		// First upload the networks file then the latest_metadata that points to it
-		err := uploadObjectWithPrefix(bucketName, networkFilesPrefix, common.NetworkFileName, data)
-		if err != nil {
-			return errors.Wrap(err, "failed to upload network ranges")
-		}
-		err = uploadObjectWithPrefix(bucketName, networkFilesPrefix, common.ChecksumFileName, []byte(cksum))
+	var latestRunFolder string
+	var latestNetworks *common.ExternalNetworkSources
+	if latestRun != nil {
+		latestRunFolder, latestNetworks = latestRun.runFolder, latestRun.networks
+	}
+	unchanged, err := isUnchangedSinceLatestRun(networks, latestNetworks, opts)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		log.Print(color.GreenString(
+			"Crawled networks are identical to the latest published networks. " +
+				"Nothing changed, skipping upload. Use --force-publish to publish anyway."))
+		return writeOutputDir(opts.outputDir, data, cksum)
+	}
+
+	// Record what changed since the latest run, so that consumers can apply incremental updates
+	changelog := diff.NewChangelog(latestRunFolder, networkFilesPrefix, diff.Compute(latestNetworks, networks))
+	changesData, err := json.Marshal(changelog)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal changes")
+	}
+
+	// Deltas let consumers patch the networks they already have instead of
+	// downloading the full networks again
+	var deltaBaseRuns []*publishedRun
+	if store != nil {
+		deltaBaseRuns, err = readDeltaBaseRuns(ctx, store, latestRun, opts.deltaRuns)
 		if err != nil {
-			return errors.Wrapf(err, "content upload succeeded but checksum upload has failed. Checksum: %s", cksum)
+			return errors.Wrap(err, "failed to read runs to publish deltas from")
 		}
+	}
+	runFiles, err := buildDeltas(networks, networkFilesPrefix, deltaBaseRuns)
+	if err != nil {
+		return err
+	}
+	runFiles[common.ChangesFileName] = changesData
 
-		// Upload latest metadata
-		err = uploadObjectWithPrefix(
-			bucketName,
-			latestPrefixFilePrefix,
-			common.LatestPrefixFileName,
-			[]byte(networkFilesPrefix))
+	manifest := common.NewManifest(networkFilesPrefix, cksum, createdAt, version.Version(), networks)
+	manifest.Summarized = opts.summarize
+	for _, baseRun := range deltaBaseRuns {
+		manifest.DeltasFrom = append(manifest.DeltasFrom, baseRun.runFolder)
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal manifest")
+	}
+
+	if !opts.isDryRun {
+		err := publishRun(ctx, store, networkFilesPrefix, latestPrefixFilePrefix, data, cksum, runFiles, manifestData)
 		if err != nil {
-			return errors.Wrap(err, "failed to upload latest metadata")
+			return err
 		}
 
 		log.Print("Successfully uploaded all contents and checksum.")
 		log.Print("+++++++++++++++++++++")
-		log.Print(
-			color.GreenString("Please check bucket: https://console.cloud.google.com/storage/browser/%s", bucketName))
+		log.Print(color.GreenString("Please check destination: %s", store))
 		log.Print("+++++++++++++++++++++")
 	} else {
 		// In dry run, just print out the package name and hashes
@@ -266,9 +603,23 @@ func uploadExternalNetworkSources(
 			"Dry run specified. Skipping upload. Folder name is: %s. Checksum computed is: %s. Timestamp is: %s",
 			networkFilesPrefix,
 			cksum,
-			timestamp)
+			getTimestamp(createdAt))
+		log.Printf("Manifest is: %s", manifestData)
+		for _, change := range changelog.Providers {
+			log.Printf("Changes of provider %s: %d prefixes added, %d removed, %d moved",
+				change.Provider, change.Added, change.Removed, change.Moved)
+		}
+		for _, baseRun := range deltaBaseRuns {
+			fileName := delta.FileName(baseRun.runFolder)
+			log.Printf("Delta from run %s is %d bytes, networks are %d bytes", baseRun.runFolder, len(runFiles[fileName]), len(data))
+		}
 	}
 
+	return writeOutputDir(opts.outputDir, data, cksum)
+}
+
+// writeOutputDir writes the networks and checksum to the output dir, if specified
+func writeOutputDir(outputDir string, data []byte, cksum string) error {
 	if outputDir == "" {
 		return nil
 	}
@@ -305,28 +656,146 @@ func writeDataToDir(outputDir string, networks []byte, checksum []byte) error {
 	return nil
 }
 
-func uploadObjectWithPrefix(bucketName, prefix, objectName string, data []byte) error {
-	err := utils.WriteToBucket(bucketName, prefix, objectName, data)
+// isUnchangedSinceLatestRun checks whether the latest published networks have the
+// same content as the crawled networks, in which case publishing would only churn
+// consumers and push useful history out of the retained runs. The fetch times of
+// the upstream feeds are ignored, since they change on every run.
+func isUnchangedSinceLatestRun(
+	networks *common.ExternalNetworkSources,
+	latestNetworks *common.ExternalNetworkSources,
+	opts publishOptions,
+) (bool, error) {
+	if latestNetworks == nil || opts.forcePublish {
+		return false, nil
+	}
+	latestNetworks.Canonicalize()
+
+	_, cksum, err := common.MarshalAndChecksum(networks.WithoutFetchTimes())
 	if err != nil {
-		return errors.Wrapf(
-			err,
-			"failed to upload content with prefix %s and name %s",
-			prefix,
-			objectName)
+		return false, errors.Wrap(err, "failed to marshal external networks")
+	}
+	_, latestCksum, err := common.MarshalAndChecksum(latestNetworks.WithoutFetchTimes())
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal latest published networks")
+	}
+	return latestCksum == cksum, nil
+}
+
+// publishRun uploads the networks, checksum and other files of this run, reads them back to
+// verify the checksum, and only then swaps latest_prefix to point to them. The swap is
+// conditional on latest_prefix not having changed since this run started
+// uploading, so concurrent publishers fail instead of silently overwriting each other.
+// The manifest is published with the other files of the run, so that latest_prefix always
+// points to a run with its manifest. A copy of it is then published next to latest_prefix.
+func publishRun(
+	ctx context.Context,
+	store storage.Store,
+	networkFilesPrefix, latestPrefixFilePrefix string,
+	data []byte,
+	cksum string,
+	runFiles map[string][]byte,
+	manifest []byte,
+) error {
+	latestPrefixPath := storage.JoinPath(latestPrefixFilePrefix, common.LatestPrefixFileName)
+	_, latestGeneration, err := store.GetWithGeneration(ctx, latestPrefixPath)
+	if err != nil && errors.Cause(err) != storage.ErrObjectNotFound {
+		return errors.Wrap(err, "failed to read latest metadata")
+	}
+
+	// First upload the networks file then the latest_metadata that points to it
+	err = uploadObjectWithPrefix(ctx, store, networkFilesPrefix, common.NetworkFileName, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload network ranges")
+	}
+	err = uploadObjectWithPrefix(ctx, store, networkFilesPrefix, common.ChecksumFileName, []byte(cksum))
+	if err != nil {
+		return errors.Wrapf(err, "content upload succeeded but checksum upload has failed. Checksum: %s", cksum)
+	}
+	fileNames := make([]string, 0, len(runFiles))
+	for fileName := range runFiles {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	for _, fileName := range fileNames {
+		err = uploadObjectWithPrefix(ctx, store, networkFilesPrefix, fileName, runFiles[fileName])
+		if err != nil {
+			return errors.Wrapf(err, "failed to upload %s", fileName)
+		}
+	}
+	err = uploadObjectWithPrefix(ctx, store, networkFilesPrefix, common.RunManifestFileName, manifest)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload manifest")
+	}
+
+	if err := verifyUploadedRun(ctx, store, networkFilesPrefix, cksum); err != nil {
+		deleteRun(ctx, store, networkFilesPrefix)
+		return errors.Wrap(err, "failed to verify uploaded network ranges")
+	}
+
+	// Upload latest metadata
+	err = store.PutIfGenerationMatch(
+		ctx,
+		latestPrefixPath,
+		[]byte(networkFilesPrefix),
+		latestGeneration)
+	if errors.Cause(err) == storage.ErrPreconditionFailed {
+		deleteRun(ctx, store, networkFilesPrefix)
+		return common.ConcurrentPublishError(latestPrefixPath, err)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to upload latest metadata")
+	}
+
+	err = uploadObjectWithPrefix(ctx, store, latestPrefixFilePrefix, common.ManifestFileName, manifest)
+	if err != nil {
+		return errors.Wrapf(err,
+			"latest metadata upload succeeded but latest manifest upload has failed. The manifest of the run is %s",
+			storage.JoinPath(networkFilesPrefix, common.RunManifestFileName))
 	}
 	return nil
 }
 
-func marshalAndGetCksum(v interface{}) ([]byte, string, error) {
-	data, err := json.Marshal(v)
+// verifyUploadedRun reads back the networks and checksum of a run and checks
+// that they match the expected checksum.
+func verifyUploadedRun(ctx context.Context, store storage.Store, networkFilesPrefix, expectedCksum string) error {
+	networksPath := storage.JoinPath(networkFilesPrefix, common.NetworkFileName)
+	data, err := store.Get(ctx, networksPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read back %s", networksPath)
+	}
+	if cksum := common.Checksum(data); cksum != expectedCksum {
+		return common.ChecksumMismatchError(networksPath, expectedCksum, cksum)
+	}
+
+	cksumPath := storage.JoinPath(networkFilesPrefix, common.ChecksumFileName)
+	cksum, err := store.Get(ctx, cksumPath)
 	if err != nil {
-		return nil, "", err
+		return errors.Wrapf(err, "failed to read back %s", cksumPath)
 	}
+	if string(cksum) != expectedCksum {
+		return common.ChecksumMismatchError(cksumPath, expectedCksum, string(cksum))
+	}
+	return nil
+}
 
-	// Compute checksum
-	hash := sha256.Sum256(data)
-	checksum := hex.EncodeToString(hash[:])
-	return data, checksum, nil
+// deleteRun removes the objects of a run that failed to be published. Failures are
+// only logged since older runs are eventually truncated anyway.
+func deleteRun(ctx context.Context, store storage.Store, networkFilesPrefix string) {
+	if err := store.DeletePrefix(ctx, networkFilesPrefix+"/"); err != nil {
+		log.Print(color.YellowString("Failed to clean up objects with prefix %s: %v", networkFilesPrefix, err))
+	}
+}
+
+func uploadObjectWithPrefix(ctx context.Context, store storage.Store, prefix, objectName string, data []byte) error {
+	err := store.Put(ctx, storage.JoinPath(prefix, objectName), data)
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"failed to upload content with prefix %s and name %s",
+			prefix,
+			objectName)
+	}
+	return nil
 }
 
 func getLatestPrefixFilePrefix() string {
@@ -335,23 +804,23 @@ func getLatestPrefixFilePrefix() string {
 
 func getObjectPrefix(prefixes ...string) string {
 	prefixes = append([]string{common.MasterBucketPrefix}, prefixes...)
-	return filepath.Join(prefixes...)
+	return storage.JoinPath(prefixes...)
 }
 
-func getCurrentTimestamp() string {
+func getTimestamp(t time.Time) string {
 	// Some Go magic here. DO NOT CHANGE THIS STRING
-	return time.Now().UTC().Format("2006-01-02 15-04-05")
+	return t.UTC().Format("2006-01-02 15-04-05")
 }
 
-func truncateOutdatedExternalNetworksDefnitions(bucketName string, isDryRun bool) error {
-	if isDryRun && bucketName == "" {
-		log.Print(color.YellowString("Dry run without specified bucket. Skipping bucket-related checks."))
+func truncateOutdatedExternalNetworksDefnitions(ctx context.Context, store storage.Store, isDryRun bool) error {
+	if isDryRun && store == nil {
+		log.Print(color.YellowString("Dry run without specified destination. Skipping destination-related checks."))
 		return nil
 	}
 
-	prefixes, err := utils.GetAllPrefixesUnderBucketWithPrefix(bucketName, common.MasterBucketPrefix)
+	prefixes, err := store.ListPrefixes(ctx, common.MasterBucketPrefix)
 	if err != nil {
-		return errors.Wrapf(err, "failed getting all prefixes under bucket %s", bucketName)
+		return errors.Wrapf(err, "failed getting all prefixes under %s", store)
 	}
 	// We should not by any chance delete the latest metadata file. Guard against that
 	latestPrefixFilePrefix := getLatestPrefixFilePrefix()
@@ -363,7 +832,7 @@ func truncateOutdatedExternalNetworksDefnitions(bucketName string, isDryRun bool
 		}
 	}
 	if latestPrefixIndex == -1 {
-		return common.LatestPrefixFileNotFound(bucketName)
+		return common.LatestPrefixFileNotFound(store.String())
 	}
 	prefixes = utils.StrSliceRemove(prefixes, latestPrefixIndex)
 
@@ -388,13 +857,13 @@ func truncateOutdatedExternalNetworksDefnitions(bucketName string, isDryRun bool
 
 	// Not dry run, delete objects
 	for _, prefix := range prefixesToDelete {
-		err = utils.DeleteObjectWithPrefix(bucketName, prefix)
+		err = store.DeletePrefix(ctx, prefix+"/")
 		if err != nil {
 			return errors.Wrapf(
 				err,
-				"failed to delete objects with prefix: %s under bucket %s",
+				"failed to delete objects with prefix: %s under %s",
 				prefix,
-				bucketName)
+				store)
 		}
 	}
 
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/crawlers/gcp"
+	"github.com/stackrox/external-network-pusher/pkg/delta"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stackrox/external-network-pusher/pkg/version"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -86,6 +94,15 @@ func TestValidateExternalNetworks(t *testing.T) {
 	}
 	err = validateExternalNetworks(crawlers, &testNetworks)
 	require.Nil(t, err)
+
+	// Providers injected by the overrides are accounted for
+	partnerNetwork := newProviderWithPrefixes(t, "Partner", 1)
+	testNetworks.ProviderNetworks = append(testNetworks.ProviderNetworks, partnerNetwork)
+	err = validateExternalNetworks(crawlers, &testNetworks)
+	require.NotNil(t, err)
+	require.Equal(t, err.Error(), common.NumProvidersError(2, 1).Error())
+	err = validateExternalNetworks(crawlers, &testNetworks, "Partner")
+	require.Nil(t, err)
 }
 
 func TestWriteDataToDir(t *testing.T) {
@@ -103,3 +120,281 @@ func TestWriteDataToDir(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("testchecksum networks.json"), cksumContent)
 }
+
+func TestTruncateOutdatedExternalNetworksDefinitions(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+
+	// Missing latest prefix file should be refused
+	require.Nil(t, store.Put(ctx, storage.JoinPath(getObjectPrefix("run00"), common.NetworkFileName), []byte("{}")))
+	err := truncateOutdatedExternalNetworksDefnitions(ctx, store, false)
+	require.NotNil(t, err)
+	require.Equal(t, common.LatestPrefixFileNotFound(store.String()).Error(), err.Error())
+
+	latestPrefixFile := storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName)
+	numRuns := common.MaxNumDefinitions + 3
+	for i := 1; i < numRuns; i++ {
+		prefix := getObjectPrefix(fmt.Sprintf("run%02d", i))
+		require.Nil(t, store.Put(ctx, storage.JoinPath(prefix, common.NetworkFileName), []byte("{}")))
+		require.Nil(t, store.Put(ctx, storage.JoinPath(prefix, common.ChecksumFileName), []byte("cksum")))
+		require.Nil(t, store.Put(ctx, latestPrefixFile, []byte(prefix)))
+	}
+
+	// Dry run should not delete anything
+	require.Nil(t, truncateOutdatedExternalNetworksDefnitions(ctx, store, true))
+	prefixes, err := store.ListPrefixes(ctx, common.MasterBucketPrefix)
+	require.Nil(t, err)
+	require.Len(t, prefixes, numRuns+1)
+
+	require.Nil(t, truncateOutdatedExternalNetworksDefnitions(ctx, store, false))
+	prefixes, err = store.ListPrefixes(ctx, common.MasterBucketPrefix)
+	require.Nil(t, err)
+	require.Len(t, prefixes, common.MaxNumDefinitions+1)
+	require.Contains(t, prefixes, getLatestPrefixFilePrefix())
+	for i := 0; i < numRuns-common.MaxNumDefinitions; i++ {
+		require.NotContains(t, prefixes, getObjectPrefix(fmt.Sprintf("run%02d", i)))
+	}
+}
+
+func readLatestRunForTest(t *testing.T, store storage.Store) *publishedRun {
+	latestRun, err := readLatestRun(context.Background(), store)
+	require.Nil(t, err)
+	return latestRun
+}
+
+func TestUploadExternalNetworkSources(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	provider := common.NewProviderNetworkRanges("provider")
+	require.Nil(t, provider.AddIPPrefix("region", "service", "35.180.0.0/16", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region", "service", "2600:1f15::/32", common.GetDefaultRegionServicePairRedundancyCheck()))
+	provider.AddSource(&common.SourceMetadata{URL: "https://example.com/ranges.json", Version: "1"})
+	networks := common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{provider},
+	}
+	createdAt := time.Date(2020, 10, 22, 1, 2, 3, 0, time.UTC)
+	provider.SetFetchedAt(createdAt)
+	data, cksum, err := common.MarshalAndChecksum(&networks)
+	require.Nil(t, err)
+
+	networkFilesPrefix := getObjectPrefix("run")
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, networkFilesPrefix, getLatestPrefixFilePrefix(),
+		createdAt, publishOptions{})
+	require.Nil(t, err)
+
+	uploaded, err := store.Get(ctx, storage.JoinPath(networkFilesPrefix, common.NetworkFileName))
+	require.Nil(t, err)
+	require.Equal(t, data, uploaded)
+	uploaded, err = store.Get(ctx, storage.JoinPath(networkFilesPrefix, common.ChecksumFileName))
+	require.Nil(t, err)
+	require.Equal(t, cksum, string(uploaded))
+	uploaded, err = store.Get(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName))
+	require.Nil(t, err)
+	require.Equal(t, networkFilesPrefix, string(uploaded))
+
+	uploaded, err = store.Get(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.ManifestFileName))
+	require.Nil(t, err)
+	var manifest common.Manifest
+	require.Nil(t, json.Unmarshal(uploaded, &manifest))
+	require.Equal(t, common.Manifest{
+		SchemaVersion: common.ManifestSchemaVersion,
+		RunFolder:     networkFilesPrefix,
+		Checksum:      cksum,
+		CreatedAt:     createdAt,
+		ToolVersion:   version.Version(),
+		Providers: []*common.ManifestProvider{
+			{
+				ProviderName:     "provider",
+				NumIPv4Prefixes:  1,
+				NumIPv6Prefixes:  1,
+				UpstreamVersions: map[string]string{"https://example.com/ranges.json": "1"},
+			},
+		},
+	}, manifest)
+	// The run has the same manifest, published before latest prefix points to the run
+	runManifest, err := store.Get(ctx, storage.JoinPath(networkFilesPrefix, common.RunManifestFileName))
+	require.Nil(t, err)
+	require.Equal(t, uploaded, runManifest)
+
+	// Everything is added by the first run
+	uploaded, err = store.Get(ctx, storage.JoinPath(networkFilesPrefix, common.ChangesFileName))
+	require.Nil(t, err)
+	var changelog diff.Changelog
+	require.Nil(t, json.Unmarshal(uploaded, &changelog))
+	require.Equal(t, "", changelog.Previous)
+	require.Equal(t, networkFilesPrefix, changelog.Current)
+	require.Len(t, changelog.Added, 2)
+	require.Empty(t, changelog.Removed)
+
+	// Publishing the same networks again should be skipped, even though they were fetched later
+	provider.SetFetchedAt(createdAt.Add(time.Hour))
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("unchanged"), getLatestPrefixFilePrefix(),
+		createdAt, publishOptions{})
+	require.Nil(t, err)
+	require.Empty(t, store.ObjectNames(getObjectPrefix("unchanged")))
+	uploaded, err = store.Get(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName))
+	require.Nil(t, err)
+	require.Equal(t, networkFilesPrefix, string(uploaded))
+
+	// Unless forced
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("forced"), getLatestPrefixFilePrefix(),
+		createdAt, publishOptions{forcePublish: true})
+	require.Nil(t, err)
+	require.NotEmpty(t, store.ObjectNames(getObjectPrefix("forced")))
+	uploaded, err = store.Get(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName))
+	require.Nil(t, err)
+	require.Equal(t, getObjectPrefix("forced"), string(uploaded))
+
+	// A new upstream version is published even if the networks did not change
+	provider.Sources[0].Version = "2"
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("new-version"), getLatestPrefixFilePrefix(),
+		createdAt, publishOptions{})
+	require.Nil(t, err)
+	require.NotEmpty(t, store.ObjectNames(getObjectPrefix("new-version")))
+	uploaded, err = store.Get(ctx, storage.JoinPath(getObjectPrefix("new-version"), common.ChangesFileName))
+	require.Nil(t, err)
+	changelog = diff.Changelog{}
+	require.Nil(t, json.Unmarshal(uploaded, &changelog))
+	require.Equal(t, getObjectPrefix("forced"), changelog.Previous)
+	require.True(t, changelog.IsEmpty())
+
+	// Changes are relative to the latest run
+	require.Nil(t, provider.AddIPPrefix("region", "service2", "3.5.140.0/22", common.GetDefaultRegionServicePairRedundancyCheck()))
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("added"), getLatestPrefixFilePrefix(),
+		createdAt, publishOptions{})
+	require.Nil(t, err)
+	uploaded, err = store.Get(ctx, storage.JoinPath(getObjectPrefix("added"), common.ChangesFileName))
+	require.Nil(t, err)
+	changelog = diff.Changelog{}
+	require.Nil(t, json.Unmarshal(uploaded, &changelog))
+	require.Equal(t, getObjectPrefix("new-version"), changelog.Previous)
+	require.Equal(t, []*diff.Entry{
+		{Provider: "provider", Region: "region", Service: "service2", Prefix: "3.5.140.0/22"},
+	}, changelog.Added)
+	require.Empty(t, changelog.Removed)
+	require.Empty(t, changelog.Moved)
+	require.Equal(t, []*diff.ScopeChange{
+		{Scope: diff.Scope{Provider: "provider"}, Previous: 2, Current: 3, Added: 1},
+	}, changelog.Providers)
+}
+
+func TestUploadExternalNetworkSourcesDeltas(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	provider := common.NewProviderNetworkRanges("provider")
+	require.Nil(t, provider.AddIPPrefix("region", "service", "35.180.0.0/16", common.GetDefaultRegionServicePairRedundancyCheck()))
+	networks := common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{provider},
+	}
+	createdAt := time.Date(2020, 10, 22, 1, 2, 3, 0, time.UTC)
+	opts := publishOptions{deltaRuns: 2}
+
+	// Nothing to publish a delta from on the first run
+	err := uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("run1"), getLatestPrefixFilePrefix(),
+		createdAt, opts)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{
+		storage.JoinPath(getObjectPrefix("run1"), common.NetworkFileName),
+		storage.JoinPath(getObjectPrefix("run1"), common.ChecksumFileName),
+		storage.JoinPath(getObjectPrefix("run1"), common.ChangesFileName),
+		storage.JoinPath(getObjectPrefix("run1"), common.RunManifestFileName),
+	}, store.ObjectNames(getObjectPrefix("run1")))
+
+	require.Nil(t, provider.AddIPPrefix("region", "service", "2600:1f15::/32", common.GetDefaultRegionServicePairRedundancyCheck()))
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("run2"), getLatestPrefixFilePrefix(),
+		createdAt, opts)
+	require.Nil(t, err)
+
+	require.Nil(t, provider.AddIPPrefix("region2", "service", "3.5.140.0/22", common.GetDefaultRegionServicePairRedundancyCheck()))
+	err = uploadExternalNetworkSources(
+		ctx, &networks, readLatestRunForTest(t, store), store, getObjectPrefix("run3"), getLatestPrefixFilePrefix(),
+		createdAt, opts)
+	require.Nil(t, err)
+
+	uploaded, err := store.Get(ctx, storage.JoinPath(getLatestPrefixFilePrefix(), common.ManifestFileName))
+	require.Nil(t, err)
+	var manifest common.Manifest
+	require.Nil(t, json.Unmarshal(uploaded, &manifest))
+	require.Equal(t, []string{getObjectPrefix("run2"), getObjectPrefix("run1")}, manifest.DeltasFrom)
+
+	// Consumers of any of the runs can patch their networks into the latest ones
+	for _, baseRunFolder := range manifest.DeltasFrom {
+		baseNetworks, err := readRunNetworks(ctx, store, baseRunFolder)
+		require.Nil(t, err)
+		uploaded, err = store.Get(ctx, storage.JoinPath(getObjectPrefix("run3"), delta.FileName(baseRunFolder)))
+		require.Nil(t, err)
+		var d delta.Delta
+		require.Nil(t, json.Unmarshal(uploaded, &d))
+		patched, err := delta.ApplyAndVerify(baseNetworks, &d, &manifest)
+		require.Nil(t, err)
+		requireSameNetworks(t, &networks, patched)
+	}
+}
+
+// interceptingStore wraps a MemoryStore and calls onPut after every successful Put
+type interceptingStore struct {
+	*storage.MemoryStore
+	onPut func(ctx context.Context, objectPath string)
+}
+
+func (s *interceptingStore) Put(ctx context.Context, objectPath string, data []byte) error {
+	if err := s.MemoryStore.Put(ctx, objectPath, data); err != nil {
+		return err
+	}
+	s.onPut(ctx, objectPath)
+	return nil
+}
+
+func TestPublishRunDetectsConcurrentPublish(t *testing.T) {
+	ctx := context.Background()
+	latestPrefixPath := storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName)
+	store := &interceptingStore{MemoryStore: storage.NewMemoryStore()}
+	require.Nil(t, store.MemoryStore.Put(ctx, latestPrefixPath, []byte(getObjectPrefix("previous"))))
+	// Another run swaps latest prefix while this run is uploading
+	store.onPut = func(ctx context.Context, objectPath string) {
+		if objectPath != latestPrefixPath {
+			require.Nil(t, store.MemoryStore.Put(ctx, latestPrefixPath, []byte(getObjectPrefix("concurrent"))))
+		}
+	}
+
+	data := []byte("networks")
+	err := publishRun(ctx, store, getObjectPrefix("run"), getLatestPrefixFilePrefix(), data, common.Checksum(data), map[string][]byte{common.ChangesFileName: []byte("{}")}, []byte("{}"))
+	require.NotNil(t, err)
+	require.Equal(t, common.ConcurrentPublishError(latestPrefixPath, storage.ErrPreconditionFailed).Error(), err.Error())
+
+	// The concurrent run's pointer should be kept and this run's objects cleaned up
+	latest, err := store.Get(ctx, latestPrefixPath)
+	require.Nil(t, err)
+	require.Equal(t, getObjectPrefix("concurrent"), string(latest))
+	require.Empty(t, store.ObjectNames(getObjectPrefix("run")+"/"))
+}
+
+func TestPublishRunVerifiesUpload(t *testing.T) {
+	ctx := context.Background()
+	latestPrefixPath := storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName)
+	networksPath := storage.JoinPath(getObjectPrefix("run"), common.NetworkFileName)
+	store := &interceptingStore{MemoryStore: storage.NewMemoryStore()}
+	// Corrupt the networks file right after it is uploaded
+	store.onPut = func(ctx context.Context, objectPath string) {
+		if objectPath == networksPath {
+			require.Nil(t, store.MemoryStore.Put(ctx, networksPath, []byte("corrupted")))
+		}
+	}
+
+	data := []byte("networks")
+	err := publishRun(ctx, store, getObjectPrefix("run"), getLatestPrefixFilePrefix(), data, common.Checksum(data), map[string][]byte{common.ChangesFileName: []byte("{}")}, []byte("{}"))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), common.ChecksumMismatchError(networksPath, common.Checksum(data), common.Checksum([]byte("corrupted"))).Error())
+
+	// Latest prefix should not point to the corrupted run
+	_, err = store.Get(ctx, latestPrefixPath)
+	require.Equal(t, storage.ErrObjectNotFound, err)
+	require.Empty(t, store.ObjectNames(getObjectPrefix("run")+"/"))
+}
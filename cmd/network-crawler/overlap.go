@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/netindex"
+)
+
+// overlapPolicy decides what to do when a prefix of a provider overlaps a prefix of
+// another provider
+type overlapPolicy string
+
+const (
+	// overlapPolicyWarn only reports the overlaps
+	overlapPolicyWarn overlapPolicy = "warn"
+	// overlapPolicyFail refuses to publish
+	overlapPolicyFail overlapPolicy = "fail"
+	// overlapPolicyPriority removes the overlapping addresses from the provider with the
+	// lower priority
+	overlapPolicyPriority overlapPolicy = "priority"
+)
+
+func (p *overlapPolicy) String() string {
+	return string(*p)
+}
+
+func (p *overlapPolicy) Set(value string) error {
+	switch policy := overlapPolicy(value); policy {
+	case overlapPolicyWarn, overlapPolicyFail, overlapPolicyPriority:
+		*p = policy
+		return nil
+	default:
+		return errors.Errorf("unknown overlap policy %q. Acceptable policies are: %s, %s, %s",
+			value, overlapPolicyWarn, overlapPolicyFail, overlapPolicyPriority)
+	}
+}
+
+// providerOverlap is a prefix of a provider contained in a prefix of another provider.
+// Two prefixes overlap only if one contains the other.
+type providerOverlap struct {
+	inner netindex.Match
+	outer netindex.Match
+}
+
+func (o *providerOverlap) String() string {
+	return fmt.Sprintf("%s of %s (%s/%s) is inside %s of %s (%s/%s)",
+		o.inner.Prefix, o.inner.Provider, o.inner.Region, o.inner.Service,
+		o.outer.Prefix, o.outer.Provider, o.outer.Region, o.outer.Service)
+}
+
+// findProviderOverlaps returns every prefix of a provider contained in a prefix of
+// another provider, for every region and service of both. Identical prefixes of two
+// providers are reported once.
+func findProviderOverlaps(networks *common.ExternalNetworkSources) ([]*providerOverlap, error) {
+	index, err := netindex.New(networks)
+	if err != nil {
+		return nil, err
+	}
+	var overlaps []*providerOverlap
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+					for _, prefix := range prefixes {
+						parsed, err := common.CanonicalIPPrefix(prefix)
+						if err != nil {
+							return nil, err
+						}
+						inner := netindex.Match{
+							Prefix:   parsed,
+							Provider: provider.ProviderName,
+							Region:   region.RegionName,
+							Service:  service.ServiceName,
+						}
+						for _, outer := range index.Covering(parsed) {
+							if outer.Provider == inner.Provider {
+								continue
+							}
+							if outer.Prefix == inner.Prefix && outer.Provider < inner.Provider {
+								// Already reported from the other provider
+								continue
+							}
+							overlaps = append(overlaps, &providerOverlap{inner: inner, outer: outer})
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(overlaps, func(i, j int) bool {
+		a, b := overlaps[i], overlaps[j]
+		if a.inner.Prefix != b.inner.Prefix {
+			return common.CompareIPPrefixes(a.inner.Prefix.String(), b.inner.Prefix.String()) < 0
+		}
+		if a.inner != b.inner {
+			return a.inner.Less(b.inner)
+		}
+		if a.outer.Prefix != b.outer.Prefix {
+			return common.CompareIPPrefixes(a.outer.Prefix.String(), b.outer.Prefix.String()) < 0
+		}
+		return a.outer.Less(b.outer)
+	})
+	return overlaps, nil
+}
+
+// checkProviderOverlaps reports the prefixes that overlap across providers, which make
+// lookups ambiguous. Depending on the policy, it then fails or removes the overlapping
+// addresses from the providers with the lower priority. Providers are given by
+// decreasing priority.
+func checkProviderOverlaps(
+	networks *common.ExternalNetworkSources,
+	policy overlapPolicy,
+	priority []common.Provider,
+) error {
+	overlaps, err := findProviderOverlaps(networks)
+	if err != nil {
+		return errors.Wrap(err, "failed to index networks")
+	}
+	if len(overlaps) == 0 {
+		log.Print("No prefix overlaps across providers.")
+		return nil
+	}
+	for _, overlap := range overlaps {
+		log.Print(color.YellowString("Overlap across providers: %s", overlap))
+	}
+
+	switch policy {
+	case overlapPolicyFail:
+		return common.ProviderOverlapError(len(overlaps))
+	case overlapPolicyPriority:
+		return resolveProviderOverlaps(networks, overlaps, priority)
+	default:
+		log.Print(color.YellowString("Found %d prefix overlaps across providers", len(overlaps)))
+		return nil
+	}
+}
+
+// resolveProviderOverlaps keeps the overlapping addresses in the provider with the
+// higher priority, and replaces the prefix of the other provider with the prefixes of
+// its remaining addresses, if any. Overlaps between two providers without priority
+// are not resolved.
+func resolveProviderOverlaps(
+	networks *common.ExternalNetworkSources,
+	overlaps []*providerOverlap,
+	priority []common.Provider,
+) error {
+	ranks := make(map[string]int, len(priority))
+	for i, provider := range priority {
+		ranks[provider.String()] = i
+	}
+	rank := func(provider string) int {
+		if r, ok := ranks[provider]; ok {
+			return r
+		}
+		return len(priority)
+	}
+
+	// The prefixes of the other providers to remove from every prefix of a lower priority provider
+	type location struct {
+		provider, region, service string
+		prefix                    netip.Prefix
+	}
+	var losers []location
+	removals := make(map[location][]netip.Prefix)
+	numUnresolved := 0
+	for _, overlap := range overlaps {
+		innerRank, outerRank := rank(overlap.inner.Provider), rank(overlap.outer.Provider)
+		if innerRank == outerRank {
+			numUnresolved++
+			continue
+		}
+		loser, winner := overlap.inner, overlap.outer
+		if innerRank < outerRank {
+			loser, winner = overlap.outer, overlap.inner
+		}
+		key := location{provider: loser.Provider, region: loser.Region, service: loser.Service, prefix: loser.Prefix}
+		if _, ok := removals[key]; !ok {
+			losers = append(losers, key)
+		}
+		removals[key] = append(removals[key], winner.Prefix)
+	}
+
+	providers := make(map[string]*common.ProviderNetworkRanges, len(networks.ProviderNetworks))
+	for _, provider := range networks.ProviderNetworks {
+		providers[provider.ProviderName] = provider
+	}
+	for _, loser := range losers {
+		var replacements []string
+		for _, prefix := range subtractPrefixes(loser.prefix, removals[loser]) {
+			replacements = append(replacements, prefix.String())
+		}
+		err := providers[loser.provider].ReplaceIPPrefix(loser.region, loser.service, loser.prefix.String(), replacements)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve overlaps of %s of provider %s", loser.prefix, loser.provider)
+		}
+		log.Printf("Replaced %s of provider %s (%s/%s) with %d prefixes not claimed by higher priority providers",
+			loser.prefix, loser.provider, loser.region, loser.service, len(replacements))
+	}
+	for _, provider := range networks.ProviderNetworks {
+		if len(provider.RegionNetworks) == 0 {
+			log.Print(color.YellowString(
+				"Provider %s has no prefix left after resolving overlaps with higher priority providers",
+				provider.ProviderName))
+		}
+	}
+	if numUnresolved > 0 {
+		log.Print(color.YellowString(
+			"%d prefix overlaps across providers are not resolved, since the providers have the same priority",
+			numUnresolved))
+	}
+	return nil
+}
+
+// subtractPrefixes returns the smallest set of prefixes covering the addresses of the
+// prefix that none of the holes contain, sorted
+func subtractPrefixes(prefix netip.Prefix, holes []netip.Prefix) []netip.Prefix {
+	overlapping := false
+	for _, hole := range holes {
+		if hole.Bits() <= prefix.Bits() && hole.Contains(prefix.Addr()) {
+			return nil
+		}
+		if hole.Overlaps(prefix) {
+			overlapping = true
+		}
+	}
+	if !overlapping {
+		return []netip.Prefix{prefix}
+	}
+	// A hole is inside the prefix, look at both of its halves
+	lower := netip.PrefixFrom(prefix.Addr(), prefix.Bits()+1)
+	upperAddr := prefix.Addr().AsSlice()
+	upperAddr[prefix.Bits()/8] |= 0x80 >> (prefix.Bits() % 8)
+	upper, _ := netip.AddrFromSlice(upperAddr)
+	return append(
+		subtractPrefixes(lower, holes),
+		subtractPrefixes(netip.PrefixFrom(upper, prefix.Bits()+1), holes)...)
+}
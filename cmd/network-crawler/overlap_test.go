@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newOverlappingNetworks(t *testing.T) *common.ExternalNetworkSources {
+	amazon := common.NewProviderNetworkRanges(common.Amazon.String())
+	require.Nil(t, amazon.AddIPPrefix("us-east-1", "EC2", "104.16.0.0/12", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, amazon.AddIPPrefix("us-east-1", "S3", "52.216.0.0/15", common.GetDefaultRegionServicePairRedundancyCheck()))
+	cloudflare := common.NewProviderNetworkRanges(common.Cloudflare.String())
+	require.Nil(t, cloudflare.AddIPPrefix("default", "default", "104.16.0.0/13", common.GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, cloudflare.AddIPPrefix("default", "default", "2400:cb00::/32", common.GetDefaultRegionServicePairRedundancyCheck()))
+	google := common.NewProviderNetworkRanges(common.Google.String())
+	require.Nil(t, google.AddIPPrefix("global", "Google Cloud", "52.216.0.0/15", common.GetDefaultRegionServicePairRedundancyCheck()))
+	return newNetworks(amazon, cloudflare, google)
+}
+
+func TestFindProviderOverlaps(t *testing.T) {
+	overlaps, err := findProviderOverlaps(newOverlappingNetworks(t))
+	require.Nil(t, err)
+	strs := make([]string, 0, len(overlaps))
+	for _, overlap := range overlaps {
+		strs = append(strs, overlap.String())
+	}
+	require.Equal(t, []string{
+		"52.216.0.0/15 of Amazon (us-east-1/S3) is inside 52.216.0.0/15 of Google (global/Google Cloud)",
+		"104.16.0.0/13 of Cloudflare (default/default) is inside 104.16.0.0/12 of Amazon (us-east-1/EC2)",
+	}, strs)
+
+	noOverlaps := newNetworks(newProviderWithPrefixes(t, "Amazon", 2), newProviderWithPrefixes(t, "Amazon", 1))
+	noOverlaps.ProviderNetworks[1].ProviderName = "Google"
+	noOverlaps.ProviderNetworks[1].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes = []string{"10.0.0.0/8"}
+	overlaps, err = findProviderOverlaps(noOverlaps)
+	require.Nil(t, err)
+	require.Empty(t, overlaps)
+}
+
+func TestCheckProviderOverlaps(t *testing.T) {
+	networks := newOverlappingNetworks(t)
+	require.Nil(t, checkProviderOverlaps(networks, overlapPolicyWarn, nil))
+	requireSameNetworks(t, newOverlappingNetworks(t), networks)
+
+	err := checkProviderOverlaps(networks, overlapPolicyFail, nil)
+	require.Equal(t, common.ProviderOverlapError(2).Error(), err.Error())
+
+	// Cloudflare keeps its prefix, Amazon keeps the rest of its block
+	require.Nil(t, checkProviderOverlaps(networks, overlapPolicyPriority, []common.Provider{common.Cloudflare, common.Amazon}))
+	networks.Canonicalize()
+	require.Equal(t, []*common.RegionNetworkDetail{{
+		RegionName: "us-east-1",
+		ServiceNetworks: []*common.ServiceIPRanges{
+			{ServiceName: "EC2", IPv4Prefixes: []string{"104.24.0.0/13"}},
+			{ServiceName: "S3", IPv4Prefixes: []string{"52.216.0.0/15"}},
+		},
+	}}, networks.ProviderNetworks[0].RegionNetworks)
+	require.Equal(t, []string{"104.16.0.0/13"}, networks.ProviderNetworks[1].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+	// Google is not in the priority list, so Amazon keeps the identical prefix and
+	// Google loses it, along with its only region
+	require.Empty(t, networks.ProviderNetworks[2].RegionNetworks)
+	overlaps, err := findProviderOverlaps(networks)
+	require.Nil(t, err)
+	require.Empty(t, overlaps)
+
+	// Providers without priority are not resolved
+	networks = newOverlappingNetworks(t)
+	require.Nil(t, checkProviderOverlaps(networks, overlapPolicyPriority, []common.Provider{common.Azure}))
+	requireSameNetworks(t, newOverlappingNetworks(t), networks)
+}
+
+func TestPublishValidatesResolvedOverlaps(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	var crawlers []common.NetworkCrawler
+	for _, provider := range newOverlappingNetworks(t).ProviderNetworks {
+		crawlers = append(crawlers, &fakeCrawler{provider: common.Provider(provider.ProviderName), networks: provider})
+	}
+	opts := publishOptions{
+		maxConcurrentCrawlers: 1,
+		crawlerTimeout:        time.Minute,
+		overlapPolicy:         overlapPolicyPriority,
+		providerPriority:      []common.Provider{common.Cloudflare, common.Amazon},
+		bogonOptions:          common.BogonOptions{Policy: common.BogonPolicyDrop},
+	}
+
+	// Google loses its only prefix to Amazon, so there is nothing left to publish for it
+	err := publishExternalNetworks(ctx, store, crawlers, opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), common.NoRegionNetworksError(common.Google.String()).Error())
+	latestRun, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.Nil(t, latestRun)
+}
+
+func TestSubtractPrefixes(t *testing.T) {
+	parse := func(prefixes ...string) []netip.Prefix {
+		result := make([]netip.Prefix, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			result = append(result, netip.MustParsePrefix(prefix))
+		}
+		return result
+	}
+	require.Equal(t, parse("10.0.0.0/24"), subtractPrefixes(netip.MustParsePrefix("10.0.0.0/24"), parse("10.0.1.0/24")))
+	require.Empty(t, subtractPrefixes(netip.MustParsePrefix("10.0.0.0/24"), parse("10.0.0.0/16")))
+	require.Equal(t,
+		parse("10.0.0.0/25", "10.0.0.128/27", "10.0.0.176/28", "10.0.0.192/26"),
+		subtractPrefixes(netip.MustParsePrefix("10.0.0.0/24"), parse("10.0.0.160/28")))
+	require.Equal(t,
+		parse("2600::/17", "2600:8000::/18", "2600:c000::/19"),
+		subtractPrefixes(netip.MustParsePrefix("2600::/16"), parse("2600:e000::/19")))
+}
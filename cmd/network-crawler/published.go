@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+)
+
+// readLatestRunFolder returns the folder of the latest published run, as pointed
+// to by the latest prefix file. An empty string is returned if nothing has been
+// published yet.
+func readLatestRunFolder(ctx context.Context, store storage.Store) (string, error) {
+	latestPrefixPath := storage.JoinPath(getLatestPrefixFilePrefix(), common.LatestPrefixFileName)
+	data, err := store.Get(ctx, latestPrefixPath)
+	if errors.Cause(err) == storage.ErrObjectNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", latestPrefixPath)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// publishedRun is a run published to the destination
+type publishedRun struct {
+	runFolder string
+	networks  *common.ExternalNetworkSources
+	// summarized is true if the networks of the run were summarized
+	summarized bool
+}
+
+// readLatestRun returns the latest published run. Nil is returned if nothing has
+// been published yet.
+func readLatestRun(ctx context.Context, store storage.Store) (*publishedRun, error) {
+	runFolder, err := readLatestRunFolder(ctx, store)
+	if err != nil || runFolder == "" {
+		return nil, err
+	}
+	networks, err := readRunNetworks(ctx, store, runFolder)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readLatestRunManifest(ctx, store, runFolder)
+	if err != nil {
+		return nil, err
+	}
+	run := &publishedRun{runFolder: runFolder, networks: networks}
+	if manifest != nil {
+		run.summarized = manifest.Summarized
+	}
+	return run, nil
+}
+
+// readLatestRunManifest returns the manifest of the latest run. Runs published before
+// manifests were published in run folders only have the latest manifest, which is used
+// if it is the manifest of the run. Nil is returned if there is no manifest of the run.
+func readLatestRunManifest(ctx context.Context, store storage.Store, runFolder string) (*common.Manifest, error) {
+	for _, manifestPath := range []string{
+		storage.JoinPath(runFolder, common.RunManifestFileName),
+		storage.JoinPath(getLatestPrefixFilePrefix(), common.ManifestFileName),
+	} {
+		data, err := store.Get(ctx, manifestPath)
+		if errors.Cause(err) == storage.ErrObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", manifestPath)
+		}
+		var manifest common.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s", manifestPath)
+		}
+		if manifest.RunFolder == runFolder {
+			return &manifest, nil
+		}
+	}
+	return nil, nil
+}
+
+// readRunNetworks returns the networks published in the run folder
+func readRunNetworks(ctx context.Context, store storage.Store, runFolder string) (*common.ExternalNetworkSources, error) {
+	networksPath := storage.JoinPath(runFolder, common.NetworkFileName)
+	data, err := store.Get(ctx, networksPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", networksPath)
+	}
+	var networks common.ExternalNetworkSources
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s", networksPath)
+	}
+	return &networks, nil
+}
+
+// listRunFolders returns the folders of all the runs in the destination, oldest first
+func listRunFolders(ctx context.Context, store storage.Store) ([]string, error) {
+	prefixes, err := store.ListPrefixes(ctx, common.MasterBucketPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed getting all prefixes under %s", store)
+	}
+	runFolders := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		// The latest prefix file is directly under the master prefix
+		if prefix != getLatestPrefixFilePrefix() {
+			runFolders = append(runFolders, prefix)
+		}
+	}
+	// Run folders start with their timestamp
+	sort.Strings(runFolders)
+	return runFolders, nil
+}
+
+// readPreviousRunFolder returns the folder of the run published before the latest
+// one. An empty string is returned if there is no such run.
+func readPreviousRunFolder(ctx context.Context, store storage.Store) (string, error) {
+	latestRunFolder, err := readLatestRunFolder(ctx, store)
+	if err != nil || latestRunFolder == "" {
+		return "", err
+	}
+	runFolders, err := listRunFolders(ctx, store)
+	if err != nil {
+		return "", err
+	}
+	previousRunFolder := ""
+	for _, runFolder := range runFolders {
+		if runFolder >= latestRunFolder {
+			break
+		}
+		previousRunFolder = runFolder
+	}
+	return previousRunFolder, nil
+}
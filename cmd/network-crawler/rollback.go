@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"slices"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+// rollbackPolicy decides what to do when a provider's upstream feed is older than
+// the one in the latest published run
+type rollbackPolicy string
+
+const (
+	// rollbackPolicyFail refuses to publish
+	rollbackPolicyFail rollbackPolicy = "fail"
+	// rollbackPolicyKeepPrevious publishes the provider's networks of the latest published run instead
+	rollbackPolicyKeepPrevious rollbackPolicy = "keep-previous"
+)
+
+func (p *rollbackPolicy) String() string {
+	return string(*p)
+}
+
+func (p *rollbackPolicy) Set(value string) error {
+	switch policy := rollbackPolicy(value); policy {
+	case rollbackPolicyFail, rollbackPolicyKeepPrevious:
+		*p = policy
+		return nil
+	default:
+		return errors.Errorf("unknown rollback policy %q. Acceptable policies are: %s, %s",
+			value, rollbackPolicyFail, rollbackPolicyKeepPrevious)
+	}
+}
+
+// guardAgainstFeedRollback compares the upstream feed versions of the crawled networks with
+// the ones recorded in the latest published networks. Syncing from a stale CDN cache or a bad
+// mirror would otherwise silently publish outdated networks. Depending on the policy, it
+// either fails or replaces the networks of the rolled back providers with the previously
+// published ones, and returns the names of the providers it replaced.
+func guardAgainstFeedRollback(
+	networks *common.ExternalNetworkSources,
+	latestNetworks *common.ExternalNetworkSources,
+	policy rollbackPolicy,
+) ([]string, error) {
+	if latestNetworks == nil {
+		return nil, nil
+	}
+	latestProviders := make(map[string]*common.ProviderNetworkRanges, len(latestNetworks.ProviderNetworks))
+	for _, provider := range latestNetworks.ProviderNetworks {
+		latestProviders[provider.ProviderName] = provider
+	}
+
+	var keptProviders []string
+	for i, provider := range networks.ProviderNetworks {
+		latestProvider, ok := latestProviders[provider.ProviderName]
+		if !ok {
+			continue
+		}
+		rollbackErr := provider.CheckFeedRollback(latestProvider)
+		if rollbackErr == nil {
+			continue
+		}
+		if policy != rollbackPolicyKeepPrevious {
+			return nil, rollbackErr
+		}
+		log.Print(color.YellowString(
+			"%v. Keeping the networks of the latest published run for provider %s",
+			rollbackErr,
+			provider.ProviderName))
+		networks.ProviderNetworks[i] = latestProvider.Copy()
+		keptProviders = append(keptProviders, provider.ProviderName)
+	}
+	return keptProviders, nil
+}
+
+// setAsideProviders removes the providers from the networks and returns their networks
+func setAsideProviders(networks *common.ExternalNetworkSources, providerNames []string) []*common.ProviderNetworkRanges {
+	var setAside []*common.ProviderNetworkRanges
+	remaining := networks.ProviderNetworks[:0]
+	for _, provider := range networks.ProviderNetworks {
+		if slices.Contains(providerNames, provider.ProviderName) {
+			setAside = append(setAside, provider)
+			continue
+		}
+		remaining = append(remaining, provider)
+	}
+	networks.ProviderNetworks = remaining
+	return setAside
+}
+
+// crawlersExcept returns the crawlers of the providers other than the given ones
+func crawlersExcept(crawlers []common.NetworkCrawler, providerNames []string) []common.NetworkCrawler {
+	var others []common.NetworkCrawler
+	for _, crawler := range crawlers {
+		if !slices.Contains(providerNames, crawler.GetProviderKey().String()) {
+			others = append(others, crawler)
+		}
+	}
+	return others
+}
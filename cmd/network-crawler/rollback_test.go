@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/diff"
+	"github.com/stackrox/external-network-pusher/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newProviderWithSource(t *testing.T, providerName, ipPrefix, version string) *common.ProviderNetworkRanges {
+	provider := common.NewProviderNetworkRanges(providerName)
+	require.Nil(t, provider.AddIPPrefix("region", "service", ipPrefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+	provider.AddSource(&common.SourceMetadata{URL: "https://example.com/" + providerName, Version: version})
+	return provider
+}
+
+func TestGuardAgainstFeedRollback(t *testing.T) {
+	// Nothing published yet
+	networks := &common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{
+			newProviderWithSource(t, "provider1", "35.180.0.0/16", "10"),
+		},
+	}
+	keptProviders, err := guardAgainstFeedRollback(networks, nil, rollbackPolicyFail)
+	require.Nil(t, err)
+	require.Empty(t, keptProviders)
+
+	latestNetworks := &common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{
+			newProviderWithSource(t, "provider1", "35.180.0.0/16", "10"),
+			newProviderWithSource(t, "provider2", "3.5.140.0/22", "20"),
+		},
+	}
+
+	// Same or newer versions are fine
+	networks = &common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{
+			newProviderWithSource(t, "provider1", "35.181.0.0/16", "10"),
+			newProviderWithSource(t, "provider2", "3.5.144.0/22", "21"),
+		},
+	}
+	keptProviders, err = guardAgainstFeedRollback(networks, latestNetworks, rollbackPolicyFail)
+	require.Nil(t, err)
+	require.Empty(t, keptProviders)
+	require.Equal(t, "35.181.0.0/16", networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes[0])
+
+	// An older version is refused
+	networks = &common.ExternalNetworkSources{
+		ProviderNetworks: []*common.ProviderNetworkRanges{
+			newProviderWithSource(t, "provider1", "35.181.0.0/16", "11"),
+			newProviderWithSource(t, "provider2", "3.5.144.0/22", "9"),
+		},
+	}
+	_, err = guardAgainstFeedRollback(networks, latestNetworks, rollbackPolicyFail)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "provider2")
+
+	// Or replaced with the previously published networks
+	keptProviders, err = guardAgainstFeedRollback(networks, latestNetworks, rollbackPolicyKeepPrevious)
+	require.Nil(t, err)
+	require.Equal(t, []string{"provider2"}, keptProviders)
+	require.Equal(t, "35.181.0.0/16", networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes[0])
+	require.Equal(t, "3.5.140.0/22", networks.ProviderNetworks[1].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes[0])
+	require.Equal(t, "20", networks.ProviderNetworks[1].Sources[0].Version)
+	require.NotSame(t, latestNetworks.ProviderNetworks[1], networks.ProviderNetworks[1])
+}
+
+func TestRollbackPolicyFlag(t *testing.T) {
+	var policy rollbackPolicy
+	require.Nil(t, policy.Set("keep-previous"))
+	require.Equal(t, rollbackPolicyKeepPrevious, policy)
+	require.Error(t, policy.Set("ignore"))
+	require.Equal(t, rollbackPolicyKeepPrevious, policy)
+}
+
+func TestPublishKeepsLatestNetworksOnRollback(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	crawlers := func(version string, ipPrefixes ...string) []common.NetworkCrawler {
+		provider := common.NewProviderNetworkRanges(common.Google.String())
+		for _, ipPrefix := range ipPrefixes {
+			require.Nil(t, provider.AddIPPrefix("region", "service", ipPrefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+		}
+		provider.AddSource(&common.SourceMetadata{URL: "https://example.com", Version: version})
+		return []common.NetworkCrawler{&fakeCrawler{provider: common.Google, networks: provider}}
+	}
+	opts := publishOptions{
+		maxConcurrentCrawlers: 1,
+		crawlerTimeout:        time.Minute,
+		rollbackPolicy:        rollbackPolicyKeepPrevious,
+		overlapPolicy:         overlapPolicyWarn,
+		bogonOptions:          common.BogonOptions{Policy: common.BogonPolicyDrop},
+	}
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers("10", "35.180.0.0/25", "35.180.0.128/25"), opts))
+	latestRun, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+
+	// The feed went backwards so the latest published networks are kept, and then
+	// summarized. Summarizing must not modify the latest published networks, or the
+	// summarized networks would be found unchanged and not be published.
+	opts.summarize = true
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers("9", "35.181.0.0/24"), opts))
+	run, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.NotEqual(t, latestRun.runFolder, run.runFolder)
+	require.Equal(t, []string{"35.180.0.0/24"}, run.networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+	require.Equal(t, "10", run.networks.ProviderNetworks[0].Sources[0].Version)
+
+	changes, err := store.Get(ctx, storage.JoinPath(run.runFolder, common.ChangesFileName))
+	require.Nil(t, err)
+	var changelog diff.Changelog
+	require.Nil(t, json.Unmarshal(changes, &changelog))
+	require.Equal(t, latestRun.runFolder, changelog.Previous)
+	require.Len(t, changelog.Providers, 1)
+	require.Equal(t, 1, changelog.Providers[0].Added)
+	require.Equal(t, 2, changelog.Providers[0].Removed)
+}
+
+func TestPublishDoesNotReprocessKeptNetworks(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	crawlers := func(version string, ipPrefixes ...string) []common.NetworkCrawler {
+		provider := common.NewProviderNetworkRanges(common.Google.String())
+		for _, ipPrefix := range ipPrefixes {
+			require.Nil(t, provider.AddIPPrefix("region", "service", ipPrefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+		}
+		provider.AddSource(&common.SourceMetadata{URL: "https://example.com", Version: version})
+		return []common.NetworkCrawler{&fakeCrawler{provider: common.Google, networks: provider, numRequired: 2}}
+	}
+	opts := publishOptions{
+		maxConcurrentCrawlers: 1,
+		crawlerTimeout:        time.Minute,
+		rollbackPolicy:        rollbackPolicyKeepPrevious,
+		overlapPolicy:         overlapPolicyWarn,
+		bogonOptions:          common.BogonOptions{Policy: common.BogonPolicyDrop},
+		summarize:             true,
+	}
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers("10", "35.180.0.0/25", "35.180.0.128/25"), opts))
+	latestRun, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.Equal(t, []string{"35.180.0.0/24"}, latestRun.networks.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+
+	// The kept networks were summarized to fewer prefixes than the crawler requires, which
+	// was checked on the crawled prefixes when they were published
+	require.Nil(t, publishExternalNetworks(ctx, store, crawlers("9", "35.181.0.0/24", "35.182.0.0/24"), opts))
+	run, err := readLatestRun(ctx, store)
+	require.Nil(t, err)
+	require.Equal(t, latestRun.runFolder, run.runFolder)
+}
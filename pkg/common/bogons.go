@@ -0,0 +1,184 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/pkg/errors"
+)
+
+// BogonPolicy decides what to do with crawled prefixes that are not publicly routable
+type BogonPolicy string
+
+const (
+	// BogonPolicyDrop removes the offending prefixes before publishing
+	BogonPolicyDrop BogonPolicy = "drop"
+	// BogonPolicyFail refuses to publish
+	BogonPolicyFail BogonPolicy = "fail"
+)
+
+func (p *BogonPolicy) String() string {
+	return string(*p)
+}
+
+// Set sets the policy from its name, so that it can be used as a flag
+func (p *BogonPolicy) Set(value string) error {
+	switch policy := BogonPolicy(value); policy {
+	case BogonPolicyDrop, BogonPolicyFail:
+		*p = policy
+		return nil
+	default:
+		return errors.Errorf("unknown bogon policy %q. Acceptable policies are: %s, %s",
+			value, BogonPolicyDrop, BogonPolicyFail)
+	}
+}
+
+// BogonOptions contains the options of FilterBogons
+type BogonOptions struct {
+	Policy BogonPolicy
+	// MinIPv4PrefixLen and MinIPv6PrefixLen are the lengths below which a prefix is
+	// considered too broad to belong to a single provider. 0 disables the check.
+	MinIPv4PrefixLen int
+	MinIPv6PrefixLen int
+}
+
+// specialPurposePrefix is an entry of the IANA IPv4 and IPv6 special-purpose address
+// registries, or another range that is never routed on the public internet
+type specialPurposePrefix struct {
+	prefix netip.Prefix
+	name   string
+}
+
+var (
+	specialPurposeIPv4Prefixes = []specialPurposePrefix{
+		{netip.MustParsePrefix("0.0.0.0/8"), "\"this network\" (RFC 791)"},
+		{netip.MustParsePrefix("10.0.0.0/8"), "private use (RFC 1918)"},
+		{netip.MustParsePrefix("100.64.0.0/10"), "shared address space, CGNAT (RFC 6598)"},
+		{netip.MustParsePrefix("127.0.0.0/8"), "loopback (RFC 1122)"},
+		{netip.MustParsePrefix("169.254.0.0/16"), "link-local (RFC 3927)"},
+		{netip.MustParsePrefix("172.16.0.0/12"), "private use (RFC 1918)"},
+		{netip.MustParsePrefix("192.0.0.0/24"), "IETF protocol assignments (RFC 6890)"},
+		{netip.MustParsePrefix("192.0.2.0/24"), "documentation, TEST-NET-1 (RFC 5737)"},
+		{netip.MustParsePrefix("192.88.99.0/24"), "deprecated 6to4 relay anycast (RFC 7526)"},
+		{netip.MustParsePrefix("192.168.0.0/16"), "private use (RFC 1918)"},
+		{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking (RFC 2544)"},
+		{netip.MustParsePrefix("198.51.100.0/24"), "documentation, TEST-NET-2 (RFC 5737)"},
+		{netip.MustParsePrefix("203.0.113.0/24"), "documentation, TEST-NET-3 (RFC 5737)"},
+		{netip.MustParsePrefix("224.0.0.0/4"), "multicast (RFC 5771)"},
+		{netip.MustParsePrefix("240.0.0.0/4"), "reserved, including limited broadcast (RFC 1112, RFC 919)"},
+	}
+	specialPurposeIPv6Prefixes = []specialPurposePrefix{
+		{netip.MustParsePrefix("::/128"), "unspecified address (RFC 4291)"},
+		{netip.MustParsePrefix("::1/128"), "loopback (RFC 4291)"},
+		{netip.MustParsePrefix("64:ff9b::/96"), "IPv4-IPv6 translation (RFC 6052)"},
+		{netip.MustParsePrefix("64:ff9b:1::/48"), "local-use IPv4-IPv6 translation (RFC 8215)"},
+		{netip.MustParsePrefix("100::/64"), "discard-only (RFC 6666)"},
+		{netip.MustParsePrefix("2001:2::/48"), "benchmarking (RFC 5180)"},
+		{netip.MustParsePrefix("2001:db8::/32"), "documentation (RFC 3849)"},
+		{netip.MustParsePrefix("3fff::/20"), "documentation (RFC 9637)"},
+		{netip.MustParsePrefix("fc00::/7"), "unique local (RFC 4193)"},
+		{netip.MustParsePrefix("fe80::/10"), "link-local (RFC 4291)"},
+		{netip.MustParsePrefix("ff00::/8"), "multicast (RFC 4291)"},
+	}
+	// ipv6GlobalUnicast is the only IPv6 range allocated for global unicast addresses
+	ipv6GlobalUnicast = netip.MustParsePrefix("2000::/3")
+)
+
+// CheckBogon returns why the prefix is not publicly routable, and whether it is not.
+// A prefix is not publicly routable if it is a default route, if it overlaps a range
+// of the IANA special-purpose address registries, if it is an IPv6 prefix outside the
+// global unicast space, or if it is shorter than the minimum length of its IP version.
+func CheckBogon(prefix netip.Prefix, minIPv4PrefixLen, minIPv6PrefixLen int) (string, bool) {
+	if prefix.Bits() == 0 {
+		return "default route", true
+	}
+	specialPurposePrefixes, minPrefixLen := specialPurposeIPv4Prefixes, minIPv4PrefixLen
+	if prefix.Addr().Is6() {
+		specialPurposePrefixes, minPrefixLen = specialPurposeIPv6Prefixes, minIPv6PrefixLen
+	}
+	for _, special := range specialPurposePrefixes {
+		if prefix.Overlaps(special.prefix) {
+			return fmt.Sprintf("overlaps %s, %s", special.prefix, special.name), true
+		}
+	}
+	if prefix.Addr().Is6() && !ipv6GlobalUnicast.Contains(prefix.Addr()) {
+		return fmt.Sprintf("outside the global unicast space %s", ipv6GlobalUnicast), true
+	}
+	if prefix.Bits() < minPrefixLen {
+		return fmt.Sprintf("shorter than the minimum prefix length /%d", minPrefixLen), true
+	}
+	return "", false
+}
+
+// BogonPrefix is a crawled prefix that is not publicly routable
+type BogonPrefix struct {
+	ProviderName string
+	Region       string
+	Service      string
+	Prefix       string
+	Reason       string
+}
+
+func (b *BogonPrefix) String() string {
+	return fmt.Sprintf("%s of %s (%s/%s): %s", b.Prefix, b.ProviderName, b.Region, b.Service, b.Reason)
+}
+
+// BogonReport lists the prefixes FilterBogons found not publicly routable, and the
+// policy they were handled with
+type BogonReport struct {
+	Policy BogonPolicy
+	Bogons []*BogonPrefix
+}
+
+// FilterBogons checks every prefix of every provider with CheckBogon. With
+// BogonPolicyDrop the offending prefixes are removed from the networks, along with
+// their services and regions if they have no prefix left. With BogonPolicyFail the
+// networks are left untouched and BogonPrefixesError is returned if any prefix is
+// offending. Strings that are not valid prefixes are not checked.
+func FilterBogons(networks *ExternalNetworkSources, opts BogonOptions) (*BogonReport, error) {
+	report := &BogonReport{Policy: opts.Policy}
+	bogonsPerProvider := make(map[*ProviderNetworkRanges][]*BogonPrefix)
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+					for _, ipPrefix := range prefixes {
+						prefix, err := netip.ParsePrefix(ipPrefix)
+						if err != nil {
+							continue
+						}
+						reason, isBogon := CheckBogon(prefix, opts.MinIPv4PrefixLen, opts.MinIPv6PrefixLen)
+						if !isBogon {
+							continue
+						}
+						bogon := &BogonPrefix{
+							ProviderName: provider.ProviderName,
+							Region:       region.RegionName,
+							Service:      service.ServiceName,
+							Prefix:       ipPrefix,
+							Reason:       reason,
+						}
+						report.Bogons = append(report.Bogons, bogon)
+						bogonsPerProvider[provider] = append(bogonsPerProvider[provider], bogon)
+					}
+				}
+			}
+		}
+	}
+	if len(report.Bogons) == 0 {
+		return report, nil
+	}
+	if opts.Policy != BogonPolicyDrop {
+		return report, BogonPrefixesError(report)
+	}
+
+	// Remove once everything was checked, since removing changes the slices being iterated
+	for provider, bogons := range bogonsPerProvider {
+		for _, bogon := range bogons {
+			if err := provider.ReplaceIPPrefix(bogon.Region, bogon.Service, bogon.Prefix, nil); err != nil {
+				return report, errors.Wrapf(err, "failed to drop prefix %s", bogon)
+			}
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckBogon(t *testing.T) {
+	for prefix, isBogon := range map[string]bool{
+		"8.8.8.0/24":         false,
+		"35.190.0.0/17":      false,
+		"3.0.0.0/9":          false,
+		"2600:1f00::/24":     false,
+		"2001:4860::/32":     false,
+		"0.0.0.0/0":          true,
+		"::/0":               true,
+		"0.0.0.0/24":         true,
+		"10.1.0.0/16":        true,
+		"8.0.0.0/6":          true,
+		"100.64.0.0/16":      true,
+		"127.0.0.1/32":       true,
+		"169.254.169.254/32": true,
+		"172.20.0.0/16":      true,
+		"192.168.1.0/24":     true,
+		"192.0.2.0/24":       true,
+		"198.51.100.0/25":    true,
+		"203.0.113.0/24":     true,
+		"224.0.0.0/24":       true,
+		"255.255.255.255/32": true,
+		"2001:db8::/48":      true,
+		"fd00::/8":           true,
+		"fe80::/64":          true,
+		"ff02::/16":          true,
+		"::1/128":            true,
+		"4000::/16":          true,
+		"2000::/8":           true,
+		"1.0.0.0/7":          true,
+	} {
+		_, actual := CheckBogon(netip.MustParsePrefix(prefix), 8, 16)
+		require.Equal(t, isBogon, actual, prefix)
+	}
+
+	reason, isBogon := CheckBogon(netip.MustParsePrefix("10.0.0.0/24"), 8, 16)
+	require.True(t, isBogon)
+	require.Equal(t, "overlaps 10.0.0.0/8, private use (RFC 1918)", reason)
+
+	// 0 disables the minimum prefix length
+	_, isBogon = CheckBogon(netip.MustParsePrefix("2400::/8"), 0, 0)
+	require.False(t, isBogon)
+}
+
+func newBogonTestNetworks(t *testing.T) *ExternalNetworkSources {
+	provider := NewProviderNetworkRanges("provider")
+	for _, prefix := range []struct{ region, service, prefix string }{
+		{"region1", "service1", "8.8.8.0/24"},
+		{"region1", "service1", "192.168.0.0/24"},
+		{"region1", "service2", "2001:db8::/32"},
+		{"region2", "service1", "10.0.0.0/8"},
+	} {
+		require.Nil(t, provider.AddIPPrefix(
+			prefix.region, prefix.service, prefix.prefix, GetDefaultRegionServicePairRedundancyCheck()))
+	}
+	return &ExternalNetworkSources{ProviderNetworks: []*ProviderNetworkRanges{provider}}
+}
+
+func TestFilterBogons(t *testing.T) {
+	opts := BogonOptions{Policy: BogonPolicyFail, MinIPv4PrefixLen: 8, MinIPv6PrefixLen: 16}
+
+	// Failing leaves the networks untouched
+	networks := newBogonTestNetworks(t)
+	report, err := FilterBogons(networks, opts)
+	require.NotNil(t, err)
+	var bogonErr *BogonError
+	require.True(t, errors.As(err, &bogonErr))
+	require.Equal(t, report, bogonErr.Report)
+	require.Len(t, report.Bogons, 3)
+	require.Equal(t, newBogonTestNetworks(t), networks)
+
+	// Dropping removes the bogons along with the emptied services and regions
+	opts.Policy = BogonPolicyDrop
+	report, err = FilterBogons(networks, opts)
+	require.Nil(t, err)
+	require.Equal(t, BogonPolicyDrop, report.Policy)
+	require.Len(t, report.Bogons, 3)
+	regions := networks.ProviderNetworks[0].RegionNetworks
+	require.Len(t, regions, 1)
+	require.Equal(t, "region1", regions[0].RegionName)
+	require.Len(t, regions[0].ServiceNetworks, 1)
+	require.Equal(t, []string{"8.8.8.0/24"}, regions[0].ServiceNetworks[0].IPv4Prefixes)
+
+	// Nothing left to drop
+	report, err = FilterBogons(networks, opts)
+	require.Nil(t, err)
+	require.Empty(t, report.Bogons)
+}
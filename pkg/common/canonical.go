@@ -0,0 +1,90 @@
+package common
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Canonicalize sorts providers, regions, services and IP prefixes of all the
+// networks, so that identical data always marshals to byte-identical output
+// (and checksum) regardless of the order in which it was crawled.
+func (e *ExternalNetworkSources) Canonicalize() {
+	for _, provider := range e.ProviderNetworks {
+		provider.Canonicalize()
+	}
+	sort.SliceStable(e.ProviderNetworks, func(i, j int) bool {
+		return e.ProviderNetworks[i].ProviderName < e.ProviderNetworks[j].ProviderName
+	})
+}
+
+// Canonicalize sorts regions, services and IP prefixes of the provider networks.
+// Empty lists of IP prefixes are replaced with nil.
+func (p *ProviderNetworkRanges) Canonicalize() {
+	for _, region := range p.RegionNetworks {
+		for _, service := range region.ServiceNetworks {
+			SortIPPrefixes(service.IPv4Prefixes)
+			SortIPPrefixes(service.IPv6Prefixes)
+			// Services without prefixes of a family are marshalled as null no matter
+			// how their prefixes were removed
+			if len(service.IPv4Prefixes) == 0 {
+				service.IPv4Prefixes = nil
+			}
+			if len(service.IPv6Prefixes) == 0 {
+				service.IPv6Prefixes = nil
+			}
+		}
+		sort.SliceStable(region.ServiceNetworks, func(i, j int) bool {
+			return region.ServiceNetworks[i].ServiceName < region.ServiceNetworks[j].ServiceName
+		})
+	}
+	sort.SliceStable(p.RegionNetworks, func(i, j int) bool {
+		return p.RegionNetworks[i].RegionName < p.RegionNetworks[j].RegionName
+	})
+}
+
+// SortIPPrefixes sorts IP prefixes numerically by address, then by prefix length.
+// Strings that are not valid prefixes are sorted lexically after all valid ones.
+func SortIPPrefixes(prefixes []string) {
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return CompareIPPrefixes(prefixes[i], prefixes[j]) < 0
+	})
+}
+
+// CompareIPPrefixes compares two IP prefixes numerically by address, then by
+// prefix length. IPv4 prefixes are ordered before IPv6 prefixes. Strings that are
+// not valid prefixes are compared lexically and ordered after all valid ones.
+func CompareIPPrefixes(a, b string) int {
+	prefixA, errA := netip.ParsePrefix(a)
+	prefixB, errB := netip.ParsePrefix(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	}
+	if c := prefixA.Addr().Compare(prefixB.Addr()); c != 0 {
+		return c
+	}
+	if c := prefixA.Bits() - prefixB.Bits(); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// CanonicalIPPrefix returns the prefix in standard notation, with its host bits
+// cleared, IPv6 addresses in lower case and compressed, and IPv4-mapped IPv6
+// prefixes as IPv4 prefixes. EX: "10.1.2.3/8" gives "10.0.0.0/8", and
+// "::FFFF:10.1.2.0/120" gives "10.1.2.0/24".
+func CanonicalIPPrefix(ipPrefix string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(ipPrefix))
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortIPPrefixes(t *testing.T) {
+	prefixes := []string{
+		"not a prefix",
+		"2600:1f15::/32",
+		"10.0.0.0/16",
+		"9.0.0.0/8",
+		"10.0.0.0/8",
+		"100.0.0.0/8",
+		"2600:1f15::/48",
+		"2001:db8::/32",
+		"another invalid",
+	}
+	SortIPPrefixes(prefixes)
+	require.Equal(t, []string{
+		// Numerical, not lexical, order
+		"9.0.0.0/8",
+		"10.0.0.0/8",
+		"10.0.0.0/16",
+		"100.0.0.0/8",
+		"2001:db8::/32",
+		"2600:1f15::/32",
+		"2600:1f15::/48",
+		"another invalid",
+		"not a prefix",
+	}, prefixes)
+}
+
+func TestCanonicalize(t *testing.T) {
+	redundancyFn := GetDefaultRegionServicePairRedundancyCheck()
+	build := func(order []int) *ExternalNetworkSources {
+		type entry struct{ provider, region, service, prefix string }
+		entries := []entry{
+			{"p2", "r1", "s1", "10.0.0.0/8"},
+			{"p2", "r1", "s1", "9.0.0.0/8"},
+			{"p1", "r2", "s2", "2600:1f15::/32"},
+			{"p1", "r2", "s1", "35.180.0.0/16"},
+			{"p1", "r1", "s1", "3.5.140.0/22"},
+			{"p1", "r1", "s1", "3.5.0.0/22"},
+		}
+		providers := make(map[string]*ProviderNetworkRanges)
+		networks := &ExternalNetworkSources{}
+		for _, i := range order {
+			e := entries[i]
+			provider, ok := providers[e.provider]
+			if !ok {
+				provider = NewProviderNetworkRanges(e.provider)
+				providers[e.provider] = provider
+				networks.ProviderNetworks = append(networks.ProviderNetworks, provider)
+			}
+			require.Nil(t, provider.AddIPPrefix(e.region, e.service, e.prefix, redundancyFn))
+		}
+		return networks
+	}
+
+	networks1 := build([]int{0, 1, 2, 3, 4, 5})
+	networks2 := build([]int{5, 3, 1, 4, 2, 0})
+	networks1.Canonicalize()
+	networks2.Canonicalize()
+	data1, err := json.Marshal(networks1)
+	require.Nil(t, err)
+	data2, err := json.Marshal(networks2)
+	require.Nil(t, err)
+	require.Equal(t, string(data1), string(data2))
+
+	require.Equal(t, "p1", networks1.ProviderNetworks[0].ProviderName)
+	require.Equal(t, "r1", networks1.ProviderNetworks[0].RegionNetworks[0].RegionName)
+	require.Equal(t, "s1", networks1.ProviderNetworks[0].RegionNetworks[1].ServiceNetworks[0].ServiceName)
+	require.Equal(t,
+		[]string{"3.5.0.0/22", "3.5.140.0/22"},
+		networks1.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+	require.Equal(t,
+		[]string{"9.0.0.0/8", "10.0.0.0/8"},
+		networks1.ProviderNetworks[1].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+}
+
+func TestCanonicalIPPrefix(t *testing.T) {
+	for input, expected := range map[string]string{
+		"10.0.0.0/8":               "10.0.0.0/8",
+		"10.1.2.3/8":               "10.0.0.0/8",
+		" 10.1.2.3/32 ":            "10.1.2.3/32",
+		"2600:1F15:0000::/32":      "2600:1f15::/32",
+		"2600:1f15:0:0:0:0:0:1/48": "2600:1f15::/48",
+		"::FFFF:10.1.2.3/120":      "10.1.2.0/24",
+		"::ffff:a01:203/128":       "10.1.2.3/32",
+		"::/0":                     "::/0",
+	} {
+		prefix, err := CanonicalIPPrefix(input)
+		require.Nil(t, err, input)
+		require.Equal(t, expected, prefix.String(), input)
+	}
+
+	for _, input := range []string{"10.0.0.0", "10.0.0.0/33", "2600:1f15::/129", "not a prefix", "fe80::1%eth0/64"} {
+		_, err := CanonicalIPPrefix(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestAddIPPrefixCanonicalizes(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	for _, prefix := range []string{"10.1.2.3/8", "10.0.0.0/8", "::ffff:10.0.0.0/104", "2600:1F15::/32", "2600:1f15:0::/32"} {
+		require.Nil(t, provider.AddIPPrefix("region", "service", prefix, GetDefaultRegionServicePairRedundancyCheck()))
+	}
+	require.Equal(t, []*RegionNetworkDetail{{
+		RegionName: "region",
+		ServiceNetworks: []*ServiceIPRanges{{
+			ServiceName:  "service",
+			IPv4Prefixes: []string{"10.0.0.0/8"},
+			IPv6Prefixes: []string{"2600:1f15::/32"},
+		}},
+	}}, provider.RegionNetworks)
+
+	require.Error(t, provider.AddIPPrefix("region", "service", "10.0.0.0/33", GetDefaultRegionServicePairRedundancyCheck()))
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// MarshalAndChecksum marshals v to JSON and returns the data along with its checksum
+func MarshalAndChecksum(v interface{}) ([]byte, string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, Checksum(data), nil
+}
+
+// Checksum returns the checksum of data as published in the checksum file, i.e. the
+// hex encoded SHA-256 of the data
+func Checksum(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -19,10 +19,22 @@ const NetworkFileName = "networks"
 // network ranges file
 const ChecksumFileName = "checksum"
 
+// ChangesFileName is the name of the file that contains the changes of the
+// network ranges compared with the previous latest networks
+const ChangesFileName = "changes"
+
 // LatestPrefixFileName is the name of the file that contains the prefix
 // of latest networks definitions.
 const LatestPrefixFileName = "latest_prefix"
 
+// ManifestFileName is the name of the file that contains the Manifest
+// of latest networks definitions. It is published next to the latest prefix file.
+const ManifestFileName = "latest_manifest"
+
+// RunManifestFileName is the name of the file that contains the Manifest of a run.
+// It is published in the run folder, before the latest prefix file points to it.
+const RunManifestFileName = "manifest"
+
 // MasterBucketPrefix is the top level prefix we use for all the uploads we do
 // in this crawler
 const MasterBucketPrefix = "external-networks"
@@ -61,6 +73,18 @@ var (
 	Cloudflare = newProvider("Cloudflare")
 )
 
+// RegisterProvider adds a provider that is not built in, for example the provider of a
+// crawler configured at runtime. Returns an error if the provider already exists.
+func RegisterProvider(s string) (Provider, error) {
+	if s == "" {
+		return "", ProviderNameEmptyError()
+	}
+	if _, err := ToProvider(s); err == nil {
+		return "", ProviderAlreadyExistsError(s)
+	}
+	return newProvider(s), nil
+}
+
 func (p Provider) String() string {
 	return string(p)
 }
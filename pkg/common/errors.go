@@ -3,8 +3,15 @@ package common
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
+// CrawlerTimeoutError is returned when a crawler does not finish crawling in time
+func CrawlerTimeoutError(providerName string, timeout time.Duration) error {
+	return fmt.Errorf("crawling provider %s did not finish within %s", providerName, timeout)
+}
+
 // NumProvidersError is returned when the number of providers crawled does not match
 // with the number of crawles spawned
 func NumProvidersError(numProviders, numCrawlers int) error {
@@ -59,14 +66,20 @@ func NotEnoughIPPrefixesError(providerName string, numObserved, numRequired int)
 		numRequired)
 }
 
-// LatestPrefixFileNotFound is returned when there is no latest metadata file on the bucket
-func LatestPrefixFileNotFound(bucketName string) error {
-	return fmt.Errorf("no %s file is found in bucket: %s", LatestPrefixFileName, bucketName)
+// LatestPrefixFileNotFound is returned when there is no latest metadata file on the destination
+func LatestPrefixFileNotFound(destination string) error {
+	return fmt.Errorf("no %s file is found in destination: %s", LatestPrefixFileName, destination)
 }
 
-// NoBucketNameSpecified is returned when the script is invoked without a bucket name
+// NoBucketNameSpecified is returned when the script is invoked without a bucket name or destination
 func NoBucketNameSpecified() error {
-	return errors.New("bucket name not specified")
+	return errors.New("bucket name or destination not specified")
+}
+
+// DestinationAndBucketNameSpecified is returned when the script is invoked with both
+// a bucket name and a destination
+func DestinationAndBucketNameSpecified() error {
+	return errors.New("only one of bucket name and destination can be specified")
 }
 
 // RegionNetworksNotFound is returned when a region networks spec is not found
@@ -78,3 +91,81 @@ func RegionNetworksNotFound(region string) error {
 func ServiceNetworksNotFound(service string) error {
 	return fmt.Errorf("service networks for service %s not found", service)
 }
+
+// IPVersionMismatchError is returned when an IP prefix is replaced with a prefix
+// of another IP version
+func IPVersionMismatchError(ipPrefix, replacement string) error {
+	return fmt.Errorf("cannot replace IP prefix %s with %s of another IP version", ipPrefix, replacement)
+}
+
+// ChecksumMismatchError is returned when the checksum of an uploaded object
+// does not match the expected checksum
+func ChecksumMismatchError(objectName, expected, actual string) error {
+	return fmt.Errorf("checksum of %s does not match. Expected: %s, actual: %s", objectName, expected, actual)
+}
+
+// ConcurrentPublishError is returned when the latest prefix file has been modified
+// by another run while this run was publishing, or is locked by another run
+func ConcurrentPublishError(latestPrefixPath string, cause error) error {
+	return fmt.Errorf(
+		"%s has been modified by another run while publishing. Refusing to overwrite it: %v",
+		latestPrefixPath, cause)
+}
+
+// UpstreamFeedRollbackError is returned when a crawled upstream feed is older than the
+// same feed in the latest published networks
+func UpstreamFeedRollbackError(providerName, feed, previousVersion, version string) error {
+	return fmt.Errorf(
+		"upstream feed %s of provider %s went backwards from version %s to %s. "+
+			"This usually means a stale cache or a bad mirror",
+		feed, providerName, previousVersion, version)
+}
+
+// ChangeRatioExceededError is returned when a provider, region or service gained or lost
+// more prefixes than allowed since the latest published networks
+func ChangeRatioExceededError(scope string, numPrevious, numAdded, numRemoved int, maxChangePercent float64) error {
+	return fmt.Errorf(
+		"%s changed more than %g%% since the latest published networks: %d prefixes before, %d added, %d removed. "+
+			"If the change is expected, allow it explicitly for the provider",
+		scope, maxChangePercent, numPrevious, numAdded, numRemoved)
+}
+
+// ProviderOverlapError is returned when prefixes of different providers overlap
+func ProviderOverlapError(numOverlaps int) error {
+	return fmt.Errorf(
+		"found %d prefix overlaps across providers. Lookups of the overlapping addresses would be ambiguous",
+		numOverlaps)
+}
+
+// maxBogonsInError is the number of offending prefixes listed in the message of a BogonError
+const maxBogonsInError = 10
+
+// BogonError is returned when crawled prefixes are not publicly routable and the bogon
+// policy refuses to publish them. Report lists all the offending prefixes.
+type BogonError struct {
+	Report *BogonReport
+}
+
+func (e *BogonError) Error() string {
+	bogons := make([]string, 0, maxBogonsInError)
+	for i, bogon := range e.Report.Bogons {
+		if i == maxBogonsInError {
+			bogons = append(bogons, fmt.Sprintf("and %d more", len(e.Report.Bogons)-maxBogonsInError))
+			break
+		}
+		bogons = append(bogons, bogon.String())
+	}
+	return fmt.Sprintf("found %d prefixes that are not publicly routable with policy %s: %s",
+		len(e.Report.Bogons), e.Report.Policy, strings.Join(bogons, "; "))
+}
+
+// BogonPrefixesError is returned when crawled prefixes are not publicly routable and
+// the bogon policy refuses to publish them
+func BogonPrefixesError(report *BogonReport) error {
+	return &BogonError{Report: report}
+}
+
+// ProviderAlreadyExistsError is returned when registering a provider that already exists
+func ProviderAlreadyExistsError(providerName string) error {
+	return fmt.Errorf("provider %s already exists", providerName)
+}
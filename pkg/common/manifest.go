@@ -0,0 +1,80 @@
+package common
+
+import (
+	"time"
+)
+
+// ManifestSchemaVersion is the version of the Manifest format. It is incremented
+// whenever a change to Manifest is not backwards compatible.
+const ManifestSchemaVersion = 1
+
+// Manifest describes a published run. The manifest of the latest run is
+// published next to the latest prefix file, so consumers can get the location
+// and checksum of the latest networks with a single request.
+type Manifest struct {
+	// SchemaVersion is the ManifestSchemaVersion the manifest was written with
+	SchemaVersion int `json:"schemaVersion"`
+	// RunFolder is the prefix under which the run's objects are stored.
+	// Same as the content of the latest prefix file.
+	RunFolder string `json:"runFolder"`
+	// Checksum is the SHA-256 of the run's networks file
+	Checksum string `json:"checksum"`
+	// CreatedAt is when the run started
+	CreatedAt time.Time `json:"createdAt"`
+	// ToolVersion is the version of the crawler that published the run
+	ToolVersion string `json:"toolVersion"`
+	// Providers summarizes the networks of each provider
+	Providers []*ManifestProvider `json:"providers"`
+	// Summarized is true if adjacent and contained prefixes of the run were folded
+	Summarized bool `json:"summarized,omitempty"`
+	// DeltasFrom are the run folders the run publishes a delta from, newest first.
+	// Applying the delta to the networks of any of them gives the networks of the run.
+	DeltasFrom []string `json:"deltasFrom,omitempty"`
+}
+
+// ManifestProvider summarizes the networks of a single provider in a Manifest
+type ManifestProvider struct {
+	ProviderName    string `json:"providerName"`
+	NumIPv4Prefixes int    `json:"numIPv4Prefixes"`
+	NumIPv6Prefixes int    `json:"numIPv6Prefixes"`
+	// UpstreamVersions maps each source URL crawled for the provider to the
+	// version identifier of the upstream feed, when the provider publishes one
+	UpstreamVersions map[string]string `json:"upstreamVersions,omitempty"`
+}
+
+// NewManifest returns the Manifest for a run that publishes the specified networks
+func NewManifest(
+	runFolder, checksum string,
+	createdAt time.Time,
+	toolVersion string,
+	networks *ExternalNetworkSources,
+) *Manifest {
+	manifest := &Manifest{
+		SchemaVersion: ManifestSchemaVersion,
+		RunFolder:     runFolder,
+		Checksum:      checksum,
+		CreatedAt:     createdAt,
+		ToolVersion:   toolVersion,
+		Providers:     make([]*ManifestProvider, 0, len(networks.ProviderNetworks)),
+	}
+	for _, provider := range networks.ProviderNetworks {
+		summary := &ManifestProvider{ProviderName: provider.ProviderName}
+		for _, source := range provider.Sources {
+			if source.Version == "" {
+				continue
+			}
+			if summary.UpstreamVersions == nil {
+				summary.UpstreamVersions = make(map[string]string)
+			}
+			summary.UpstreamVersions[source.URL] = source.Version
+		}
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				summary.NumIPv4Prefixes += len(service.IPv4Prefixes)
+				summary.NumIPv6Prefixes += len(service.IPv6Prefixes)
+			}
+		}
+		manifest.Providers = append(manifest.Providers, summary)
+	}
+	return manifest
+}
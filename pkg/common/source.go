@@ -0,0 +1,120 @@
+package common
+
+import (
+	"strconv"
+	"time"
+)
+
+// SourceMetadata describes an upstream feed a provider's networks were crawled from,
+// so that every published run can be traced back to the upstream snapshots it contains.
+type SourceMetadata struct {
+	// URL is where the networks were fetched from
+	URL string `json:"url"`
+	// Feed names the feed among the provider's sources when its URL changes with
+	// every release, e.g. the Azure cloud. Empty if the URL identifies the feed.
+	Feed string `json:"feed,omitempty"`
+	// Version is the version identifier published by the upstream feed,
+	// e.g. syncToken, changeNumber or etag. Empty if the feed does not publish one.
+	Version string `json:"version,omitempty"`
+	// PublishedAt is when the upstream feed was published. Nil if unknown.
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	// FetchedAt is when the feed was fetched
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+// AddSource records an upstream feed the provider's networks were crawled from
+func (p *ProviderNetworkRanges) AddSource(source *SourceMetadata) {
+	p.Sources = append(p.Sources, source)
+}
+
+// SetFetchedAt sets the fetch time of all the provider's sources
+func (p *ProviderNetworkRanges) SetFetchedAt(fetchedAt time.Time) {
+	for _, source := range p.Sources {
+		source.FetchedAt = fetchedAt
+	}
+}
+
+// FeedKey identifies the feed of the source across runs
+func (s *SourceMetadata) FeedKey() string {
+	if s.Feed != "" {
+		return s.Feed
+	}
+	return s.URL
+}
+
+// IsOlderThan reports whether the source is an older snapshot of the same feed than
+// the other source. Numeric versions (syncToken, changeNumber) are compared when both
+// sources have one, the publication times otherwise. Sources that cannot be compared
+// are never considered older.
+func (s *SourceMetadata) IsOlderThan(other *SourceMetadata) bool {
+	version, err := strconv.ParseUint(s.Version, 10, 64)
+	otherVersion, otherErr := strconv.ParseUint(other.Version, 10, 64)
+	if err == nil && otherErr == nil {
+		return version < otherVersion
+	}
+	if s.PublishedAt != nil && other.PublishedAt != nil {
+		return s.PublishedAt.Before(*other.PublishedAt)
+	}
+	return false
+}
+
+// displayVersion returns the version of the source for messages
+func (s *SourceMetadata) displayVersion() string {
+	if s.Version == "" && s.PublishedAt != nil {
+		return s.PublishedAt.Format(time.RFC3339)
+	}
+	return s.Version
+}
+
+// CheckFeedRollback returns an error if any of the provider's upstream feeds is older
+// than the same feed in the previously published networks of the provider
+func (p *ProviderNetworkRanges) CheckFeedRollback(previous *ProviderNetworkRanges) error {
+	previousSources := make(map[string]*SourceMetadata, len(previous.Sources))
+	for _, source := range previous.Sources {
+		previousSources[source.FeedKey()] = source
+	}
+	for _, source := range p.Sources {
+		previousSource, ok := previousSources[source.FeedKey()]
+		if !ok {
+			continue
+		}
+		if source.IsOlderThan(previousSource) {
+			return UpstreamFeedRollbackError(
+				p.ProviderName, source.FeedKey(), previousSource.displayVersion(), source.displayVersion())
+		}
+	}
+	return nil
+}
+
+// WithoutFetchTimes returns a copy of the networks with the fetch time of every source
+// cleared. The copy shares everything but the sources with the original. Useful to
+// compare the content of runs, since the fetch times always change.
+func (e *ExternalNetworkSources) WithoutFetchTimes() *ExternalNetworkSources {
+	result := &ExternalNetworkSources{
+		ProviderNetworks: make([]*ProviderNetworkRanges, 0, len(e.ProviderNetworks)),
+	}
+	for _, provider := range e.ProviderNetworks {
+		providerCopy := *provider
+		providerCopy.Sources = make([]*SourceMetadata, 0, len(provider.Sources))
+		for _, source := range provider.Sources {
+			sourceCopy := *source
+			sourceCopy.FetchedAt = time.Time{}
+			providerCopy.Sources = append(providerCopy.Sources, &sourceCopy)
+		}
+		result.ProviderNetworks = append(result.ProviderNetworks, &providerCopy)
+	}
+	return result
+}
+
+// ParseUpstreamTime parses a timestamp published by an upstream feed with the first
+// matching layout. Timestamps without time zone are assumed to be UTC. Returns nil
+// if none of the layouts match.
+func ParseUpstreamTime(value string, layouts ...string) *time.Time {
+	for _, layout := range layouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			parsed = parsed.UTC()
+			return &parsed
+		}
+	}
+	return nil
+}
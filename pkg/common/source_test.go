@@ -0,0 +1,68 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamTime(t *testing.T) {
+	parsed := ParseUpstreamTime("2020-10-22-20-13-06", time.RFC3339, "2006-01-02-15-04-05")
+	require.NotNil(t, parsed)
+	require.Equal(t, time.Date(2020, 10, 22, 20, 13, 6, 0, time.UTC), *parsed)
+
+	// Time zones are converted to UTC
+	parsed = ParseUpstreamTime("2020-10-22T22:13:06+02:00", time.RFC3339)
+	require.NotNil(t, parsed)
+	require.Equal(t, time.Date(2020, 10, 22, 20, 13, 6, 0, time.UTC), *parsed)
+
+	require.Nil(t, ParseUpstreamTime("UNUSED", time.RFC3339))
+	require.Nil(t, ParseUpstreamTime("2020-10-22T22:13:06Z"))
+}
+
+func TestWithoutFetchTimes(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	provider.AddSource(&SourceMetadata{URL: "https://example.com/ranges.json", Version: "1"})
+	provider.SetFetchedAt(time.Date(2020, 10, 22, 1, 2, 3, 0, time.UTC))
+	networks := &ExternalNetworkSources{ProviderNetworks: []*ProviderNetworkRanges{provider}}
+
+	withoutFetchTimes := networks.WithoutFetchTimes()
+	require.Len(t, withoutFetchTimes.ProviderNetworks, 1)
+	require.Len(t, withoutFetchTimes.ProviderNetworks[0].Sources, 1)
+	require.True(t, withoutFetchTimes.ProviderNetworks[0].Sources[0].FetchedAt.IsZero())
+	require.Equal(t, "1", withoutFetchTimes.ProviderNetworks[0].Sources[0].Version)
+	// The original is left untouched
+	require.False(t, provider.Sources[0].FetchedAt.IsZero())
+}
+
+func TestCheckFeedRollback(t *testing.T) {
+	publishedAt := time.Date(2020, 10, 22, 1, 2, 3, 0, time.UTC)
+	earlier := publishedAt.Add(-time.Hour)
+	previous := NewProviderNetworkRanges("provider")
+	previous.AddSource(&SourceMetadata{URL: "https://example.com/numeric.json", Version: "100"})
+	previous.AddSource(&SourceMetadata{URL: "https://example.com/timestamp.json", PublishedAt: &publishedAt})
+	previous.AddSource(&SourceMetadata{URL: "https://example.com/etag.json", Version: "b"})
+	previous.AddSource(&SourceMetadata{URL: "https://example.com/20201019.json", Feed: "Public", Version: "121"})
+
+	current := NewProviderNetworkRanges("provider")
+	current.AddSource(&SourceMetadata{URL: "https://example.com/numeric.json", Version: "101"})
+	current.AddSource(&SourceMetadata{URL: "https://example.com/timestamp.json", PublishedAt: &publishedAt})
+	// Versions that are not ordered are not compared
+	current.AddSource(&SourceMetadata{URL: "https://example.com/etag.json", Version: "a"})
+	current.AddSource(&SourceMetadata{URL: "https://example.com/20201026.json", Feed: "Public", Version: "122"})
+	require.Nil(t, current.CheckFeedRollback(previous))
+
+	// Numeric versions are compared as numbers
+	current.Sources[0].Version = "99"
+	require.Error(t, current.CheckFeedRollback(previous))
+	current.Sources[0].Version = "101"
+
+	current.Sources[1].PublishedAt = &earlier
+	require.Error(t, current.CheckFeedRollback(previous))
+	current.Sources[1].PublishedAt = &publishedAt
+
+	// Feeds are matched by name when they have one
+	current.Sources[3].Version = "120"
+	require.Error(t, current.CheckFeedRollback(previous))
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"math/big"
+	"net/netip"
+	"sort"
+)
+
+// SummaryReport counts what Summarize folded in the networks of a provider
+type SummaryReport struct {
+	ProviderName      string
+	NumPrefixesBefore int
+	NumPrefixesAfter  int
+	// NumAddressesFolded is the number of addresses of the prefixes that were merged
+	// into a larger prefix, or dropped because another prefix of the same service
+	// contains them
+	NumAddressesFolded *big.Int
+}
+
+// NumPrefixesFolded returns the number of prefixes Summarize removed
+func (r *SummaryReport) NumPrefixesFolded() int {
+	return r.NumPrefixesBefore - r.NumPrefixesAfter
+}
+
+// Summarize replaces the prefixes of every service of every region with the smallest
+// set of prefixes covering exactly the same addresses: adjacent prefixes are merged
+// into the prefix containing both, and prefixes contained in another prefix of the
+// same service are dropped. Services are summarized independently, so a prefix
+// published by several services is kept in all of them.
+func (p *ProviderNetworkRanges) Summarize() *SummaryReport {
+	report := &SummaryReport{ProviderName: p.ProviderName, NumAddressesFolded: new(big.Int)}
+	for _, region := range p.RegionNetworks {
+		for _, service := range region.ServiceNetworks {
+			report.NumPrefixesBefore += len(service.IPv4Prefixes) + len(service.IPv6Prefixes)
+			service.IPv4Prefixes = summarizePrefixes(service.IPv4Prefixes, report.NumAddressesFolded)
+			service.IPv6Prefixes = summarizePrefixes(service.IPv6Prefixes, report.NumAddressesFolded)
+			report.NumPrefixesAfter += len(service.IPv4Prefixes) + len(service.IPv6Prefixes)
+		}
+	}
+	if p.prefixToRegionServiceNames != nil {
+		// Forget the folded prefixes, so that adding them again does not look up their services
+		p.indexPrefixes()
+	}
+	return report
+}
+
+// summarizePrefixes returns the summarized prefixes, sorted. The addresses of the
+// prefixes that were folded are added to numAddressesFolded. Strings that are not
+// valid prefixes are kept as is.
+func summarizePrefixes(prefixes []string, numAddressesFolded *big.Int) []string {
+	if len(prefixes) == 0 {
+		return prefixes
+	}
+	parsed := make([]netip.Prefix, 0, len(prefixes))
+	var invalid []string
+	for _, prefix := range prefixes {
+		canonical, err := CanonicalIPPrefix(prefix)
+		if err != nil {
+			invalid = append(invalid, prefix)
+			continue
+		}
+		parsed = append(parsed, canonical)
+	}
+	// Containing prefixes are sorted right before the prefixes they contain
+	sort.Slice(parsed, func(i, j int) bool {
+		if c := parsed[i].Addr().Compare(parsed[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return parsed[i].Bits() < parsed[j].Bits()
+	})
+
+	summarized := make([]netip.Prefix, 0, len(parsed))
+	for _, prefix := range parsed {
+		if n := len(summarized); n > 0 && summarized[n-1].Bits() <= prefix.Bits() && summarized[n-1].Contains(prefix.Addr()) {
+			continue
+		}
+		summarized = append(summarized, prefix)
+		// Merge the last two prefixes for as long as they are the two halves of a larger prefix
+		for n := len(summarized); n >= 2; n = len(summarized) {
+			parent, ok := mergeSiblings(summarized[n-2], summarized[n-1])
+			if !ok {
+				break
+			}
+			summarized = append(summarized[:n-2], parent)
+		}
+	}
+
+	kept := make(map[netip.Prefix]bool, len(summarized))
+	for _, prefix := range summarized {
+		kept[prefix] = true
+	}
+	counted := make(map[netip.Prefix]bool, len(parsed))
+	for _, prefix := range parsed {
+		if !kept[prefix] && !counted[prefix] {
+			counted[prefix] = true
+			numAddressesFolded.Add(numAddressesFolded, numAddresses(prefix))
+		}
+	}
+
+	result := make([]string, 0, len(summarized)+len(invalid))
+	for _, prefix := range summarized {
+		result = append(result, prefix.String())
+	}
+	return append(result, invalid...)
+}
+
+// mergeSiblings returns the prefix made of the two prefixes, if they are its two halves
+func mergeSiblings(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() || a == b {
+		return netip.Prefix{}, false
+	}
+	parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+	if parent != netip.PrefixFrom(b.Addr(), b.Bits()-1).Masked() {
+		return netip.Prefix{}, false
+	}
+	return parent, true
+}
+
+// numAddresses returns the number of addresses of the prefix
+func numAddresses(prefix netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits()))
+}
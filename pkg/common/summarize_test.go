@@ -0,0 +1,105 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizePrefixes(t *testing.T) {
+	for _, c := range []struct {
+		name               string
+		prefixes           []string
+		expected           []string
+		numAddressesFolded int64
+	}{
+		{
+			name:     "contained",
+			prefixes: []string{"10.0.1.0/24", "10.0.0.0/22", "10.0.2.0/24", "10.1.0.0/24"},
+			expected: []string{"10.0.0.0/22", "10.1.0.0/24"},
+			// The two /24
+			numAddressesFolded: 512,
+		},
+		{
+			name:     "adjacent",
+			prefixes: []string{"10.0.3.0/24", "10.0.2.0/24", "10.0.1.0/24", "10.0.0.0/24", "10.0.4.0/24"},
+			expected: []string{"10.0.0.0/22", "10.0.4.0/24"},
+			// The four /24 merged into the /22
+			numAddressesFolded: 1024,
+		},
+		{
+			name:               "adjacent but not siblings",
+			prefixes:           []string{"10.0.1.0/24", "10.0.2.0/24"},
+			expected:           []string{"10.0.1.0/24", "10.0.2.0/24"},
+			numAddressesFolded: 0,
+		},
+		{
+			name:               "merged then contained",
+			prefixes:           []string{"10.0.0.0/25", "10.0.0.128/25", "10.0.0.128/26", "10.0.1.0/24"},
+			expected:           []string{"10.0.0.0/23"},
+			numAddressesFolded: 64 + 512,
+		},
+		{
+			name:               "duplicates",
+			prefixes:           []string{"10.0.0.0/24", "10.0.0.0/24"},
+			expected:           []string{"10.0.0.0/24"},
+			numAddressesFolded: 0,
+		},
+		{
+			name:               "IPv6",
+			prefixes:           []string{"2600:1f15::/97", "2600:1f15::8000:0/97"},
+			expected:           []string{"2600:1f15::/96"},
+			numAddressesFolded: 1 << 32,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			folded := new(big.Int)
+			require.Equal(t, c.expected, summarizePrefixes(c.prefixes, folded))
+			require.Equal(t, big.NewInt(c.numAddressesFolded), folded)
+		})
+	}
+
+	// Addresses of IPv6 prefixes do not fit in 64 bits
+	folded := new(big.Int)
+	summarizePrefixes([]string{"2600::/16", "2601::/16"}, folded)
+	expected, ok := new(big.Int).SetString("20000000000000000000000000000", 16)
+	require.True(t, ok)
+	require.Equal(t, expected, folded)
+}
+
+func TestSummarize(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	for _, entry := range []struct{ region, service, prefix string }{
+		{"region1", "service1", "10.0.0.0/24"},
+		{"region1", "service1", "10.0.1.0/24"},
+		{"region1", "service1", "10.0.0.128/25"},
+		{"region1", "service1", "2600:1f15::/32"},
+		// Services are summarized independently
+		{"region1", "service2", "10.0.1.0/24"},
+		{"region2", "service1", "10.0.0.0/24"},
+	} {
+		require.Nil(t, provider.AddIPPrefix(entry.region, entry.service, entry.prefix, GetDefaultRegionServicePairRedundancyCheck()))
+	}
+
+	report := provider.Summarize()
+	require.Equal(t, &SummaryReport{
+		ProviderName:       "provider",
+		NumPrefixesBefore:  6,
+		NumPrefixesAfter:   4,
+		NumAddressesFolded: big.NewInt(128 + 512),
+	}, report)
+	require.Equal(t, 2, report.NumPrefixesFolded())
+	require.Equal(t, []*RegionNetworkDetail{
+		{RegionName: "region1", ServiceNetworks: []*ServiceIPRanges{
+			{ServiceName: "service1", IPv4Prefixes: []string{"10.0.0.0/23"}, IPv6Prefixes: []string{"2600:1f15::/32"}},
+			{ServiceName: "service2", IPv4Prefixes: []string{"10.0.1.0/24"}},
+		}},
+		{RegionName: "region2", ServiceNetworks: []*ServiceIPRanges{
+			{ServiceName: "service1", IPv4Prefixes: []string{"10.0.0.0/24"}},
+		}},
+	}, provider.RegionNetworks)
+
+	// Folded prefixes can be added again
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "10.0.1.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+}
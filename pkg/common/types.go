@@ -1,9 +1,10 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net"
+	"slices"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -13,7 +14,9 @@ import (
 // NetworkCrawler defines an interface for the implementation
 // of Provider specific network range crawlers
 type NetworkCrawler interface {
-	CrawlPublicNetworkRanges() (*ProviderNetworkRanges, error)
+	// CrawlPublicNetworkRanges crawls the provider's networks. Crawling should stop
+	// as soon as possible once ctx is done.
+	CrawlPublicNetworkRanges(ctx context.Context) (*ProviderNetworkRanges, error)
 	GetHumanReadableProviderName() string
 	GetProviderKey() Provider
 	// GetNumRequiredIPPrefixes returns number of required IP prefixes crawled by crawler
@@ -41,6 +44,8 @@ type RegionNetworkDetail struct {
 type ProviderNetworkRanges struct {
 	ProviderName   string                 `json:"providerName"`
 	RegionNetworks []*RegionNetworkDetail `json:"regionNetworks"`
+	// Sources are the upstream feeds the networks were crawled from
+	Sources []*SourceMetadata `json:"sources,omitempty"`
 
 	// prefixToRegionServiceNames is used to remove "redundant" network
 	// Redundancy is determined by user's predicate while adding a new IP prefix.
@@ -110,14 +115,70 @@ func NewProviderNetworkRanges(providerName string) *ProviderNetworkRanges {
 	}
 }
 
+// Copy returns a deep copy of the provider's networks and sources, which can be
+// modified without affecting the original
+func (p *ProviderNetworkRanges) Copy() *ProviderNetworkRanges {
+	providerCopy := &ProviderNetworkRanges{
+		ProviderName: p.ProviderName,
+	}
+	if p.RegionNetworks != nil {
+		providerCopy.RegionNetworks = make([]*RegionNetworkDetail, 0, len(p.RegionNetworks))
+	}
+	for _, region := range p.RegionNetworks {
+		regionCopy := &RegionNetworkDetail{RegionName: region.RegionName}
+		if region.ServiceNetworks != nil {
+			regionCopy.ServiceNetworks = make([]*ServiceIPRanges, 0, len(region.ServiceNetworks))
+		}
+		for _, service := range region.ServiceNetworks {
+			regionCopy.ServiceNetworks = append(regionCopy.ServiceNetworks, &ServiceIPRanges{
+				ServiceName:  service.ServiceName,
+				IPv4Prefixes: slices.Clone(service.IPv4Prefixes),
+				IPv6Prefixes: slices.Clone(service.IPv6Prefixes),
+			})
+		}
+		providerCopy.RegionNetworks = append(providerCopy.RegionNetworks, regionCopy)
+	}
+	for _, source := range p.Sources {
+		sourceCopy := *source
+		if source.PublishedAt != nil {
+			publishedAt := *source.PublishedAt
+			sourceCopy.PublishedAt = &publishedAt
+		}
+		providerCopy.AddSource(&sourceCopy)
+	}
+	if p.prefixToRegionServiceNames != nil {
+		providerCopy.indexPrefixes()
+	}
+	return providerCopy
+}
+
 // AddIPPrefix adds the specified IP prefix to the region and service name pair
-// returns error if the IP given is not a valid IP prefix
+// returns error if the IP given is not a valid IP prefix. The prefix is stored in its
+// canonical form, see CanonicalIPPrefix, so that different notations of the same
+// prefix are deduplicated.
 func (p *ProviderNetworkRanges) AddIPPrefix(region, service, ipPrefix string, fn IsRedundantRegionServicePairFn) error {
-	ip, prefix, err := net.ParseCIDR(ipPrefix)
-	if err != nil || ip == nil || prefix == nil {
-		return errors.Wrapf(err, "failed to parse address: %s", ip)
+	prefix, err := CanonicalIPPrefix(ipPrefix)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse address: %s", ipPrefix)
+	}
+	if canonical := prefix.String(); canonical != ipPrefix {
+		if Verbose() {
+			log.Printf(
+				"Rewrote prefix %s of region %s and service %s of provider %s to %s",
+				ipPrefix,
+				region,
+				service,
+				p.ProviderName,
+				canonical)
+		}
+		ipPrefix = canonical
+	}
+	isIPv4 := prefix.Addr().Is4()
+	if p.prefixToRegionServiceNames == nil {
+		// Networks that were not built with NewProviderNetworkRanges, for example decoded
+		// from a published run
+		p.indexPrefixes()
 	}
-	isIPv4 := ip.To4() != nil
 
 	// Check redundancy
 	existingPairs, ok := p.prefixToRegionServiceNames[ipPrefix]
@@ -161,6 +222,19 @@ func (p *ProviderNetworkRanges) AddIPPrefix(region, service, ipPrefix string, fn
 }
 
 func (p *ProviderNetworkRanges) addIPPrefix(region, service, ip string, isIPv4 bool) {
+	serviceIPRanges := p.getOrAddServiceIPRanges(region, service)
+	if isIPv4 {
+		serviceIPRanges.IPv4Prefixes = append(serviceIPRanges.IPv4Prefixes, ip)
+	} else {
+		serviceIPRanges.IPv6Prefixes = append(serviceIPRanges.IPv6Prefixes, ip)
+	}
+
+	// Update cache
+	p.prefixToRegionServiceNames[ip] =
+		append(p.prefixToRegionServiceNames[ip], &RegionServicePair{Region: region, Service: service})
+}
+
+func (p *ProviderNetworkRanges) getOrAddServiceIPRanges(region, service string) *ServiceIPRanges {
 	var regionNetwork *RegionNetworkDetail
 	for _, network := range p.RegionNetworks {
 		if network.RegionName == region {
@@ -174,28 +248,145 @@ func (p *ProviderNetworkRanges) addIPPrefix(region, service, ip string, isIPv4 b
 		p.RegionNetworks = append(p.RegionNetworks, regionNetwork)
 	}
 
-	var serviceIPRanges *ServiceIPRanges
 	for _, ips := range regionNetwork.ServiceNetworks {
 		if ips.ServiceName == service {
-			serviceIPRanges = ips
-			break
+			return ips
 		}
 	}
-	if serviceIPRanges == nil {
-		// Never seen this service before
-		serviceIPRanges = &ServiceIPRanges{ServiceName: service}
-		regionNetwork.ServiceNetworks = append(regionNetwork.ServiceNetworks, serviceIPRanges)
+	// Never seen this service before
+	serviceIPRanges := &ServiceIPRanges{ServiceName: service}
+	regionNetwork.ServiceNetworks = append(regionNetwork.ServiceNetworks, serviceIPRanges)
+	return serviceIPRanges
+}
+
+// MoveService moves the prefixes of the service of the region to the service newService
+// of the region newRegion, which are added if they do not exist yet. Prefixes already in
+// the destination service are not duplicated. The moved service is removed, along with
+// its region if it has no service left.
+func (p *ProviderNetworkRanges) MoveService(region, service, newRegion, newService string) error {
+	if region == newRegion && service == newService {
+		return nil
+	}
+	moved, err := p.removeService(region, service)
+	if err != nil {
+		return err
 	}
+	serviceIPRanges := p.getOrAddServiceIPRanges(newRegion, newService)
+	serviceIPRanges.IPv4Prefixes = utils.StrSliceAppendMissing(serviceIPRanges.IPv4Prefixes, moved.IPv4Prefixes...)
+	serviceIPRanges.IPv6Prefixes = utils.StrSliceAppendMissing(serviceIPRanges.IPv6Prefixes, moved.IPv6Prefixes...)
+	if p.prefixToRegionServiceNames != nil {
+		p.indexPrefixes()
+	}
+	return nil
+}
 
-	if isIPv4 {
-		serviceIPRanges.IPv4Prefixes = append(serviceIPRanges.IPv4Prefixes, ip)
-	} else {
-		serviceIPRanges.IPv6Prefixes = append(serviceIPRanges.IPv6Prefixes, ip)
+// RemoveService removes the service of the region with all its prefixes, along with the
+// region if it has no service left
+func (p *ProviderNetworkRanges) RemoveService(region, service string) error {
+	if _, err := p.removeService(region, service); err != nil {
+		return err
+	}
+	if p.prefixToRegionServiceNames != nil {
+		p.indexPrefixes()
 	}
+	return nil
+}
 
-	// Update cache
-	p.prefixToRegionServiceNames[ip] =
-		append(p.prefixToRegionServiceNames[ip], &RegionServicePair{Region: region, Service: service})
+func (p *ProviderNetworkRanges) removeService(region, service string) (*ServiceIPRanges, error) {
+	for i, regionNetwork := range p.RegionNetworks {
+		if regionNetwork.RegionName != region {
+			continue
+		}
+		for j, serviceIPRanges := range regionNetwork.ServiceNetworks {
+			if serviceIPRanges.ServiceName != service {
+				continue
+			}
+			regionNetwork.ServiceNetworks = SvcIPRangesSliceRemove(regionNetwork.ServiceNetworks, j)
+			if regionNetwork.isEmpty() {
+				p.RegionNetworks = RgnNetDetSliceRemove(p.RegionNetworks, i)
+			}
+			return serviceIPRanges, nil
+		}
+		return nil, ServiceNetworksNotFound(service)
+	}
+	return nil, RegionNetworksNotFound(region)
+}
+
+// indexPrefixes rebuilds the cache of the region and service pairs of every prefix
+func (p *ProviderNetworkRanges) indexPrefixes() {
+	p.prefixToRegionServiceNames = make(map[string][]*RegionServicePair)
+	for _, region := range p.RegionNetworks {
+		for _, service := range region.ServiceNetworks {
+			for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+				for _, prefix := range prefixes {
+					p.prefixToRegionServiceNames[prefix] = append(p.prefixToRegionServiceNames[prefix],
+						&RegionServicePair{Region: region.RegionName, Service: service.ServiceName})
+				}
+			}
+		}
+	}
+}
+
+// ReplaceIPPrefix replaces the IP prefix of the region and service name pair with the
+// replacement prefixes, which must be of the same IP version. Prefixes are compared in
+// their canonical form, and replacements the service already has are not duplicated.
+// Without replacements the prefix is removed, along with its service and region if they
+// have no prefix left.
+func (p *ProviderNetworkRanges) ReplaceIPPrefix(region, service, ipPrefix string, replacements []string) error {
+	prefix, err := CanonicalIPPrefix(ipPrefix)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse address: %s", ipPrefix)
+	}
+	ipPrefix = prefix.String()
+	isIPv4 := prefix.Addr().Is4()
+	keep := false
+	if len(replacements) > 0 {
+		var serviceIPRanges *ServiceIPRanges
+		for _, network := range p.RegionNetworks {
+			if network.RegionName != region {
+				continue
+			}
+			for _, ips := range network.ServiceNetworks {
+				if ips.ServiceName == service {
+					serviceIPRanges = ips
+				}
+			}
+		}
+		if serviceIPRanges == nil {
+			return ServiceNetworksNotFound(service)
+		}
+		canonicalReplacements := make([]string, 0, len(replacements))
+		for _, replacement := range replacements {
+			canonical, err := CanonicalIPPrefix(replacement)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse address: %s", replacement)
+			}
+			if canonical.Addr().Is4() != isIPv4 {
+				return IPVersionMismatchError(ipPrefix, canonical.String())
+			}
+			if canonical == prefix {
+				keep = true
+				continue
+			}
+			canonicalReplacements = append(canonicalReplacements, canonical.String())
+		}
+		existing := &serviceIPRanges.IPv6Prefixes
+		if isIPv4 {
+			existing = &serviceIPRanges.IPv4Prefixes
+		}
+		numExisting := len(*existing)
+		*existing = utils.StrSliceAppendMissing(*existing, canonicalReplacements...)
+		if p.prefixToRegionServiceNames != nil {
+			for _, replacement := range (*existing)[numExisting:] {
+				p.prefixToRegionServiceNames[replacement] =
+					append(p.prefixToRegionServiceNames[replacement], &RegionServicePair{Region: region, Service: service})
+			}
+		}
+	}
+	if keep {
+		return nil
+	}
+	return p.removeIPPrefix(region, service, ipPrefix, isIPv4)
 }
 
 func (p *ProviderNetworkRanges) removeIPPrefix(region, service, ip string, isIPv4 bool) error {
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceIPPrefix(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "10.0.0.0/23", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "2600:1f15::/32", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region2", "service1", "10.0.0.0/23", GetDefaultRegionServicePairRedundancyCheck()))
+
+	require.Nil(t, provider.ReplaceIPPrefix("region1", "service1", "10.0.0.0/23", []string{"10.0.0.0/24"}))
+	// Without replacements, the region is removed along with its last prefix
+	require.Nil(t, provider.ReplaceIPPrefix("region2", "service1", "10.0.0.0/23", nil))
+	require.Equal(t, []*RegionNetworkDetail{{
+		RegionName: "region1",
+		ServiceNetworks: []*ServiceIPRanges{{
+			ServiceName:  "service1",
+			IPv4Prefixes: []string{"10.0.0.0/24"},
+			IPv6Prefixes: []string{"2600:1f15::/32"},
+		}},
+	}}, provider.RegionNetworks)
+
+	// Prefixes are canonicalized, and the service's prefixes are not duplicated
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "10.0.2.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.ReplaceIPPrefix("region1", "service1", "10.0.0.1/24", []string{"10.0.2.0/24", "10.0.1.1/24", "10.0.1.0/24"}))
+	require.ElementsMatch(t, []string{"10.0.2.0/24", "10.0.1.0/24"}, provider.RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+	require.Len(t, provider.prefixToRegionServiceNames["10.0.2.0/24"], 1)
+	require.Len(t, provider.prefixToRegionServiceNames["10.0.1.0/24"], 1)
+	require.Empty(t, provider.prefixToRegionServiceNames["10.0.0.0/24"])
+	// Replacing a prefix with itself keeps it
+	require.Nil(t, provider.ReplaceIPPrefix("region1", "service1", "10.0.1.0/24", []string{"10.0.1.0/24", "10.0.3.0/24"}))
+	require.ElementsMatch(t, []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"}, provider.RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+
+	// Replacements must be of the same IP version, and nothing is replaced otherwise
+	err := provider.ReplaceIPPrefix("region1", "service1", "10.0.3.0/24", []string{"10.0.4.0/24", "2600:1f16::/32"})
+	require.Equal(t, IPVersionMismatchError("10.0.3.0/24", "2600:1f16::/32").Error(), err.Error())
+	err = provider.ReplaceIPPrefix("region1", "service1", "2600:1f15::/32", []string{"10.0.4.0/24"})
+	require.Equal(t, IPVersionMismatchError("2600:1f15::/32", "10.0.4.0/24").Error(), err.Error())
+	require.ElementsMatch(t, []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"}, provider.RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+	require.Equal(t, []string{"2600:1f15::/32"}, provider.RegionNetworks[0].ServiceNetworks[0].IPv6Prefixes)
+
+	require.Error(t, provider.ReplaceIPPrefix("region1", "service2", "10.0.0.0/24", []string{"10.0.0.0/25"}))
+	require.Error(t, provider.ReplaceIPPrefix("region1", "service1", "not a prefix", nil))
+}
+
+func TestMoveAndRemoveService(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "10.0.0.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region1", "service2", "10.0.1.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region2", "service1", "10.0.0.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region2", "service1", "10.0.2.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+
+	// Moving into an existing service merges the prefixes, and removes the emptied region
+	require.Nil(t, provider.MoveService("region2", "service1", "region1", "service1"))
+	require.Nil(t, provider.RemoveService("region1", "service2"))
+	require.Equal(t, []*RegionNetworkDetail{{
+		RegionName: "region1",
+		ServiceNetworks: []*ServiceIPRanges{{
+			ServiceName:  "service1",
+			IPv4Prefixes: []string{"10.0.0.0/24", "10.0.2.0/24"},
+		}},
+	}}, provider.RegionNetworks)
+	require.Len(t, provider.prefixToRegionServiceNames["10.0.0.0/24"], 1)
+	require.Empty(t, provider.prefixToRegionServiceNames["10.0.1.0/24"])
+
+	require.Nil(t, provider.MoveService("region1", "service1", "region3", "service3"))
+	require.Equal(t, "region3", provider.RegionNetworks[0].RegionName)
+	require.Equal(t, "service3", provider.RegionNetworks[0].ServiceNetworks[0].ServiceName)
+	require.Equal(t, "region3", provider.prefixToRegionServiceNames["10.0.2.0/24"][0].Region)
+
+	require.Error(t, provider.RemoveService("region1", "service1"))
+	require.Error(t, provider.MoveService("region3", "service1", "region1", "service1"))
+}
+
+func TestCopy(t *testing.T) {
+	provider := NewProviderNetworkRanges("provider")
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "10.0.0.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	require.Nil(t, provider.AddIPPrefix("region1", "service1", "2600:1f15::/32", GetDefaultRegionServicePairRedundancyCheck()))
+	provider.AddSource(&SourceMetadata{URL: "https://example.com", Version: "1"})
+
+	providerCopy := provider.Copy()
+	require.Equal(t, provider, providerCopy)
+
+	// Modifying the copy leaves the original as it was
+	require.Nil(t, providerCopy.ReplaceIPPrefix("region1", "service1", "10.0.0.0/24", []string{"10.0.0.0/25"}))
+	require.Nil(t, providerCopy.AddIPPrefix("region2", "service1", "10.0.1.0/24", GetDefaultRegionServicePairRedundancyCheck()))
+	providerCopy.RegionNetworks[0].ServiceNetworks[0].ServiceName = "service2"
+	providerCopy.Sources[0].Version = "2"
+	require.Equal(t, []*RegionNetworkDetail{{
+		RegionName: "region1",
+		ServiceNetworks: []*ServiceIPRanges{{
+			ServiceName:  "service1",
+			IPv4Prefixes: []string{"10.0.0.0/24"},
+			IPv6Prefixes: []string{"2600:1f15::/32"},
+		}},
+	}}, provider.RegionNetworks)
+	require.Equal(t, "1", provider.Sources[0].Version)
+	require.Equal(t, "service1", provider.prefixToRegionServiceNames["10.0.0.0/24"][0].Service)
+	require.Empty(t, provider.prefixToRegionServiceNames["10.0.1.0/24"])
+}
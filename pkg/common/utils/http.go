@@ -1,8 +1,9 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -12,12 +13,17 @@ import (
 
 const httpGetTimeout = 60 * time.Second
 
+// httpClient is shared by all crawlers so that connections are reused
+var httpClient = &http.Client{
+	Timeout: httpGetTimeout,
+}
+
 // HTTPGetWithRetry returns the body of the HTTP Get response. Retries if the call fails.
-func HTTPGetWithRetry(provider, url string) ([]byte, error) {
+func HTTPGetWithRetry(ctx context.Context, provider, url string) ([]byte, error) {
 	var body []byte
-	retryErr := WithDefaultRetry(func() error {
+	retryErr := WithDefaultRetry(ctx, func() error {
 		var err error
-		body, err = HTTPGet(url)
+		body, err = HTTPGet(ctx, url)
 		if err != nil {
 			return errors.Wrapf(err, "failed to fetch networks from %s with URL: %s", provider, url)
 		}
@@ -30,14 +36,14 @@ func HTTPGetWithRetry(provider, url string) ([]byte, error) {
 }
 
 // HTTPGet returns the body of the HTTP GET response
-func HTTPGet(url string) ([]byte, error) {
+func HTTPGet(ctx context.Context, url string) ([]byte, error) {
 	log.Printf("Getting from URL: %s...", url)
 
-	client := &http.Client{
-		Timeout: httpGetTimeout,
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
-
-	resp, err := client.Get(url)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +52,7 @@ func HTTPGet(url string) ([]byte, error) {
 		return nil, fmt.Errorf("received non 200 status code. Code: %d, error: %v", resp.StatusCode, err)
 	}
 
-	bodyData, err := ioutil.ReadAll(resp.Body)
+	bodyData, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed while trying to copy response data")
 	}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer server.Close()
+
+	body, err := HTTPGet(context.Background(), server.URL+"/ok")
+	require.Nil(t, err)
+	require.Equal(t, []byte("body"), body)
+
+	_, err = HTTPGet(context.Background(), server.URL+"/missing")
+	require.NotNil(t, err)
+}
+
+func TestHTTPGetWithRetryCancellation(t *testing.T) {
+	var numRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Without cancellation this would keep retrying for minutes
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := HTTPGetWithRetry(ctx, "test", server.URL)
+	require.NotNil(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+	require.GreaterOrEqual(t, atomic.LoadInt32(&numRequests), int32(1))
+}
@@ -1,24 +1,27 @@
 package utils
 
 import (
-	"github.com/cenkalti/backoff/v3"
+	"context"
 	"log"
 	"time"
+
+	"github.com/cenkalti/backoff/v3"
 )
 
 // WithDefaultRetry retries a given function with a default exponential backoff
-func WithDefaultRetry(do func() error) error {
-	return WithRetry(do, 2*time.Second, 10*time.Second, 5*time.Minute)
+func WithDefaultRetry(ctx context.Context, do func() error) error {
+	return WithRetry(ctx, do, 2*time.Second, 10*time.Second, 5*time.Minute)
 }
 
 // WithRetry retries a given function with an exponential backoff until maxTime is reached
-func WithRetry(do func() error, interval, maxInterval, maxTime time.Duration) error {
+// or ctx is done
+func WithRetry(ctx context.Context, do func() error, interval, maxInterval, maxTime time.Duration) error {
 	exponential := backoff.NewExponentialBackOff()
 	exponential.MaxElapsedTime = maxTime
 	exponential.InitialInterval = interval
 	exponential.MaxInterval = maxInterval
 
-	err := backoff.RetryNotify(do, exponential, func(err error, d time.Duration) {
+	err := backoff.RetryNotify(do, backoff.WithContext(exponential, ctx), func(err error, d time.Duration) {
 		log.Printf("call failed, retrying in %s. Error: %v", d.Round(time.Second), err)
 	})
 	return err
@@ -50,6 +50,22 @@ func StrSliceRemove(in []string, i int) []string {
 	return in[:len(in)-1]
 }
 
+// StrSliceAppendMissing appends the elements that are not in the string slice yet
+func StrSliceAppendMissing(in []string, elems ...string) []string {
+	existing := make(map[string]struct{}, len(in)+len(elems))
+	for _, s := range in {
+		existing[s] = struct{}{}
+	}
+	for _, s := range elems {
+		if _, ok := existing[s]; ok {
+			continue
+		}
+		existing[s] = struct{}{}
+		in = append(in, s)
+	}
+	return in
+}
+
 // Uniquify uniquifies a string by attaching a UUID to it
 func Uniquify(name string) (string, error) {
 	if strings.Contains(name, uniquifyDelim) {
@@ -1,14 +1,19 @@
 package aws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/common/utils"
 )
 
+// awsCreateDateLayout is the layout of createDate. EX: 2020-10-22-20-13-06
+const awsCreateDateLayout = "2006-01-02-15-04-05"
+
 type awsIPv4Spec struct {
 	IPPrefix           string `json:"ip_prefix"`
 	Region             string `json:"region"`
@@ -52,8 +57,9 @@ func (c *awsNetworkCrawler) GetNumRequiredIPPrefixes() int {
 	return 4000
 }
 
-func (c *awsNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkRanges, error) {
-	networkData, err := c.fetch()
+func (c *awsNetworkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	fetchedAt := time.Now().UTC()
+	networkData, err := c.fetch(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch network data while crawling Google's network ranges")
 	}
@@ -63,11 +69,12 @@ func (c *awsNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkR
 		return nil, errors.Wrap(err, "failed to parse Google's network data")
 	}
 
+	parsed.SetFetchedAt(fetchedAt)
 	return parsed, nil
 }
 
-func (c *awsNetworkCrawler) fetch() ([]byte, error) {
-	return utils.HTTPGetWithRetry("Amazon", c.url)
+func (c *awsNetworkCrawler) fetch(ctx context.Context) ([]byte, error) {
+	return utils.HTTPGetWithRetry(ctx, "Amazon", c.url)
 }
 
 func (c *awsNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkRanges, error) {
@@ -78,6 +85,11 @@ func (c *awsNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkR
 	}
 
 	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
+	providerNetworks.AddSource(&common.SourceMetadata{
+		URL:         c.url,
+		Version:     awsNetworkSpec.SyncToken,
+		PublishedAt: common.ParseUpstreamTime(awsNetworkSpec.CreateDate, awsCreateDateLayout),
+	})
 	for _, ipv4Spec := range awsNetworkSpec.Prefixes {
 		if ipv4Spec.IPPrefix == "" {
 			// Empty IPv4. Something might be wrong here. Logging for warning
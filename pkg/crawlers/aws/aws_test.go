@@ -3,6 +3,7 @@ package aws
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stackrox/external-network-pusher/pkg/common/testutils"
 	"github.com/stretchr/testify/require"
@@ -206,3 +207,31 @@ func TestAWSRegionServiceRedundancyCheck(t *testing.T) {
 			[]string{})
 	}
 }
+
+func TestAWSParseSourceMetadata(t *testing.T) {
+	testData := awsNetworkSpec{
+		SyncToken:  "1603397586",
+		CreateDate: "2020-10-22-20-13-06",
+		Prefixes: []awsIPv4Spec{
+			{
+				IPPrefix:           "3.5.140.0/22",
+				Region:             "region1",
+				NetworkBorderGroup: testutils.UnusedString,
+				Service:            "service1",
+			},
+		},
+	}
+	networks, err := json.Marshal(testData)
+	require.Nil(t, err)
+
+	crawler := awsNetworkCrawler{url: "https://ip-ranges.amazonaws.com/ip-ranges.json"}
+	parsedResult, err := crawler.parseNetworks(networks)
+	require.Nil(t, err)
+
+	require.Len(t, parsedResult.Sources, 1)
+	source := parsedResult.Sources[0]
+	require.Equal(t, crawler.url, source.URL)
+	require.Equal(t, "1603397586", source.Version)
+	require.NotNil(t, source.PublishedAt)
+	require.Equal(t, time.Date(2020, 10, 22, 20, 13, 6, 0, time.UTC), *source.PublishedAt)
+}
@@ -1,13 +1,20 @@
 package azure
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/common/utils"
-	"log"
-	"os/exec"
+	"golang.org/x/net/html"
 )
 
 // With Microsoft, it is a little different in a sense that: it has four
@@ -27,6 +34,10 @@ import (
 
 const azureCompoundNameDelim = "/"
 
+// azureDownloadURLPattern is contained in the links to the JSON files on
+// Microsoft's download pages
+const azureDownloadURLPattern = "download.microsoft.com/download/"
+
 type azureNetworkCrawler struct {
 	urls []string
 }
@@ -47,6 +58,12 @@ type azureCloudEntity struct {
 	Properties azureCloudEntityProperties `json:"properties"`
 }
 
+// azureCloudInfo is a fetched JSON file of an Azure cloud
+type azureCloudInfo struct {
+	url  string
+	data []byte
+}
+
 // azureCloud represents the top level structure for
 // an Azure cloud networks
 type azureCloud struct {
@@ -73,9 +90,10 @@ func (c *azureNetworkCrawler) GetNumRequiredIPPrefixes() int {
 	return 24000
 }
 
-func (c *azureNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkRanges, error) {
+func (c *azureNetworkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
 	// First, fetch from all sources
-	cloudInfos, err := c.fetchAll()
+	fetchedAt := time.Now().UTC()
+	cloudInfos, err := c.fetchAll(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -85,17 +103,23 @@ func (c *azureNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetwor
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse Azure networks")
 	}
+	azureNetworks.SetFetchedAt(fetchedAt)
 	return azureNetworks, nil
 }
 
-func (c *azureNetworkCrawler) parseAzureNetworks(cloudInfos [][]byte) (*common.ProviderNetworkRanges, error) {
+func (c *azureNetworkCrawler) parseAzureNetworks(cloudInfos []azureCloudInfo) (*common.ProviderNetworkRanges, error) {
 	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
-	for _, data := range cloudInfos {
+	for _, cloudInfo := range cloudInfos {
 		var cloud azureCloud
-		err := json.Unmarshal(data, &cloud)
+		err := json.Unmarshal(cloudInfo.data, &cloud)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to unmarshal Azure networks")
 		}
+		providerNetworks.AddSource(&common.SourceMetadata{
+			URL:     cloudInfo.url,
+			Feed:    cloud.Cloud,
+			Version: strconv.Itoa(cloud.ChangeNumber),
+		})
 
 		for _, entity := range cloud.Values {
 			if len(entity.Properties.AddressPrefixes) == 0 {
@@ -271,16 +295,17 @@ func toServiceName(platformName, serviceName string) string {
 	return utils.ToCompoundName(azureCompoundNameDelim, platformName, serviceName)
 }
 
-func (c *azureNetworkCrawler) fetchAll() ([][]byte, error) {
+// fetchAll returns the JSON files of all the clouds
+func (c *azureNetworkCrawler) fetchAll(ctx context.Context) ([]azureCloudInfo, error) {
 	// Microsoft does not give a static URL for its IP ranges, instead, they redirect all
 	// download requests to a semi-static URL with dynamic parameter (EX: <staticURL>?ID=<some ID>),
 	// and the page then renders generated URLs to json files.
 	jsonURLs := make([]string, 0, len(c.urls))
 	for _, url := range c.urls {
 		var jsonURL string
-		retryErr := utils.WithDefaultRetry(func() error {
+		retryErr := utils.WithDefaultRetry(ctx, func() error {
 			var err error
-			jsonURL, err = c.redirectToJSONURL(url)
+			jsonURL, err = c.redirectToJSONURL(ctx, url)
 			if err != nil {
 				return errors.Wrapf(err, "failed to crawl Azure with URL: %s. Error: %v. JSON URL: %s", url, err, jsonURL)
 			}
@@ -296,37 +321,63 @@ func (c *azureNetworkCrawler) fetchAll() ([][]byte, error) {
 		jsonURLs = append(jsonURLs, jsonURL)
 	}
 
-	contents := make([][]byte, 0, len(jsonURLs))
+	cloudInfos := make([]azureCloudInfo, 0, len(jsonURLs))
 	for _, jsonURL := range jsonURLs {
 		log.Printf("Current URL is: %s", jsonURL)
-		body, err := utils.HTTPGetWithRetry("Azure", jsonURL)
+		body, err := utils.HTTPGetWithRetry(ctx, "Azure", jsonURL)
 		if err != nil {
 			return nil, err
 		}
-		contents = append(contents, body)
+		cloudInfos = append(cloudInfos, azureCloudInfo{url: jsonURL, data: body})
+	}
+	return cloudInfos, nil
+}
+
+func (c *azureNetworkCrawler) redirectToJSONURL(ctx context.Context, rawURL string) (string, error) {
+	// The shared HTTP client follows the redirects for us
+	page, err := utils.HTTPGet(ctx, rawURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to fetch download page %q while trying to crawl Azure", rawURL)
+	}
+	jsonURL, err := findJSONURL(page)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse download page %q while trying to crawl Azure", rawURL)
+	}
+	return jsonURL, nil
+}
+
+// findJSONURL returns the first link on a download page that points to the
+// download location of a JSON file. An empty string is returned if there is no
+// such link on the page.
+func findJSONURL(page []byte) (string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return "", nil
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				if string(key) == "href" && isJSONDownloadURL(string(value)) {
+					return string(value), nil
+				}
+			}
+		}
 	}
-	return contents, nil
 }
 
-func (c *azureNetworkCrawler) redirectToJSONURL(rawURL string) (string, error) {
-	cmd := fmt.Sprintf(
-		// curl the page
-		"curl -Lfs \"%s\" |"+
-			// Get all the <a> tags
-			" grep -Eoi '<a [^>]+>' |"+
-			// Get all the hrefs within <a> tags
-			" grep -Eo 'href=\"[^\\\"]+\"' |"+
-			// Get all the relevant download links
-			" grep \"download.microsoft.com/download/\" |"+
-			// Match the URL part
-			" grep -m 1 -Eo '(http|https)://[^\"]+' |"+
-			// Trim trailing newline char
-			"tr -d '\n'",
-		rawURL)
-	out, err := exec.Command("/bin/sh", "-c", cmd).Output()
+func isJSONDownloadURL(href string) bool {
+	u, err := url.Parse(href)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to redirect to JSON URL %q while trying to crawl Azure with URL", rawURL)
-		return "", err
+		return false
 	}
-	return string(out), nil
+	return (u.Scheme == "http" || u.Scheme == "https") && strings.Contains(href, azureDownloadURLPattern)
 }
@@ -1,8 +1,15 @@
 package azure
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stackrox/external-network-pusher/pkg/common/testutils"
 	"github.com/stretchr/testify/require"
@@ -121,10 +128,18 @@ func TestAzureParseNetwork(t *testing.T) {
 	require.Nil(t, err)
 
 	crawler := azureNetworkCrawler{}
-	parsedResult, err := crawler.parseAzureNetworks([][]byte{cloud1Networks, cloud2Networks})
+	parsedResult, err := crawler.parseAzureNetworks([]azureCloudInfo{
+		{url: "https://example.com/cloud1.json", data: cloud1Networks},
+		{url: "https://example.com/cloud2.json", data: cloud2Networks},
+	})
 	require.Nil(t, err)
 	require.Equal(t, parsedResult.ProviderName, crawler.GetProviderKey().String())
 
+	// Every cloud is recorded as a source with the URL of its own JSON file
+	require.Len(t, parsedResult.Sources, 2)
+	require.Equal(t, "https://example.com/cloud1.json", parsedResult.Sources[0].URL)
+	require.Equal(t, "https://example.com/cloud2.json", parsedResult.Sources[1].URL)
+
 	// There should be 3 regions in total (c1r1, c2r2, c2)
 	require.Equal(t, 3, len(parsedResult.RegionNetworks))
 	regionNameToDetail := testutils.GetRegionNameToDetails(parsedResult)
@@ -271,7 +286,7 @@ func TestAzureRegionServiceRedundancyCheck(t *testing.T) {
 	require.Nil(t, err)
 
 	crawler := azureNetworkCrawler{}
-	parsedResult, err := crawler.parseAzureNetworks([][]byte{cloudNetworks})
+	parsedResult, err := crawler.parseAzureNetworks([]azureCloudInfo{{url: "https://example.com/cloud.json", data: cloudNetworks}})
 	require.Nil(t, err)
 	require.Equal(t, parsedResult.ProviderName, crawler.GetProviderKey().String())
 
@@ -296,3 +311,74 @@ func TestAzureRegionServiceRedundancyCheck(t *testing.T) {
 			[]string{})
 	}
 }
+
+func TestAzureFindJSONURL(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "details-56519.html"))
+	require.Nil(t, err)
+
+	jsonURL, err := findJSONURL(page)
+	require.Nil(t, err)
+	// Links within scripts, relative links and links to other pages should be ignored
+	require.Equal(
+		t,
+		"https://download.microsoft.com/download/7/1/D/71D86715-5596-4529-9B13-DA13A5DE5B63/ServiceTags_Public_20201019.json",
+		jsonURL)
+
+	jsonURL, err = findJSONURL([]byte(`<html><body><a href="https://www.microsoft.com">Microsoft</a></body></html>`))
+	require.Nil(t, err)
+	require.Equal(t, "", jsonURL)
+}
+
+func TestAzureCrawlFromDownloadPage(t *testing.T) {
+	page, err := os.ReadFile(filepath.Join("testdata", "details-56519.html"))
+	require.Nil(t, err)
+	serviceTags, err := os.ReadFile(filepath.Join("testdata", "ServiceTags_Public_20201019.json"))
+	require.Nil(t, err)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	// Serve the recorded page with the download links pointing to the test server
+	page = bytes.ReplaceAll(page, []byte("https://download.microsoft.com"), []byte(server.URL+"/download.microsoft.com"))
+	mux.HandleFunc("/download/details.aspx", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/en-us/download/details.aspx?"+r.URL.RawQuery, http.StatusFound)
+	})
+	mux.HandleFunc("/en-us/download/details.aspx", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(page)
+	})
+	mux.HandleFunc(
+		"/download.microsoft.com/download/7/1/D/71D86715-5596-4529-9B13-DA13A5DE5B63/ServiceTags_Public_20201019.json",
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(serviceTags)
+		})
+
+	crawler := azureNetworkCrawler{urls: []string{server.URL + "/download/details.aspx?id=56519"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	parsedResult, err := crawler.CrawlPublicNetworkRanges(ctx)
+	require.Nil(t, err)
+
+	// The upstream version and the resolved JSON URL are recorded
+	require.Len(t, parsedResult.Sources, 1)
+	require.Equal(t,
+		server.URL+"/download.microsoft.com/download/7/1/D/71D86715-5596-4529-9B13-DA13A5DE5B63/ServiceTags_Public_20201019.json",
+		parsedResult.Sources[0].URL)
+	require.Equal(t, "Public", parsedResult.Sources[0].Feed)
+	require.Equal(t, "121", parsedResult.Sources[0].Version)
+	require.False(t, parsedResult.Sources[0].FetchedAt.IsZero())
+
+	regionNameToDetail := testutils.GetRegionNameToDetails(parsedResult)
+	require.Len(t, regionNameToDetail, 2)
+	testutils.CheckServiceIPsInRegion(
+		t,
+		testutils.GetServiceNameToIPs(regionNameToDetail["Public"]),
+		toServiceName("Azure", "ActionGroup"),
+		[]string{"13.66.60.119/32"},
+		[]string{"2603:1030:c06:400::978/125"})
+	testutils.CheckServiceIPsInRegion(
+		t,
+		testutils.GetServiceNameToIPs(regionNameToDetail[toRegionName("Public", "australiacentral")]),
+		"Azure",
+		[]string{"20.36.32.0/19"},
+		[]string{})
+}
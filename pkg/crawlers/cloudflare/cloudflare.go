@@ -1,8 +1,10 @@
 package cloudflare
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stackrox/external-network-pusher/pkg/common"
@@ -46,8 +48,9 @@ func (c *cloudflareNetworkCrawler) GetNumRequiredIPPrefixes() int {
 	return 15
 }
 
-func (c *cloudflareNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkRanges, error) {
-	networkData, err := c.fetch()
+func (c *cloudflareNetworkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	fetchedAt := time.Now().UTC()
+	networkData, err := c.fetch(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch network data while crawling Cloudflare's network ranges")
 	}
@@ -56,11 +59,12 @@ func (c *cloudflareNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderN
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse Cloudflare's network data")
 	}
+	parsed.SetFetchedAt(fetchedAt)
 	return parsed, nil
 }
 
-func (c *cloudflareNetworkCrawler) fetch() ([]byte, error) {
-	return utils.HTTPGetWithRetry("Cloudflare", c.url)
+func (c *cloudflareNetworkCrawler) fetch(ctx context.Context) ([]byte, error) {
+	return utils.HTTPGetWithRetry(ctx, "Cloudflare", c.url)
 }
 
 func (c *cloudflareNetworkCrawler) parseNetworks(networks []byte) (*common.ProviderNetworkRanges, error) {
@@ -71,6 +75,10 @@ func (c *cloudflareNetworkCrawler) parseNetworks(networks []byte) (*common.Provi
 	}
 
 	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
+	providerNetworks.AddSource(&common.SourceMetadata{
+		URL:     c.url,
+		Version: cloudflareNetworkSpec.Result.ETag,
+	})
 	for _, ipv4Str := range cloudflareNetworkSpec.Result.IPv4CIDRs {
 		ipv4Str = unescapeIPPrefix(ipv4Str)
 		err :=
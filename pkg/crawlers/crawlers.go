@@ -8,6 +8,7 @@ import (
 	"github.com/stackrox/external-network-pusher/pkg/crawlers/azure"
 	"github.com/stackrox/external-network-pusher/pkg/crawlers/cloudflare"
 	"github.com/stackrox/external-network-pusher/pkg/crawlers/gcp"
+	"github.com/stackrox/external-network-pusher/pkg/crawlers/generic"
 	"github.com/stackrox/external-network-pusher/pkg/crawlers/oracle"
 )
 
@@ -20,6 +21,24 @@ var allCrawlers = []common.NetworkCrawler{
 	cloudflare.NewCloudflareNetworkCrawler(),
 }
 
+// RegisterFromSpecs adds a generic crawler for every provider spec file, see generic.Spec.
+// Their providers are registered as well, so it must be called before provider names
+// are resolved.
+func RegisterFromSpecs(paths []string) error {
+	for _, path := range paths {
+		spec, err := generic.LoadSpec(path)
+		if err != nil {
+			return err
+		}
+		crawler, err := generic.NewNetworkCrawler(spec)
+		if err != nil {
+			return err
+		}
+		allCrawlers = append(allCrawlers, crawler)
+	}
+	return nil
+}
+
 // Get returns list of provider specific NetworkCrawler implementations
 func Get(skippedProviders []common.Provider) []common.NetworkCrawler {
 	skippedProvidersSet := make(map[common.Provider]struct{})
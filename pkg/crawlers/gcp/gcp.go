@@ -1,13 +1,18 @@
 package gcp
 
 import (
+	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/common/utils"
 )
 
+// gcpCreationTimeLayout is the layout of creationTime. EX: 2020-10-22T13:43:51.596
+const gcpCreationTimeLayout = "2006-01-02T15:04:05.999999999"
+
 type gcpIPSpec struct {
 	Ipv4Prefix string `json:"ipv4Prefix"`
 	Ipv6Prefix string `json:"ipv6Prefix"`
@@ -43,8 +48,9 @@ func (c *gcpNetworkCrawler) GetNumRequiredIPPrefixes() int {
 	return 350
 }
 
-func (c *gcpNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkRanges, error) {
-	networkData, err := c.fetch()
+func (c *gcpNetworkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	fetchedAt := time.Now().UTC()
+	networkData, err := c.fetch(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch network data while crawling Google's network ranges")
 	}
@@ -54,11 +60,12 @@ func (c *gcpNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkR
 		return nil, errors.Wrap(err, "failed to parse Google's network data")
 	}
 
+	parsed.SetFetchedAt(fetchedAt)
 	return parsed, nil
 }
 
-func (c *gcpNetworkCrawler) fetch() ([]byte, error) {
-	return utils.HTTPGetWithRetry("Google", c.url)
+func (c *gcpNetworkCrawler) fetch(ctx context.Context) ([]byte, error) {
+	return utils.HTTPGetWithRetry(ctx, "Google", c.url)
 }
 
 func (c *gcpNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkRanges, error) {
@@ -69,6 +76,11 @@ func (c *gcpNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkR
 	}
 
 	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
+	providerNetworks.AddSource(&common.SourceMetadata{
+		URL:         c.url,
+		Version:     gcpNetworkSpec.SyncToken,
+		PublishedAt: common.ParseUpstreamTime(gcpNetworkSpec.CreationTime, gcpCreationTimeLayout),
+	})
 	for _, gcpIPSpec := range gcpNetworkSpec.Prefixes {
 		if gcpIPSpec.Ipv4Prefix == "" && gcpIPSpec.Ipv6Prefix == "" {
 			continue
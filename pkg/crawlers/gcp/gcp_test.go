@@ -3,6 +3,7 @@ package gcp
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stackrox/external-network-pusher/pkg/common/testutils"
 	"github.com/stretchr/testify/require"
@@ -161,3 +162,30 @@ func TestGCPRegionServiceRedundancyCheck(t *testing.T) {
 			[]string{})
 	}
 }
+
+func TestGCPParseSourceMetadata(t *testing.T) {
+	testData := gcpNetworkSpec{
+		SyncToken:    "1603373031596",
+		CreationTime: "2020-10-22T13:43:51.596",
+		Prefixes: []gcpIPSpec{
+			{
+				Ipv4Prefix: "34.80.0.0/15",
+				Service:    "Google Cloud",
+				Scope:      "asia-east1",
+			},
+		},
+	}
+	networks, err := json.Marshal(testData)
+	require.Nil(t, err)
+
+	crawler := gcpNetworkCrawler{url: "https://www.gstatic.com/ipranges/cloud.json"}
+	parsedResult, err := crawler.parseNetworks(networks)
+	require.Nil(t, err)
+
+	require.Len(t, parsedResult.Sources, 1)
+	source := parsedResult.Sources[0]
+	require.Equal(t, crawler.url, source.URL)
+	require.Equal(t, "1603373031596", source.Version)
+	require.NotNil(t, source.PublishedAt)
+	require.Equal(t, time.Date(2020, 10, 22, 13, 43, 51, 596000000, time.UTC), *source.PublishedAt)
+}
@@ -0,0 +1,30 @@
+package generic
+
+import (
+	"fmt"
+)
+
+// InvalidSpecError is returned when a provider spec is incomplete or malformed
+func InvalidSpecError(providerName, reason string) error {
+	return fmt.Errorf("provider spec %q is invalid: %s", providerName, reason)
+}
+
+// InvalidSelectorError is returned when a selector cannot be parsed
+func InvalidSelectorError(selector, reason string) error {
+	return fmt.Errorf("invalid selector %q: %s", selector, reason)
+}
+
+// NotAStringError is returned when a selector selects an object or an array instead of a string
+func NotAStringError(selector string, value interface{}) error {
+	return fmt.Errorf("selector %q selected %v, which is not a string", selector, value)
+}
+
+// MultipleValuesError is returned when a selector of a single value selects several values
+func MultipleValuesError(selector string, numValues int) error {
+	return fmt.Errorf("selector %q selected %d values, expected at most one", selector, numValues)
+}
+
+// FamilyMismatchError is returned when a prefix is not of the IP version of its prefix spec
+func FamilyMismatchError(prefix string, family Family) error {
+	return fmt.Errorf("prefix %s is not an %s prefix", prefix, family)
+}
@@ -0,0 +1,381 @@
+package generic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stackrox/external-network-pusher/pkg/common/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Family restricts the IP version of the prefixes selected by a PrefixSpec
+type Family string
+
+const (
+	// FamilyAny detects the IP version of every prefix
+	FamilyAny Family = ""
+	// FamilyIPv4 only accepts IPv4 prefixes
+	FamilyIPv4 Family = "ipv4"
+	// FamilyIPv6 only accepts IPv6 prefixes
+	FamilyIPv6 Family = "ipv6"
+)
+
+// RedundancyPolicy decides what happens when a prefix is listed for several region and
+// service pairs
+type RedundancyPolicy string
+
+const (
+	// RedundancyKeepAll keeps the prefix for every distinct pair
+	RedundancyKeepAll RedundancyPolicy = ""
+	// RedundancyFirst keeps the prefix for the first pair it is listed with
+	RedundancyFirst RedundancyPolicy = "first"
+	// RedundancyLast keeps the prefix for the last pair it is listed with
+	RedundancyLast RedundancyPolicy = "last"
+)
+
+// Spec describes a provider publishing its networks as a JSON document, and how to
+// read the prefixes of the document. It is written in YAML, or in JSON which is a
+// subset of YAML.
+//
+// Selectors are a subset of JSONPath, see selector. "$" is the root of the document
+// and "@" is the current item of PrefixSpec.Items. Values of region and service that
+// do not start with $ or @ are literal names.
+type Spec struct {
+	// Provider is the key of the provider, as used in the published networks and in
+	// --skipped-providers. It must not be a built-in provider.
+	Provider string `yaml:"provider"`
+	// Name is the human readable name of the provider. Defaults to Provider.
+	Name string `yaml:"name"`
+	// URL is where the JSON document is fetched from
+	URL string `yaml:"url"`
+	// MinPrefixes is the minimum number of prefixes a crawl must find to be published
+	MinPrefixes int `yaml:"minPrefixes"`
+	// Redundancy decides what happens when a prefix is listed for several region and
+	// service pairs
+	Redundancy RedundancyPolicy `yaml:"redundancy"`
+	// Version optionally selects the version identifier of the document, e.g. a syncToken
+	Version string `yaml:"version"`
+	// PublishedAt optionally selects when the document was published, parsed with
+	// PublishedAtLayout
+	PublishedAt       string `yaml:"publishedAt"`
+	PublishedAtLayout string `yaml:"publishedAtLayout"`
+	// Prefixes describe where the prefixes are in the document
+	Prefixes []*PrefixSpec `yaml:"prefixes"`
+}
+
+// PrefixSpec describes a list of prefixes in the document
+type PrefixSpec struct {
+	// Items selects the items of the list from the root of the document, e.g. $.prefixes[*]
+	Items string `yaml:"items"`
+	// Prefix selects the prefixes of an item, e.g. @.ip_prefix. Addresses without a
+	// prefix length are read as a single address prefix.
+	Prefix string `yaml:"prefix"`
+	// Region and Service select the region and service of an item, or are literal
+	// names. Items without region or service are published under common.DefaultRegion
+	// and common.DefaultService.
+	Region  string `yaml:"region"`
+	Service string `yaml:"service"`
+	// Family restricts the IP version of the prefixes. Prefixes of the other version
+	// fail the crawl, since the document is then not the one the spec describes.
+	Family Family `yaml:"family"`
+}
+
+type compiledPrefixSpec struct {
+	items   *selector
+	prefix  *valueSpec
+	region  *valueSpec
+	service *valueSpec
+	family  Family
+}
+
+type networkCrawler struct {
+	spec        *Spec
+	provider    common.Provider
+	version     *valueSpec
+	publishedAt *valueSpec
+	prefixes    []*compiledPrefixSpec
+}
+
+// LoadSpec reads the provider spec file at the path
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read provider spec %s", path)
+	}
+	spec := &Spec{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(spec); err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "failed to parse provider spec %s", path)
+	}
+	return spec, nil
+}
+
+// NewNetworkCrawler validates the spec, registers its provider and returns a crawler
+// of the provider
+func NewNetworkCrawler(spec *Spec) (common.NetworkCrawler, error) {
+	c, err := compile(spec)
+	if err != nil {
+		return nil, err
+	}
+	c.provider, err = common.RegisterProvider(spec.Provider)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compile validates the spec and compiles its selectors
+func compile(spec *Spec) (*networkCrawler, error) {
+	if spec.Provider == "" {
+		return nil, InvalidSpecError(spec.Provider, "a provider is required")
+	}
+	if spec.URL == "" {
+		return nil, InvalidSpecError(spec.Provider, "a URL is required")
+	}
+	if spec.MinPrefixes < 1 {
+		return nil, InvalidSpecError(spec.Provider, "the minimum number of prefixes must be at least 1")
+	}
+	switch spec.Redundancy {
+	case RedundancyKeepAll, RedundancyFirst, RedundancyLast:
+	default:
+		return nil, InvalidSpecError(spec.Provider, "unknown redundancy policy "+string(spec.Redundancy))
+	}
+	if spec.PublishedAt != "" && spec.PublishedAtLayout == "" {
+		return nil, InvalidSpecError(spec.Provider, "publishedAt requires a publishedAtLayout")
+	}
+	if len(spec.Prefixes) == 0 {
+		return nil, InvalidSpecError(spec.Provider, "at least one prefix list is required")
+	}
+
+	c := &networkCrawler{spec: spec}
+	var err error
+	if c.version, err = parseDocumentValueSpec(spec.Provider, spec.Version); err != nil {
+		return nil, err
+	}
+	if c.publishedAt, err = parseDocumentValueSpec(spec.Provider, spec.PublishedAt); err != nil {
+		return nil, err
+	}
+	for _, prefixSpec := range spec.Prefixes {
+		compiled := &compiledPrefixSpec{family: prefixSpec.Family}
+		switch prefixSpec.Family {
+		case FamilyAny, FamilyIPv4, FamilyIPv6:
+		default:
+			return nil, InvalidSpecError(spec.Provider, "unknown family "+string(prefixSpec.Family))
+		}
+		if compiled.items, err = parseSelector(prefixSpec.Items); err != nil {
+			return nil, errors.Wrapf(err, "invalid items of provider spec %s", spec.Provider)
+		}
+		if compiled.items.fromItem {
+			return nil, InvalidSpecError(spec.Provider, "items must be selected from the root $ of the document")
+		}
+		if prefixSpec.Prefix == "" {
+			return nil, InvalidSpecError(spec.Provider, "a prefix selector is required for items "+prefixSpec.Items)
+		}
+		if compiled.prefix, err = parseSelectorValueSpec(prefixSpec.Prefix); err != nil {
+			return nil, errors.Wrapf(err, "invalid prefix of provider spec %s", spec.Provider)
+		}
+		if compiled.region, err = parseValueSpec(prefixSpec.Region); err != nil {
+			return nil, errors.Wrapf(err, "invalid region of provider spec %s", spec.Provider)
+		}
+		if compiled.service, err = parseValueSpec(prefixSpec.Service); err != nil {
+			return nil, errors.Wrapf(err, "invalid service of provider spec %s", spec.Provider)
+		}
+		c.prefixes = append(c.prefixes, compiled)
+	}
+	return c, nil
+}
+
+// parseDocumentValueSpec parses an optional selector of the root of the document
+func parseDocumentValueSpec(providerName, raw string) (*valueSpec, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	sel, err := parseSelector(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid provider spec %s", providerName)
+	}
+	if sel.fromItem {
+		return nil, InvalidSpecError(providerName, "selector "+raw+" must start with the root $ of the document")
+	}
+	return &valueSpec{selector: sel}, nil
+}
+
+// parseSelectorValueSpec parses a value that must be a selector
+func parseSelectorValueSpec(raw string) (*valueSpec, error) {
+	sel, err := parseSelector(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &valueSpec{selector: sel}, nil
+}
+
+func (c *networkCrawler) GetHumanReadableProviderName() string {
+	if c.spec.Name != "" {
+		return c.spec.Name
+	}
+	return c.spec.Provider
+}
+
+func (c *networkCrawler) GetProviderKey() common.Provider {
+	return c.provider
+}
+
+func (c *networkCrawler) GetNumRequiredIPPrefixes() int {
+	return c.spec.MinPrefixes
+}
+
+func (c *networkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	fetchedAt := time.Now().UTC()
+	networkData, err := c.fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch network data while crawling %s's network ranges",
+			c.GetHumanReadableProviderName())
+	}
+
+	parsed, err := c.parseNetworks(networkData)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s's network data", c.GetHumanReadableProviderName())
+	}
+
+	parsed.SetFetchedAt(fetchedAt)
+	return parsed, nil
+}
+
+func (c *networkCrawler) fetch(ctx context.Context) ([]byte, error) {
+	return utils.HTTPGetWithRetry(ctx, c.GetHumanReadableProviderName(), c.spec.URL)
+}
+
+func (c *networkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkRanges, error) {
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as published, e.g. for numeric versions
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s's network data", c.GetHumanReadableProviderName())
+	}
+
+	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
+	source := &common.SourceMetadata{URL: c.spec.URL}
+	if c.version != nil {
+		version, err := c.version.evalString(document, nil)
+		if err != nil {
+			return nil, err
+		}
+		source.Version = version
+	}
+	if c.publishedAt != nil {
+		publishedAt, err := c.publishedAt.evalString(document, nil)
+		if err != nil {
+			return nil, err
+		}
+		source.PublishedAt = common.ParseUpstreamTime(publishedAt, c.spec.PublishedAtLayout)
+	}
+	providerNetworks.AddSource(source)
+
+	for _, prefixSpec := range c.prefixes {
+		items := prefixSpec.items.eval(document, nil)
+		if len(items) == 0 {
+			// The document may have changed its layout. Logging for warning
+			log.Printf("No items selected by %s in %s's network data", prefixSpec.items, c.GetHumanReadableProviderName())
+		}
+		for _, item := range items {
+			if err := c.addItem(providerNetworks, prefixSpec, document, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return providerNetworks, nil
+}
+
+func (c *networkCrawler) addItem(
+	providerNetworks *common.ProviderNetworkRanges,
+	prefixSpec *compiledPrefixSpec,
+	document, item interface{},
+) error {
+	ipPrefixes, err := prefixSpec.prefix.evalStrings(document, item)
+	if err != nil {
+		return err
+	}
+	region, err := prefixSpec.region.evalString(document, item)
+	if err != nil {
+		return err
+	}
+	if region == "" {
+		region = common.DefaultRegion
+	}
+	service, err := prefixSpec.service.evalString(document, item)
+	if err != nil {
+		return err
+	}
+	if service == "" {
+		service = common.DefaultService
+	}
+
+	for _, ipPrefix := range ipPrefixes {
+		if ipPrefix == "" {
+			// Empty prefix. Something might be wrong here. Logging for warning
+			log.Printf("Received an empty prefix definition from %s: %v", c.GetHumanReadableProviderName(), item)
+			continue
+		}
+		ipPrefix, err = toPrefix(ipPrefix, prefixSpec.family)
+		if err != nil {
+			return err
+		}
+		err = providerNetworks.AddIPPrefix(region, service, ipPrefix, c.getComputeRedundancyFn())
+		if err != nil {
+			return errors.Wrapf(err, "failed to add %s prefix: %s", c.GetHumanReadableProviderName(), ipPrefix)
+		}
+	}
+	return nil
+}
+
+// toPrefix returns the prefix, or the single address prefix of an address, after
+// checking its IP version against the family
+func toPrefix(value string, family Family) (string, error) {
+	value = strings.TrimSpace(value)
+	var addr netip.Addr
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse prefix %s", value)
+		}
+		addr = prefix.Addr()
+	} else {
+		var err error
+		addr, err = netip.ParseAddr(value)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse address %s", value)
+		}
+		value = netip.PrefixFrom(addr, addr.BitLen()).String()
+	}
+	isIPv4 := addr.Unmap().Is4()
+	if (family == FamilyIPv4 && !isIPv4) || (family == FamilyIPv6 && isIPv4) {
+		return "", FamilyMismatchError(value, family)
+	}
+	return value, nil
+}
+
+func (c *networkCrawler) getComputeRedundancyFn() common.IsRedundantRegionServicePairFn {
+	switch c.spec.Redundancy {
+	case RedundancyFirst:
+		return func(newPair, _ *common.RegionServicePair) (*common.RegionServicePair, error) {
+			return newPair, nil
+		}
+	case RedundancyLast:
+		return func(_, existingPair *common.RegionServicePair) (*common.RegionServicePair, error) {
+			return existingPair, nil
+		}
+	default:
+		return common.GetDefaultRegionServicePairRedundancyCheck()
+	}
+}
@@ -0,0 +1,167 @@
+package generic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+// awsLikeSpec reads a document laid out like the Amazon ip-ranges.json
+const awsLikeSpec = `
+provider: AmazonLike
+name: Amazon-like
+url: https://example.com/ip-ranges.json
+minPrefixes: 3
+version: $.syncToken
+publishedAt: $.createDate
+publishedAtLayout: 2006-01-02-15-04-05
+prefixes:
+  - items: $.prefixes[*]
+    prefix: "@.ip_prefix"
+    region: "@.region"
+    service: "@.service"
+    family: ipv4
+  - items: $.ipv6_prefixes[*]
+    prefix: "@.ipv6_prefix"
+    region: "@.region"
+    service: "@.service"
+    family: ipv6
+`
+
+const awsLikeDocument = `{
+	"syncToken": "1700000000",
+	"createDate": "2023-11-14-22-13-20",
+	"prefixes": [
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "EC2"},
+		{"ip_prefix": "52.93.153.170", "region": "eu-west-2", "service": "AMAZON"},
+		{"ip_prefix": "", "region": "eu-west-2", "service": "AMAZON"}
+	],
+	"ipv6_prefixes": [
+		{"ipv6_prefix": "2600:1f14:fff:f800::/56", "region": "us-west-2"}
+	]
+}`
+
+func writeSpec(t *testing.T, spec string) string {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	require.Nil(t, os.WriteFile(path, []byte(spec), 0644))
+	return path
+}
+
+func TestParseNetworks(t *testing.T) {
+	spec, err := LoadSpec(writeSpec(t, awsLikeSpec))
+	require.Nil(t, err)
+	crawler, err := compile(spec)
+	require.Nil(t, err)
+	require.Equal(t, "Amazon-like", crawler.GetHumanReadableProviderName())
+	require.Equal(t, 3, crawler.GetNumRequiredIPPrefixes())
+
+	networks, err := crawler.parseNetworks([]byte(awsLikeDocument))
+	require.Nil(t, err)
+	require.Len(t, networks.Sources, 1)
+	require.Equal(t, "1700000000", networks.Sources[0].Version)
+	require.Equal(t, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC), *networks.Sources[0].PublishedAt)
+	require.ElementsMatch(t, []*common.RegionNetworkDetail{
+		{
+			RegionName: "ap-northeast-2",
+			ServiceNetworks: []*common.ServiceIPRanges{
+				{ServiceName: "AMAZON", IPv4Prefixes: []string{"3.5.140.0/22"}},
+				{ServiceName: "EC2", IPv4Prefixes: []string{"3.5.140.0/22"}},
+			},
+		},
+		{
+			RegionName: "eu-west-2",
+			ServiceNetworks: []*common.ServiceIPRanges{
+				{ServiceName: "AMAZON", IPv4Prefixes: []string{"52.93.153.170/32"}},
+			},
+		},
+		{
+			RegionName: "us-west-2",
+			ServiceNetworks: []*common.ServiceIPRanges{
+				{ServiceName: common.DefaultService, IPv6Prefixes: []string{"2600:1f14:fff:f800::/56"}},
+			},
+		},
+	}, networks.RegionNetworks)
+
+	// Keeping the first pair drops the EC2 service of the duplicated prefix
+	spec.Redundancy = RedundancyFirst
+	crawler, err = compile(spec)
+	require.Nil(t, err)
+	networks, err = crawler.parseNetworks([]byte(awsLikeDocument))
+	require.Nil(t, err)
+	require.Len(t, networks.RegionNetworks[0].ServiceNetworks, 1)
+	require.Equal(t, "AMAZON", networks.RegionNetworks[0].ServiceNetworks[0].ServiceName)
+
+	// Prefixes of the wrong IP version mean the document is not the one the spec describes
+	_, err = crawler.parseNetworks([]byte(`{"prefixes": [{"ip_prefix": "2600:1f14::/32"}]}`))
+	require.Error(t, err)
+}
+
+func TestParseNetworksCloudflareLike(t *testing.T) {
+	crawler, err := compile(&Spec{
+		Provider:    "CloudflareLike",
+		URL:         "https://example.com/ips",
+		MinPrefixes: 1,
+		Version:     "$.result.etag",
+		Prefixes: []*PrefixSpec{
+			{Items: "$.result", Prefix: "@.ipv4_cidrs[*]", Region: "global", Service: "cdn"},
+			{Items: "$.result", Prefix: "@.ipv6_cidrs[*]", Region: "global", Service: "cdn"},
+		},
+	})
+	require.Nil(t, err)
+	networks, err := crawler.parseNetworks([]byte(`{"result": {
+		"ipv4_cidrs": ["173.245.48.0/20", "103.21.244.0/22"],
+		"ipv6_cidrs": ["2400:cb00::/32"],
+		"etag": "38f79d050aa027e3be3865e495dcc9bc"
+	}}`))
+	require.Nil(t, err)
+	require.Equal(t, "38f79d050aa027e3be3865e495dcc9bc", networks.Sources[0].Version)
+	require.Equal(t, []*common.RegionNetworkDetail{{
+		RegionName: "global",
+		ServiceNetworks: []*common.ServiceIPRanges{{
+			ServiceName:  "cdn",
+			IPv4Prefixes: []string{"173.245.48.0/20", "103.21.244.0/22"},
+			IPv6Prefixes: []string{"2400:cb00::/32"},
+		}},
+	}}, networks.RegionNetworks)
+}
+
+func TestNewNetworkCrawler(t *testing.T) {
+	spec, err := LoadSpec(writeSpec(t, awsLikeSpec))
+	require.Nil(t, err)
+	crawler, err := NewNetworkCrawler(spec)
+	require.Nil(t, err)
+	provider, err := common.ToProvider("AmazonLike")
+	require.Nil(t, err)
+	require.Equal(t, provider, crawler.GetProviderKey())
+
+	// Providers cannot be registered twice, nor replace built-in providers
+	_, err = NewNetworkCrawler(spec)
+	require.Error(t, err)
+	spec.Provider = common.Amazon.String()
+	_, err = NewNetworkCrawler(spec)
+	require.Error(t, err)
+
+	// Unknown fields are rejected, so that a misspelled field is not silently ignored
+	_, err = LoadSpec(writeSpec(t, "provider: P\nurl: u\nminPrefixes: 1\nprefixs: []"))
+	require.Error(t, err)
+
+	for _, invalid := range []*Spec{
+		{URL: "u", MinPrefixes: 1, Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b"}}},
+		{Provider: "P", MinPrefixes: 1, Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b"}}},
+		{Provider: "P", URL: "u", Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b"}}},
+		{Provider: "P", URL: "u", MinPrefixes: 1},
+		{Provider: "P", URL: "u", MinPrefixes: 1, Prefixes: []*PrefixSpec{{Items: "@.a", Prefix: "@.b"}}},
+		{Provider: "P", URL: "u", MinPrefixes: 1, Prefixes: []*PrefixSpec{{Items: "$.a"}}},
+		{Provider: "P", URL: "u", MinPrefixes: 1, Redundancy: "none", Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b"}}},
+		{Provider: "P", URL: "u", MinPrefixes: 1, PublishedAt: "$.date", Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b"}}},
+		{Provider: "P", URL: "u", MinPrefixes: 1, Prefixes: []*PrefixSpec{{Items: "$.a", Prefix: "@.b", Family: "ipv5"}}},
+	} {
+		_, err := compile(invalid)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,187 @@
+package generic
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// selector is a compiled JSONPath-style selector. It supports the subset of JSONPath
+// the feeds need: the root "$" of the document or the current item "@", followed by
+// any number of ".name", "['name']", "[index]", ".*" and "[*]" segments. Wildcards
+// select every element of an array, or every value of an object in key order.
+type selector struct {
+	raw      string
+	fromItem bool
+	segments []segment
+}
+
+type segment struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseSelector(raw string) (*selector, error) {
+	if raw == "" || (raw[0] != '$' && raw[0] != '@') {
+		return nil, InvalidSelectorError(raw, "must start with $ or @")
+	}
+	sel := &selector{raw: raw, fromItem: raw[0] == '@'}
+	rest := raw[1:]
+	for rest != "" {
+		var seg segment
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "*") {
+				seg.wildcard = true
+				rest = rest[1:]
+				break
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, InvalidSelectorError(raw, "empty field name")
+			}
+			seg.name, rest = rest[:end], rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, InvalidSelectorError(raw, "unclosed [")
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				seg.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.name = inner[1 : len(inner)-1]
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, InvalidSelectorError(raw, "expected a quoted field name, an index or * in []")
+				}
+				seg.index, seg.isIndex = index, true
+			}
+		default:
+			return nil, InvalidSelectorError(raw, "expected . or [")
+		}
+		sel.segments = append(sel.segments, seg)
+	}
+	return sel, nil
+}
+
+func (s *selector) String() string {
+	return s.raw
+}
+
+// eval returns the values the selector selects in the document or in the current item.
+// Values that do not exist are not selected.
+func (s *selector) eval(document, item interface{}) []interface{} {
+	values := []interface{}{document}
+	if s.fromItem {
+		values = []interface{}{item}
+	}
+	for _, seg := range s.segments {
+		var next []interface{}
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				if seg.wildcard {
+					keys := make([]string, 0, len(v))
+					for key := range v {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, v[key])
+					}
+				} else if elem, ok := v[seg.name]; ok && !seg.isIndex {
+					next = append(next, elem)
+				}
+			case []interface{}:
+				if seg.wildcard {
+					next = append(next, v...)
+				} else if seg.isIndex && seg.index < len(v) {
+					next = append(next, v[seg.index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// toString converts a selected scalar to a string. Numbers keep the notation of the
+// document.
+func toString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// valueSpec is either a selector or a literal value
+type valueSpec struct {
+	selector *selector
+	literal  string
+}
+
+// parseValueSpec parses values starting with $ or @ as selectors. Other values are literals.
+func parseValueSpec(raw string) (*valueSpec, error) {
+	if raw == "" || (raw[0] != '$' && raw[0] != '@') {
+		return &valueSpec{literal: raw}, nil
+	}
+	sel, err := parseSelector(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &valueSpec{selector: sel}, nil
+}
+
+// evalStrings returns the selected strings, or the literal. Empty literals select nothing.
+func (v *valueSpec) evalStrings(document, item interface{}) ([]string, error) {
+	if v.selector == nil {
+		if v.literal == "" {
+			return nil, nil
+		}
+		return []string{v.literal}, nil
+	}
+	values := v.selector.eval(document, item)
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		str, ok := toString(value)
+		if !ok {
+			return nil, NotAStringError(v.selector.String(), value)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+// evalString returns the selected string, the literal, or an empty string if nothing
+// is selected. Selecting more than one value is an error.
+func (v *valueSpec) evalString(document, item interface{}) (string, error) {
+	strs, err := v.evalStrings(document, item)
+	if err != nil {
+		return "", err
+	}
+	switch len(strs) {
+	case 0:
+		return "", nil
+	case 1:
+		return strs[0], nil
+	default:
+		return "", MultipleValuesError(v.selector.String(), len(strs))
+	}
+}
@@ -0,0 +1,74 @@
+package generic
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector(t *testing.T) {
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(`{
+		"syncToken": 1700000000,
+		"result": {"ipv4_cidrs": ["173.245.48.0/20", "103.21.244.0/22"]},
+		"prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2"},
+			{"ip_prefix": "13.34.37.64/27", "region": "ap-southeast-4"}
+		],
+		"odd key": {"b": "2", "a": "1"}
+	}`)))
+	decoder.UseNumber()
+	require.Nil(t, decoder.Decode(&document))
+
+	for raw, expected := range map[string][]interface{}{
+		"$.syncToken":                    {json.Number("1700000000")},
+		"$.result.ipv4_cidrs[*]":         {"173.245.48.0/20", "103.21.244.0/22"},
+		"$['result'][\"ipv4_cidrs\"][1]": {"103.21.244.0/22"},
+		"$.prefixes[*].region":           {"ap-northeast-2", "ap-southeast-4"},
+		"$.prefixes[5].region":           nil,
+		"$.missing.field":                nil,
+		"$['odd key'].*":                 {"1", "2"},
+	} {
+		sel, err := parseSelector(raw)
+		require.Nil(t, err, raw)
+		require.Equal(t, expected, sel.eval(document, nil), raw)
+	}
+
+	item := map[string]interface{}{"ip_prefix": "3.5.140.0/22"}
+	sel, err := parseSelector("@.ip_prefix")
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{"3.5.140.0/22"}, sel.eval(document, item))
+
+	for _, invalid := range []string{"", "prefixes", "$.", "$..a", "$[", "$[-1]", "$[a]", "$a"} {
+		_, err := parseSelector(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestValueSpec(t *testing.T) {
+	document := map[string]interface{}{"region": "us-east-1", "regions": []interface{}{"a", "b"}, "obj": map[string]interface{}{}}
+
+	literal, err := parseValueSpec("global")
+	require.Nil(t, err)
+	value, err := literal.evalString(document, nil)
+	require.Nil(t, err)
+	require.Equal(t, "global", value)
+
+	sel, err := parseValueSpec("$.region")
+	require.Nil(t, err)
+	value, err = sel.evalString(document, nil)
+	require.Nil(t, err)
+	require.Equal(t, "us-east-1", value)
+
+	sel, err = parseValueSpec("$.regions[*]")
+	require.Nil(t, err)
+	_, err = sel.evalString(document, nil)
+	require.Error(t, err)
+
+	sel, err = parseValueSpec("$.obj")
+	require.Nil(t, err)
+	_, err = sel.evalStrings(document, nil)
+	require.Error(t, err)
+}
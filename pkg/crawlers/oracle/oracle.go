@@ -1,14 +1,20 @@
 package oracle
 
 import (
+	"context"
 	"encoding/json"
 	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stackrox/external-network-pusher/pkg/common"
 	"github.com/stackrox/external-network-pusher/pkg/common/utils"
 )
 
+// ociLastUpdatedTimestampLayouts are the layouts of last_updated_timestamp
+// observed so far. EX: 2020-11-04T18:01:00.360353
+var ociLastUpdatedTimestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02-15-04-05"}
+
 type ociNetworkCrawler struct {
 	url string
 }
@@ -46,8 +52,9 @@ func (c *ociNetworkCrawler) GetNumRequiredIPPrefixes() int {
 	return 200
 }
 
-func (c *ociNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkRanges, error) {
-	networkData, err := c.fetch()
+func (c *ociNetworkCrawler) CrawlPublicNetworkRanges(ctx context.Context) (*common.ProviderNetworkRanges, error) {
+	fetchedAt := time.Now().UTC()
+	networkData, err := c.fetch(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to fetch network data while crawling Oracle's network ranges")
 	}
@@ -57,11 +64,12 @@ func (c *ociNetworkCrawler) CrawlPublicNetworkRanges() (*common.ProviderNetworkR
 		return nil, errors.Wrap(err, "failed to parse Oracle's network data")
 	}
 
+	parsed.SetFetchedAt(fetchedAt)
 	return parsed, nil
 }
 
-func (c *ociNetworkCrawler) fetch() ([]byte, error) {
-	return utils.HTTPGetWithRetry("Oracle", c.url)
+func (c *ociNetworkCrawler) fetch(ctx context.Context) ([]byte, error) {
+	return utils.HTTPGetWithRetry(ctx, "Oracle", c.url)
 }
 
 func (c *ociNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkRanges, error) {
@@ -72,6 +80,14 @@ func (c *ociNetworkCrawler) parseNetworks(data []byte) (*common.ProviderNetworkR
 	}
 
 	providerNetworks := common.NewProviderNetworkRanges(c.GetProviderKey().String())
+	providerNetworks.AddSource(&common.SourceMetadata{
+		URL: c.url,
+		// Oracle does not publish any other version identifier
+		Version: ociNetworkSpec.LastUpdatedTimestamp,
+		PublishedAt: common.ParseUpstreamTime(
+			ociNetworkSpec.LastUpdatedTimestamp,
+			ociLastUpdatedTimestampLayouts...),
+	})
 	for _, regionNetworks := range ociNetworkSpec.Regions {
 		for _, cidrDef := range regionNetworks.CIDRs {
 			// sort the tags before creating service name to make service name consistent
@@ -3,6 +3,7 @@ package oracle
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stackrox/external-network-pusher/pkg/common/testutils"
 	"github.com/stretchr/testify/require"
@@ -182,3 +183,41 @@ func TestOCIRegionServiceRedundancyCheck(t *testing.T) {
 			[]string{})
 	}
 }
+
+func TestOCIParseSourceMetadata(t *testing.T) {
+	testData := ociNetworkSpec{
+		LastUpdatedTimestamp: "2020-11-04T18:01:00.360353",
+		Regions: []ociRegionNetworkDetails{
+			{
+				Region: "us-phoenix-1",
+				CIDRs: []ociCIDRDefinition{
+					{
+						CIDR: "129.146.0.0/21",
+						Tags: []string{"OCI"},
+					},
+				},
+			},
+		},
+	}
+	networks, err := json.Marshal(testData)
+	require.Nil(t, err)
+
+	crawler := ociNetworkCrawler{url: "https://docs.cloud.oracle.com/en-us/iaas/tools/public_ip_ranges.json"}
+	parsedResult, err := crawler.parseNetworks(networks)
+	require.Nil(t, err)
+
+	require.Len(t, parsedResult.Sources, 1)
+	source := parsedResult.Sources[0]
+	require.Equal(t, crawler.url, source.URL)
+	require.Equal(t, "2020-11-04T18:01:00.360353", source.Version)
+	require.NotNil(t, source.PublishedAt)
+	require.Equal(t, time.Date(2020, 11, 4, 18, 1, 0, 360353000, time.UTC), *source.PublishedAt)
+
+	// Unknown timestamp formats are not fatal
+	testData.LastUpdatedTimestamp = testutils.UnusedString
+	networks, err = json.Marshal(testData)
+	require.Nil(t, err)
+	parsedResult, err = crawler.parseNetworks(networks)
+	require.Nil(t, err)
+	require.Nil(t, parsedResult.Sources[0].PublishedAt)
+}
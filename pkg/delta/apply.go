@@ -0,0 +1,118 @@
+package delta
+
+import (
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+// Apply returns the networks the delta results in, given the networks it applies to.
+// The base networks are canonicalized but not modified otherwise. The checksums of
+// both the base and the resulting networks are verified.
+func Apply(base *common.ExternalNetworkSources, d *Delta) (*common.ExternalNetworkSources, error) {
+	if d.SchemaVersion > SchemaVersion {
+		return nil, UnsupportedSchemaVersionError(d.SchemaVersion)
+	}
+	base.Canonicalize()
+	_, baseChecksum, err := common.MarshalAndChecksum(base)
+	if err != nil {
+		return nil, err
+	}
+	if baseChecksum != d.FromChecksum {
+		return nil, common.ChecksumMismatchError(d.From, d.FromChecksum, baseChecksum)
+	}
+
+	baseProviders := make(map[string]*common.ProviderNetworkRanges, len(base.ProviderNetworks))
+	for _, provider := range base.ProviderNetworks {
+		baseProviders[provider.ProviderName] = provider
+	}
+	result := &common.ExternalNetworkSources{
+		ProviderNetworks: make([]*common.ProviderNetworkRanges, 0, len(base.ProviderNetworks)),
+	}
+	changed := make(map[string]bool, len(d.Providers))
+	for _, providerDelta := range d.Providers {
+		changed[providerDelta.ProviderName] = true
+		if providerDelta.Deleted {
+			continue
+		}
+		provider, err := applyProvider(baseProviders[providerDelta.ProviderName], providerDelta)
+		if err != nil {
+			return nil, err
+		}
+		result.ProviderNetworks = append(result.ProviderNetworks, provider)
+	}
+	for _, provider := range base.ProviderNetworks {
+		if !changed[provider.ProviderName] {
+			result.ProviderNetworks = append(result.ProviderNetworks, provider)
+		}
+	}
+
+	result.Canonicalize()
+	_, checksum, err := common.MarshalAndChecksum(result)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != d.Checksum {
+		return nil, common.ChecksumMismatchError(d.To, d.Checksum, checksum)
+	}
+	return result, nil
+}
+
+// ApplyAndVerify applies the delta and verifies that the result is the run described
+// by the manifest
+func ApplyAndVerify(base *common.ExternalNetworkSources, d *Delta, manifest *common.Manifest) (*common.ExternalNetworkSources, error) {
+	if d.To != manifest.RunFolder {
+		return nil, RunFolderMismatchError(d.To, manifest.RunFolder)
+	}
+	if d.Checksum != manifest.Checksum {
+		return nil, common.ChecksumMismatchError(manifest.RunFolder, manifest.Checksum, d.Checksum)
+	}
+	return Apply(base, d)
+}
+
+func applyProvider(base *common.ProviderNetworkRanges, d *ProviderDelta) (*common.ProviderNetworkRanges, error) {
+	prefixes := prefixesOf(base)
+	for _, region := range d.Removed {
+		for _, service := range region.ServiceNetworks {
+			sets := prefixes[region.RegionName][service.ServiceName]
+			for _, prefix := range service.IPv4Prefixes {
+				if sets == nil || !sets.ipv4[prefix] {
+					return nil, PrefixNotFoundError(d.ProviderName, region.RegionName, service.ServiceName, prefix)
+				}
+				delete(sets.ipv4, prefix)
+			}
+			for _, prefix := range service.IPv6Prefixes {
+				if sets == nil || !sets.ipv6[prefix] {
+					return nil, PrefixNotFoundError(d.ProviderName, region.RegionName, service.ServiceName, prefix)
+				}
+				delete(sets.ipv6, prefix)
+			}
+		}
+	}
+	for _, region := range d.Added {
+		if prefixes[region.RegionName] == nil {
+			prefixes[region.RegionName] = make(map[string]*prefixSets)
+		}
+		for _, service := range region.ServiceNetworks {
+			sets := prefixes[region.RegionName][service.ServiceName]
+			if sets == nil {
+				sets = &prefixSets{ipv4: make(map[string]bool), ipv6: make(map[string]bool)}
+				prefixes[region.RegionName][service.ServiceName] = sets
+			}
+			for _, prefix := range service.IPv4Prefixes {
+				sets.ipv4[prefix] = true
+			}
+			for _, prefix := range service.IPv6Prefixes {
+				sets.ipv6[prefix] = true
+			}
+		}
+	}
+
+	provider := &common.ProviderNetworkRanges{
+		ProviderName:   d.ProviderName,
+		RegionNetworks: subtract(prefixes, nil),
+		Sources:        d.Sources,
+	}
+	if provider.RegionNetworks == nil {
+		provider.RegionNetworks = make([]*common.RegionNetworkDetail, 0)
+	}
+	return provider, nil
+}
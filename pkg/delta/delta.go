@@ -0,0 +1,176 @@
+package delta
+
+import (
+	"path"
+	"sort"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+// SchemaVersion is the version of the Delta format. It is incremented whenever a
+// change to Delta is not backwards compatible.
+const SchemaVersion = 1
+
+// fileNamePrefix is the prefix of the names of the delta files in a run folder
+const fileNamePrefix = "delta_from_"
+
+// Delta is the difference between the networks of two runs, in a form compact
+// enough to be downloaded instead of the full networks of the newer run. Applying
+// it to the networks of the older run gives the networks of the newer run.
+type Delta struct {
+	// SchemaVersion is the SchemaVersion the delta was written with
+	SchemaVersion int `json:"schemaVersion"`
+	// From is the run folder of the networks the delta applies to
+	From string `json:"from"`
+	// FromChecksum is the checksum of the canonical form of the networks the delta applies to
+	FromChecksum string `json:"fromChecksum"`
+	// To is the run folder of the networks the delta results in
+	To string `json:"to"`
+	// Checksum is the checksum of the networks the delta results in. Same as the
+	// checksum of the run To.
+	Checksum string `json:"checksum"`
+	// Providers are the changes of every provider of the resulting networks, and of
+	// the deleted providers
+	Providers []*ProviderDelta `json:"providers"`
+}
+
+// ProviderDelta contains the changes of a provider
+type ProviderDelta struct {
+	ProviderName string `json:"providerName"`
+	// Deleted is true if the provider is no longer published
+	Deleted bool `json:"deleted,omitempty"`
+	// Sources replace the sources of the provider
+	Sources []*common.SourceMetadata `json:"sources,omitempty"`
+	// Removed are the prefixes removed from the services of the provider
+	Removed []*common.RegionNetworkDetail `json:"removed,omitempty"`
+	// Added are the prefixes added to the services of the provider
+	Added []*common.RegionNetworkDetail `json:"added,omitempty"`
+}
+
+// FileName returns the name of the file, in the folder of a run, of the delta from
+// the networks of the given run folder
+func FileName(fromRunFolder string) string {
+	return fileNamePrefix + path.Base(fromRunFolder)
+}
+
+// New returns the delta from the networks of a run to the networks of another run.
+// Both networks are canonicalized.
+func New(fromRunFolder string, from *common.ExternalNetworkSources, toRunFolder string, to *common.ExternalNetworkSources) (*Delta, error) {
+	from.Canonicalize()
+	to.Canonicalize()
+	_, fromChecksum, err := common.MarshalAndChecksum(from)
+	if err != nil {
+		return nil, err
+	}
+	_, checksum, err := common.MarshalAndChecksum(to)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Delta{
+		SchemaVersion: SchemaVersion,
+		From:          fromRunFolder,
+		FromChecksum:  fromChecksum,
+		To:            toRunFolder,
+		Checksum:      checksum,
+		Providers:     make([]*ProviderDelta, 0, len(to.ProviderNetworks)),
+	}
+	fromProviders := make(map[string]*common.ProviderNetworkRanges, len(from.ProviderNetworks))
+	for _, provider := range from.ProviderNetworks {
+		fromProviders[provider.ProviderName] = provider
+	}
+	toProviders := make(map[string]bool, len(to.ProviderNetworks))
+	for _, provider := range to.ProviderNetworks {
+		toProviders[provider.ProviderName] = true
+		fromPrefixes := prefixesOf(fromProviders[provider.ProviderName])
+		toPrefixes := prefixesOf(provider)
+		d.Providers = append(d.Providers, &ProviderDelta{
+			ProviderName: provider.ProviderName,
+			Sources:      provider.Sources,
+			Removed:      subtract(fromPrefixes, toPrefixes),
+			Added:        subtract(toPrefixes, fromPrefixes),
+		})
+	}
+	for _, provider := range from.ProviderNetworks {
+		if !toProviders[provider.ProviderName] {
+			d.Providers = append(d.Providers, &ProviderDelta{ProviderName: provider.ProviderName, Deleted: true})
+		}
+	}
+	sort.Slice(d.Providers, func(i, j int) bool {
+		return d.Providers[i].ProviderName < d.Providers[j].ProviderName
+	})
+	return d, nil
+}
+
+// servicePrefixes are the IPv4 and IPv6 prefixes of every region and service
+type servicePrefixes map[string]map[string]*prefixSets
+
+type prefixSets struct {
+	ipv4 map[string]bool
+	ipv6 map[string]bool
+}
+
+func prefixesOf(provider *common.ProviderNetworkRanges) servicePrefixes {
+	result := make(servicePrefixes)
+	if provider == nil {
+		return result
+	}
+	for _, region := range provider.RegionNetworks {
+		result[region.RegionName] = make(map[string]*prefixSets)
+		for _, service := range region.ServiceNetworks {
+			sets := &prefixSets{ipv4: make(map[string]bool), ipv6: make(map[string]bool)}
+			for _, prefix := range service.IPv4Prefixes {
+				sets.ipv4[prefix] = true
+			}
+			for _, prefix := range service.IPv6Prefixes {
+				sets.ipv6[prefix] = true
+			}
+			result[region.RegionName][service.ServiceName] = sets
+		}
+	}
+	return result
+}
+
+// subtract returns the prefixes of a that are not in the same region and service in b,
+// in canonical order
+func subtract(a, b servicePrefixes) []*common.RegionNetworkDetail {
+	var result []*common.RegionNetworkDetail
+	for regionName, services := range a {
+		region := &common.RegionNetworkDetail{RegionName: regionName}
+		for serviceName, sets := range services {
+			var other *prefixSets
+			if b[regionName] != nil {
+				other = b[regionName][serviceName]
+			}
+			service := &common.ServiceIPRanges{
+				ServiceName:  serviceName,
+				IPv4Prefixes: subtractSet(sets.ipv4, other, true),
+				IPv6Prefixes: subtractSet(sets.ipv6, other, false),
+			}
+			if len(service.IPv4Prefixes)+len(service.IPv6Prefixes) > 0 {
+				region.ServiceNetworks = append(region.ServiceNetworks, service)
+			}
+		}
+		if len(region.ServiceNetworks) > 0 {
+			result = append(result, region)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	// Reuse the canonical order of the networks
+	provider := &common.ProviderNetworkRanges{RegionNetworks: result}
+	provider.Canonicalize()
+	return provider.RegionNetworks
+}
+
+func subtractSet(a map[string]bool, b *prefixSets, ipv4 bool) []string {
+	var result []string
+	for prefix := range a {
+		if b != nil && ((ipv4 && b.ipv4[prefix]) || (!ipv4 && b.ipv6[prefix])) {
+			continue
+		}
+		result = append(result, prefix)
+	}
+	return result
+}
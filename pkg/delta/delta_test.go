@@ -0,0 +1,151 @@
+package delta
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	provider, region, service, prefix string
+}
+
+func newNetworks(t *testing.T, entries ...entry) *common.ExternalNetworkSources {
+	providers := make(map[string]*common.ProviderNetworkRanges)
+	networks := &common.ExternalNetworkSources{}
+	for _, e := range entries {
+		provider, ok := providers[e.provider]
+		if !ok {
+			provider = common.NewProviderNetworkRanges(e.provider)
+			provider.AddSource(&common.SourceMetadata{URL: "https://example.com/" + e.provider, Version: "1"})
+			providers[e.provider] = provider
+			networks.ProviderNetworks = append(networks.ProviderNetworks, provider)
+		}
+		err := provider.AddIPPrefix(e.region, e.service, e.prefix, common.GetDefaultRegionServicePairRedundancyCheck())
+		require.Nil(t, err)
+	}
+	return networks
+}
+
+// roundTrip returns the networks as read back by a consumer
+func roundTrip(t *testing.T, networks *common.ExternalNetworkSources) *common.ExternalNetworkSources {
+	data, err := json.Marshal(networks)
+	require.Nil(t, err)
+	var result common.ExternalNetworkSources
+	require.Nil(t, json.Unmarshal(data, &result))
+	return &result
+}
+
+func roundTripDelta(t *testing.T, d *Delta) *Delta {
+	data, err := json.Marshal(d)
+	require.Nil(t, err)
+	var result Delta
+	require.Nil(t, json.Unmarshal(data, &result))
+	return &result
+}
+
+func TestNewAndApply(t *testing.T) {
+	from := newNetworks(t,
+		entry{"Amazon", "us-east-1", "EC2", "3.5.140.0/22"},
+		entry{"Amazon", "us-east-1", "EC2", "35.180.0.0/16"},
+		entry{"Amazon", "us-west-1", "S3", "2600:1f15::/32"},
+		entry{"Amazon", "us-west-1", "S3", "3.5.140.0/22"},
+		entry{"Cloudflare", "default", "default", "173.245.48.0/20"},
+		entry{"Google", "asia-east1", "Google Cloud", "34.80.0.0/15"},
+	)
+	to := newNetworks(t,
+		entry{"Amazon", "us-east-1", "EC2", "35.180.0.0/16"},
+		entry{"Amazon", "us-east-1", "EC2", "52.93.178.234/32"},
+		entry{"Amazon", "us-west-1", "S3", "3.5.140.0/22"},
+		entry{"Amazon", "eu-west-1", "S3", "2a05:d07a:a000::/40"},
+		entry{"Google", "asia-east1", "Google Cloud", "34.80.0.0/15"},
+		entry{"Oracle", "us-phoenix-1", "OSN", "129.146.0.0/21"},
+	)
+	to.ProviderNetworks[0].Sources[0].Version = "2"
+
+	d, err := New("external-networks/run1", from, "external-networks/run2", to)
+	require.Nil(t, err)
+	require.Equal(t, SchemaVersion, d.SchemaVersion)
+	require.Equal(t, "delta_from_run1", FileName(d.From))
+	require.Equal(t, []*ProviderDelta{
+		{
+			ProviderName: "Amazon",
+			Sources:      to.ProviderNetworks[0].Sources,
+			Removed: []*common.RegionNetworkDetail{
+				{RegionName: "us-east-1", ServiceNetworks: []*common.ServiceIPRanges{
+					{ServiceName: "EC2", IPv4Prefixes: []string{"3.5.140.0/22"}},
+				}},
+				{RegionName: "us-west-1", ServiceNetworks: []*common.ServiceIPRanges{
+					{ServiceName: "S3", IPv6Prefixes: []string{"2600:1f15::/32"}},
+				}},
+			},
+			Added: []*common.RegionNetworkDetail{
+				{RegionName: "eu-west-1", ServiceNetworks: []*common.ServiceIPRanges{
+					{ServiceName: "S3", IPv6Prefixes: []string{"2a05:d07a:a000::/40"}},
+				}},
+				{RegionName: "us-east-1", ServiceNetworks: []*common.ServiceIPRanges{
+					{ServiceName: "EC2", IPv4Prefixes: []string{"52.93.178.234/32"}},
+				}},
+			},
+		},
+		{ProviderName: "Cloudflare", Deleted: true},
+		{ProviderName: "Google", Sources: to.ProviderNetworks[1].Sources},
+		{
+			ProviderName: "Oracle",
+			Sources:      to.ProviderNetworks[2].Sources,
+			Added: []*common.RegionNetworkDetail{
+				{RegionName: "us-phoenix-1", ServiceNetworks: []*common.ServiceIPRanges{
+					{ServiceName: "OSN", IPv4Prefixes: []string{"129.146.0.0/21"}},
+				}},
+			},
+		},
+	}, d.Providers)
+
+	// Consumers apply the delta to the networks they downloaded earlier
+	result, err := Apply(roundTrip(t, from), roundTripDelta(t, d))
+	require.Nil(t, err)
+	expected, _, err := common.MarshalAndChecksum(to)
+	require.Nil(t, err)
+	actual, checksum, err := common.MarshalAndChecksum(result)
+	require.Nil(t, err)
+	require.Equal(t, string(expected), string(actual))
+	require.Equal(t, d.Checksum, checksum)
+}
+
+func TestApplyVerifiesChecksums(t *testing.T) {
+	from := newNetworks(t, entry{"Amazon", "us-east-1", "EC2", "3.5.140.0/22"})
+	to := newNetworks(t, entry{"Amazon", "us-east-1", "EC2", "35.180.0.0/16"})
+	d, err := New("external-networks/run1", from, "external-networks/run2", to)
+	require.Nil(t, err)
+
+	// Wrong base
+	_, err = Apply(newNetworks(t, entry{"Amazon", "us-east-1", "EC2", "52.93.178.234/32"}), d)
+	require.NotNil(t, err)
+
+	manifest := common.NewManifest("external-networks/run2", d.Checksum, time.Now(), "test", to)
+	result, err := ApplyAndVerify(newNetworks(t, entry{"Amazon", "us-east-1", "EC2", "3.5.140.0/22"}), d, manifest)
+	require.Nil(t, err)
+	require.Equal(t, []string{"35.180.0.0/16"}, result.ProviderNetworks[0].RegionNetworks[0].ServiceNetworks[0].IPv4Prefixes)
+
+	manifest.RunFolder = "external-networks/run3"
+	_, err = ApplyAndVerify(from, d, manifest)
+	require.NotNil(t, err)
+
+	manifest.RunFolder = "external-networks/run2"
+	manifest.Checksum = "deadbeef"
+	_, err = ApplyAndVerify(from, d, manifest)
+	require.NotNil(t, err)
+
+	// Tampered delta
+	d.Providers[0].Added[0].ServiceNetworks[0].IPv4Prefixes = []string{"52.93.178.234/32"}
+	_, err = Apply(newNetworks(t, entry{"Amazon", "us-east-1", "EC2", "3.5.140.0/22"}), d)
+	require.NotNil(t, err)
+
+	// Removing a prefix the base does not have
+	d.Providers[0].Removed[0].ServiceNetworks[0].IPv4Prefixes = []string{"52.93.178.234/32"}
+	_, err = applyProvider(from.ProviderNetworks[0], d.Providers[0])
+	require.NotNil(t, err)
+}
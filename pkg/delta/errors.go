@@ -0,0 +1,20 @@
+package delta
+
+import (
+	"fmt"
+)
+
+// UnsupportedSchemaVersionError is returned when applying a delta written with a newer schema version
+func UnsupportedSchemaVersionError(schemaVersion int) error {
+	return fmt.Errorf("unsupported delta schema version %d. Supported version is %d", schemaVersion, SchemaVersion)
+}
+
+// PrefixNotFoundError is returned when a delta removes a prefix that is not in the networks it is applied to
+func PrefixNotFoundError(provider, region, service, prefix string) error {
+	return fmt.Errorf("prefix %s not found in service %s of region %s of provider %s", prefix, service, region, provider)
+}
+
+// RunFolderMismatchError is returned when a delta does not result in the run described by a manifest
+func RunFolderMismatchError(deltaRunFolder, manifestRunFolder string) error {
+	return fmt.Errorf("delta results in run %s, but the manifest describes run %s", deltaRunFolder, manifestRunFolder)
+}
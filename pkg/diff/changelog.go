@@ -0,0 +1,33 @@
+package diff
+
+// ChangelogSchemaVersion is the version of the Changelog format. It is incremented
+// whenever a change to Changelog is not backwards compatible.
+const ChangelogSchemaVersion = 1
+
+// Changelog lists the changes of a networks snapshot compared with a previous one.
+// Every published run contains the changelog relative to the run that was the
+// latest when it was published, so consumers can apply incremental updates and
+// explain why the networks changed.
+type Changelog struct {
+	// SchemaVersion is the ChangelogSchemaVersion the changelog was written with
+	SchemaVersion int `json:"schemaVersion"`
+	// Previous is the snapshot the changes are relative to, e.g. the run folder of
+	// the previous run. Empty if there is none, in which case everything is added.
+	Previous string `json:"previous"`
+	// Current is the snapshot the changes lead to, e.g. the run folder of the run
+	Current string `json:"current"`
+	// Providers summarizes the changes of every provider
+	Providers []*ScopeChange `json:"providers"`
+	*Diff
+}
+
+// NewChangelog returns the changelog of the diff between the previous and the current snapshot
+func NewChangelog(previous, current string, d *Diff) *Changelog {
+	return &Changelog{
+		SchemaVersion: ChangelogSchemaVersion,
+		Previous:      previous,
+		Current:       current,
+		Providers:     d.ProviderChanges(),
+		Diff:          d,
+	}
+}
@@ -0,0 +1,356 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+// Entry is an IP prefix attributed to a service of a region of a provider.
+// The same prefix can be attributed to several services and regions.
+type Entry struct {
+	Provider string `json:"provider"`
+	Region   string `json:"region"`
+	Service  string `json:"service"`
+	Prefix   string `json:"prefix"`
+}
+
+// Location is a service of a region a prefix is attributed to
+type Location struct {
+	Region  string `json:"region"`
+	Service string `json:"service"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s/%s", l.Region, l.Service)
+}
+
+// Move is a prefix published by a provider in both networks, whose regions or
+// services changed
+type Move struct {
+	Provider string `json:"provider"`
+	Prefix   string `json:"prefix"`
+	// From are the locations the prefix is no longer attributed to
+	From []Location `json:"from"`
+	// To are the locations the prefix is newly attributed to
+	To []Location `json:"to"`
+}
+
+// Scope is a provider, a region of a provider or a service of a region. The
+// region and service are empty for the scopes of whole providers and regions.
+type Scope struct {
+	Provider string `json:"provider"`
+	Region   string `json:"region,omitempty"`
+	Service  string `json:"service,omitempty"`
+}
+
+func (s Scope) String() string {
+	switch {
+	case s.Region == "":
+		return fmt.Sprintf("provider %s", s.Provider)
+	case s.Service == "":
+		return fmt.Sprintf("region %s of provider %s", s.Region, s.Provider)
+	default:
+		return fmt.Sprintf("service %s in region %s of provider %s", s.Service, s.Region, s.Provider)
+	}
+}
+
+// ScopeChange is the number of prefixes added to and removed from a scope. Prefixes
+// attributed to several services of a provider are counted once for the provider.
+type ScopeChange struct {
+	Scope
+	Previous int `json:"previous"`
+	Current  int `json:"current"`
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	// Moved is the number of prefixes that moved between the regions or services
+	// of a provider. Only counted for whole providers.
+	Moved int `json:"moved,omitempty"`
+}
+
+// Diff is the difference between two networks snapshots
+type Diff struct {
+	// Added are the entries only in the current networks, whose prefixes were
+	// not published by the provider in the previous networks
+	Added []*Entry `json:"added"`
+	// Removed are the entries only in the previous networks, whose prefixes are
+	// no longer published by the provider in the current networks
+	Removed []*Entry `json:"removed"`
+	// Moved are the prefixes published by a provider in both networks, but
+	// attributed to different regions or services
+	Moved []*Move `json:"moved"`
+
+	previousCounts map[Scope]int
+	currentCounts  map[Scope]int
+}
+
+// Compute returns the entries added and removed, and the prefixes moved between the
+// previous and the current networks. The entries are sorted by provider, region,
+// service and prefix, the moves by provider and prefix.
+func Compute(previous, current *common.ExternalNetworkSources) *Diff {
+	previousEntries := entriesOf(previous)
+	currentEntries := entriesOf(current)
+	d := &Diff{
+		Added:          make([]*Entry, 0),
+		Removed:        make([]*Entry, 0),
+		Moved:          make([]*Move, 0),
+		previousCounts: countScopes(previousEntries),
+		currentCounts:  countScopes(currentEntries),
+	}
+
+	// Attributions of the prefixes that changed, by provider and prefix
+	type providerPrefix struct {
+		provider, prefix string
+	}
+	added := make(map[providerPrefix][]Location)
+	removed := make(map[providerPrefix][]Location)
+	for entry := range currentEntries {
+		if _, ok := previousEntries[entry]; !ok {
+			key := providerPrefix{provider: entry.Provider, prefix: entry.Prefix}
+			added[key] = append(added[key], Location{Region: entry.Region, Service: entry.Service})
+		}
+	}
+	for entry := range previousEntries {
+		if _, ok := currentEntries[entry]; !ok {
+			key := providerPrefix{provider: entry.Provider, prefix: entry.Prefix}
+			removed[key] = append(removed[key], Location{Region: entry.Region, Service: entry.Service})
+		}
+	}
+
+	previousPrefixes := prefixesOf(previousEntries)
+	currentPrefixes := prefixesOf(currentEntries)
+	for key, locations := range added {
+		if previousPrefixes[key.provider][key.prefix] {
+			d.Moved = append(d.Moved, &Move{Provider: key.provider, Prefix: key.prefix, To: locations})
+			continue
+		}
+		for _, location := range locations {
+			d.Added = append(d.Added, &Entry{
+				Provider: key.provider, Region: location.Region, Service: location.Service, Prefix: key.prefix,
+			})
+		}
+	}
+	movedFrom := make(map[providerPrefix][]Location)
+	for key, locations := range removed {
+		if currentPrefixes[key.provider][key.prefix] {
+			movedFrom[key] = locations
+			continue
+		}
+		for _, location := range locations {
+			d.Removed = append(d.Removed, &Entry{
+				Provider: key.provider, Region: location.Region, Service: location.Service, Prefix: key.prefix,
+			})
+		}
+	}
+	for key := range movedFrom {
+		if _, ok := added[key]; !ok {
+			// The prefix only lost some of its locations
+			d.Moved = append(d.Moved, &Move{Provider: key.provider, Prefix: key.prefix})
+		}
+	}
+	for _, move := range d.Moved {
+		move.From = movedFrom[providerPrefix{provider: move.Provider, prefix: move.Prefix}]
+		if move.To == nil {
+			move.To = make([]Location, 0)
+		}
+		if move.From == nil {
+			move.From = make([]Location, 0)
+		}
+		sortLocations(move.From)
+		sortLocations(move.To)
+	}
+
+	sortEntries(d.Added)
+	sortEntries(d.Removed)
+	sort.Slice(d.Moved, func(i, j int) bool {
+		a, b := d.Moved[i], d.Moved[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return common.CompareIPPrefixes(a.Prefix, b.Prefix) < 0
+	})
+	return d
+}
+
+// IsEmpty returns true if nothing changed
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Moved) == 0
+}
+
+// ScopeChanges returns the number of prefixes added and removed for every provider,
+// region and service in either of the networks. The changes are sorted by scope,
+// whole providers and regions first.
+func (d *Diff) ScopeChanges() []*ScopeChange {
+	changes := make(map[Scope]*ScopeChange)
+	getChange := func(scope Scope) *ScopeChange {
+		change, ok := changes[scope]
+		if !ok {
+			change = &ScopeChange{
+				Scope:    scope,
+				Previous: d.previousCounts[scope],
+				Current:  d.currentCounts[scope],
+			}
+			changes[scope] = change
+		}
+		return change
+	}
+	for scope := range d.previousCounts {
+		getChange(scope)
+	}
+	for scope := range d.currentCounts {
+		getChange(scope)
+	}
+	for _, entry := range d.Added {
+		location := Location{Region: entry.Region, Service: entry.Service}
+		for _, scope := range locationScopesOf(entry.Provider, location) {
+			getChange(scope).Added++
+		}
+	}
+	for _, entry := range d.Removed {
+		location := Location{Region: entry.Region, Service: entry.Service}
+		for _, scope := range locationScopesOf(entry.Provider, location) {
+			getChange(scope).Removed++
+		}
+	}
+	for provider, prefixes := range prefixesOf(entrySet(d.Added)) {
+		getChange(Scope{Provider: provider}).Added = len(prefixes)
+	}
+	for provider, prefixes := range prefixesOf(entrySet(d.Removed)) {
+		getChange(Scope{Provider: provider}).Removed = len(prefixes)
+	}
+	// Moves only change the counts of regions and services, since the provider
+	// publishes the prefix in both networks
+	for _, move := range d.Moved {
+		getChange(Scope{Provider: move.Provider}).Moved++
+		for _, location := range move.To {
+			for _, scope := range locationScopesOf(move.Provider, location) {
+				getChange(scope).Added++
+			}
+		}
+		for _, location := range move.From {
+			for _, scope := range locationScopesOf(move.Provider, location) {
+				getChange(scope).Removed++
+			}
+		}
+	}
+
+	result := make([]*ScopeChange, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, change)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return lessScope(result[i].Scope, result[j].Scope)
+	})
+	return result
+}
+
+// ProviderChanges returns the changes of whole providers, sorted by provider
+func (d *Diff) ProviderChanges() []*ScopeChange {
+	result := make([]*ScopeChange, 0)
+	for _, change := range d.ScopeChanges() {
+		if change.Region == "" {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+func entriesOf(networks *common.ExternalNetworkSources) map[Entry]struct{} {
+	entries := make(map[Entry]struct{})
+	if networks == nil {
+		return entries
+	}
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+					for _, prefix := range prefixes {
+						entries[Entry{
+							Provider: provider.ProviderName,
+							Region:   region.RegionName,
+							Service:  service.ServiceName,
+							Prefix:   prefix,
+						}] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	return entries
+}
+
+func entrySet(entries []*Entry) map[Entry]struct{} {
+	set := make(map[Entry]struct{}, len(entries))
+	for _, entry := range entries {
+		set[*entry] = struct{}{}
+	}
+	return set
+}
+
+// locationScopesOf returns the region and service scopes of the location
+func locationScopesOf(provider string, location Location) []Scope {
+	return []Scope{
+		{Provider: provider, Region: location.Region},
+		{Provider: provider, Region: location.Region, Service: location.Service},
+	}
+}
+
+// prefixesOf returns the set of prefixes of every provider
+func prefixesOf(entries map[Entry]struct{}) map[string]map[string]bool {
+	prefixes := make(map[string]map[string]bool)
+	for entry := range entries {
+		if prefixes[entry.Provider] == nil {
+			prefixes[entry.Provider] = make(map[string]bool)
+		}
+		prefixes[entry.Provider][entry.Prefix] = true
+	}
+	return prefixes
+}
+
+// countScopes returns the number of entries of every region and service, and the
+// number of distinct prefixes of every provider
+func countScopes(entries map[Entry]struct{}) map[Scope]int {
+	counts := make(map[Scope]int)
+	for entry := range entries {
+		location := Location{Region: entry.Region, Service: entry.Service}
+		for _, scope := range locationScopesOf(entry.Provider, location) {
+			counts[scope]++
+		}
+	}
+	for provider, prefixes := range prefixesOf(entries) {
+		counts[Scope{Provider: provider}] = len(prefixes)
+	}
+	return counts
+}
+
+func lessScope(a, b Scope) bool {
+	if a.Provider != b.Provider {
+		return a.Provider < b.Provider
+	}
+	if a.Region != b.Region {
+		return a.Region < b.Region
+	}
+	return a.Service < b.Service
+}
+
+func sortEntries(entries []*Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		scopeA := Scope{Provider: a.Provider, Region: a.Region, Service: a.Service}
+		scopeB := Scope{Provider: b.Provider, Region: b.Region, Service: b.Service}
+		if scopeA != scopeB {
+			return lessScope(scopeA, scopeB)
+		}
+		return common.CompareIPPrefixes(a.Prefix, b.Prefix) < 0
+	})
+}
+
+func sortLocations(locations []Location) {
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].Region != locations[j].Region {
+			return locations[i].Region < locations[j].Region
+		}
+		return locations[i].Service < locations[j].Service
+	})
+}
@@ -0,0 +1,127 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newNetworks(t *testing.T, entries ...Entry) *common.ExternalNetworkSources {
+	providers := make(map[string]*common.ProviderNetworkRanges)
+	networks := &common.ExternalNetworkSources{}
+	for _, entry := range entries {
+		provider, ok := providers[entry.Provider]
+		if !ok {
+			provider = common.NewProviderNetworkRanges(entry.Provider)
+			providers[entry.Provider] = provider
+			networks.ProviderNetworks = append(networks.ProviderNetworks, provider)
+		}
+		err := provider.AddIPPrefix(entry.Region, entry.Service, entry.Prefix, common.GetDefaultRegionServicePairRedundancyCheck())
+		require.Nil(t, err)
+	}
+	return networks
+}
+
+func TestCompute(t *testing.T) {
+	previous := newNetworks(t,
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "3.5.140.0/22"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "35.180.0.0/16"},
+		Entry{Provider: "Amazon", Region: "us-west-1", Service: "S3", Prefix: "2600:1f15::/32"},
+		Entry{Provider: "Amazon", Region: "us-west-1", Service: "S3", Prefix: "3.5.140.0/22"},
+		Entry{Provider: "Google", Region: "asia-east1", Service: "Google Cloud", Prefix: "34.80.0.0/15"},
+	)
+	current := newNetworks(t,
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "35.180.0.0/16"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "52.93.178.234/32"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "3.4.0.0/22"},
+		Entry{Provider: "Amazon", Region: "us-west-1", Service: "S3", Prefix: "2600:1f15::/32"},
+		Entry{Provider: "Google", Region: "asia-east1", Service: "Google Cloud", Prefix: "34.80.0.0/15"},
+	)
+
+	d := Compute(previous, current)
+	require.False(t, d.IsEmpty())
+	require.Equal(t, []*Entry{
+		{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "3.4.0.0/22"},
+		{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "52.93.178.234/32"},
+	}, d.Added)
+	// Prefixes are counted once for the provider, even if removed from several services
+	require.Equal(t, []*Entry{
+		{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "3.5.140.0/22"},
+		{Provider: "Amazon", Region: "us-west-1", Service: "S3", Prefix: "3.5.140.0/22"},
+	}, d.Removed)
+	require.Empty(t, d.Moved)
+
+	require.Equal(t, []*ScopeChange{
+		{Scope: Scope{Provider: "Amazon"}, Previous: 3, Current: 4, Added: 2, Removed: 1},
+		{Scope: Scope{Provider: "Amazon", Region: "us-east-1"}, Previous: 2, Current: 3, Added: 2, Removed: 1},
+		{Scope: Scope{Provider: "Amazon", Region: "us-east-1", Service: "EC2"}, Previous: 2, Current: 3, Added: 2, Removed: 1},
+		{Scope: Scope{Provider: "Amazon", Region: "us-west-1"}, Previous: 2, Current: 1, Removed: 1},
+		{Scope: Scope{Provider: "Amazon", Region: "us-west-1", Service: "S3"}, Previous: 2, Current: 1, Removed: 1},
+		{Scope: Scope{Provider: "Google"}, Previous: 1, Current: 1},
+		{Scope: Scope{Provider: "Google", Region: "asia-east1"}, Previous: 1, Current: 1},
+		{Scope: Scope{Provider: "Google", Region: "asia-east1", Service: "Google Cloud"}, Previous: 1, Current: 1},
+	}, d.ScopeChanges())
+
+	require.Equal(t, []*ScopeChange{
+		{Scope: Scope{Provider: "Amazon"}, Previous: 3, Current: 4, Added: 2, Removed: 1},
+		{Scope: Scope{Provider: "Google"}, Previous: 1, Current: 1},
+	}, d.ProviderChanges())
+
+	require.True(t, Compute(current, current).IsEmpty())
+
+	// Everything is added when there is no previous networks
+	d = Compute(nil, current)
+	require.Len(t, d.Added, 5)
+	require.Empty(t, d.Removed)
+}
+
+func TestComputeMoves(t *testing.T) {
+	previous := newNetworks(t,
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "3.5.140.0/22"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "S3", Prefix: "35.180.0.0/16"},
+		Entry{Provider: "Google", Region: "asia-east1", Service: "Google Cloud", Prefix: "34.80.0.0/15"},
+	)
+	current := newNetworks(t,
+		Entry{Provider: "Amazon", Region: "us-west-1", Service: "EC2", Prefix: "3.5.140.0/22"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "S3", Prefix: "35.180.0.0/16"},
+		Entry{Provider: "Amazon", Region: "us-east-1", Service: "EC2", Prefix: "35.180.0.0/16"},
+		// The same prefix published by another provider is not a move
+		Entry{Provider: "Oracle", Region: "asia-east1", Service: "OCI", Prefix: "34.80.0.0/15"},
+	)
+
+	d := Compute(previous, current)
+	require.Equal(t, []*Entry{
+		{Provider: "Oracle", Region: "asia-east1", Service: "OCI", Prefix: "34.80.0.0/15"},
+	}, d.Added)
+	require.Equal(t, []*Entry{
+		{Provider: "Google", Region: "asia-east1", Service: "Google Cloud", Prefix: "34.80.0.0/15"},
+	}, d.Removed)
+	require.Equal(t, []*Move{
+		{
+			Provider: "Amazon",
+			Prefix:   "3.5.140.0/22",
+			From:     []Location{{Region: "us-east-1", Service: "EC2"}},
+			To:       []Location{{Region: "us-west-1", Service: "EC2"}},
+		},
+		{
+			Provider: "Amazon",
+			Prefix:   "35.180.0.0/16",
+			From:     []Location{},
+			To:       []Location{{Region: "us-east-1", Service: "EC2"}},
+		},
+	}, d.Moved)
+
+	changes := make(map[Scope]*ScopeChange)
+	for _, change := range d.ScopeChanges() {
+		changes[change.Scope] = change
+	}
+	// The provider still publishes the same prefixes
+	require.Equal(t, &ScopeChange{Scope: Scope{Provider: "Amazon"}, Previous: 2, Current: 2, Moved: 2}, changes[Scope{Provider: "Amazon"}])
+	require.Equal(t,
+		&ScopeChange{Scope: Scope{Provider: "Amazon", Region: "us-east-1"}, Previous: 2, Current: 2, Added: 1, Removed: 1},
+		changes[Scope{Provider: "Amazon", Region: "us-east-1"}])
+	require.Equal(t,
+		&ScopeChange{Scope: Scope{Provider: "Amazon", Region: "us-west-1"}, Previous: 0, Current: 1, Added: 1},
+		changes[Scope{Provider: "Amazon", Region: "us-west-1"}])
+}
@@ -0,0 +1,110 @@
+package netindex
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+// benchNetworksEnv names a networks file to run the benchmarks on, e.g. the networks of a
+// published run. Random networks of the size of a full crawl are used by default.
+const benchNetworksEnv = "NETINDEX_BENCH_NETWORKS"
+
+// Number of prefixes of a full crawl of all providers, most of them from Azure
+const (
+	crawlNumIPv4Prefixes = 40000
+	crawlNumIPv6Prefixes = 15000
+	crawlNumBlocks       = 128
+)
+
+func benchNetworks(b *testing.B) *common.ExternalNetworkSources {
+	path := os.Getenv(benchNetworksEnv)
+	if path == "" {
+		return randomNetworks(b, rand.New(rand.NewSource(1)), crawlNumIPv4Prefixes, crawlNumIPv6Prefixes, crawlNumBlocks)
+	}
+	data, err := os.ReadFile(path)
+	require.Nil(b, err)
+	var networks common.ExternalNetworkSources
+	require.Nil(b, json.Unmarshal(data, &networks))
+	return &networks
+}
+
+// benchQueries returns single addresses, three quarters of them inside published prefixes
+func benchQueries(b *testing.B, index *Index, networks *common.ExternalNetworkSources, ipv4 bool) []netip.Addr {
+	var all []Match
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				prefixes := service.IPv6Prefixes
+				if ipv4 {
+					prefixes = service.IPv4Prefixes
+				}
+				for _, prefix := range prefixes {
+					all = append(all, Match{Prefix: netip.MustParsePrefix(prefix)})
+				}
+			}
+		}
+	}
+	require.NotEmpty(b, all)
+	r := rand.New(rand.NewSource(2))
+	queries := make([]netip.Addr, 0, 1024)
+	for len(queries) < cap(queries) {
+		query := randomQuery(r, all)
+		if query.IsSingleIP() {
+			queries = append(queries, query.Addr())
+		}
+	}
+	b.Logf("%d prefixes indexed", index.Len())
+	return queries
+}
+
+func BenchmarkNew(b *testing.B) {
+	networks := benchNetworks(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := New(networks)
+		require.Nil(b, err)
+	}
+}
+
+func benchmarkLookup(b *testing.B, ipv4 bool) {
+	networks := benchNetworks(b)
+	index, err := New(networks)
+	require.Nil(b, err)
+	queries := benchQueries(b, index, networks, ipv4)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Lookup(queries[i%len(queries)])
+	}
+}
+
+func BenchmarkLookupIPv4(b *testing.B) {
+	benchmarkLookup(b, true)
+}
+
+func BenchmarkLookupIPv6(b *testing.B) {
+	benchmarkLookup(b, false)
+}
+
+func BenchmarkCoveringIPv4(b *testing.B) {
+	networks := benchNetworks(b)
+	index, err := New(networks)
+	require.Nil(b, err)
+	queries := benchQueries(b, index, networks, true)
+	prefixes := make([]netip.Prefix, 0, len(queries))
+	for _, query := range queries {
+		prefixes = append(prefixes, netip.PrefixFrom(query, query.BitLen()))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Covering(prefixes[i%len(prefixes)])
+	}
+}
@@ -0,0 +1,280 @@
+// Package netindex indexes the prefixes of ExternalNetworkSources to find the providers,
+// regions and services IP addresses belong to.
+package netindex
+
+import (
+	"math/bits"
+	"net/netip"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+)
+
+// Match is a published prefix of a service of a region of a provider
+type Match struct {
+	Prefix   netip.Prefix `json:"prefix"`
+	Provider string       `json:"provider"`
+	Region   string       `json:"region"`
+	Service  string       `json:"service"`
+}
+
+// Less reports whether the match sorts before the other match by provider, region and
+// service. Prefixes are not compared.
+func (m Match) Less(other Match) bool {
+	if m.Provider != other.Provider {
+		return m.Provider < other.Provider
+	}
+	if m.Region != other.Region {
+		return m.Region < other.Region
+	}
+	return m.Service < other.Service
+}
+
+// Index is an immutable radix tree of the IPv4 and IPv6 prefixes of networks. It is
+// safe for concurrent use.
+type Index struct {
+	ipv4        *node
+	ipv6        *node
+	numPrefixes int
+}
+
+// node is a node of a path compressed binary trie. Nodes only exist for published
+// prefixes and where the paths to published prefixes fork.
+type node struct {
+	key      key
+	bits     int
+	children [2]*node
+	// matches are the matches of the prefix of the node, if it is published
+	matches []Match
+}
+
+// key holds the bits of an address, most significant first. IPv4 addresses only use
+// the 32 most significant bits.
+type key struct {
+	hi, lo uint64
+}
+
+// New returns the index of all the prefixes of the networks. IPv4-mapped IPv6 prefixes
+// are indexed as IPv4 prefixes.
+func New(networks *common.ExternalNetworkSources) (*Index, error) {
+	index := &Index{ipv4: &node{}, ipv6: &node{}}
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+					for _, prefix := range prefixes {
+						parsed, err := netip.ParsePrefix(prefix)
+						if err != nil {
+							return nil, errors.Wrapf(err, "invalid prefix of service %s in region %s of provider %s",
+								service.ServiceName, region.RegionName, provider.ProviderName)
+						}
+						parsed = unmapPrefix(parsed).Masked()
+						index.insert(parsed, Match{
+							Prefix:   parsed,
+							Provider: provider.ProviderName,
+							Region:   region.RegionName,
+							Service:  service.ServiceName,
+						})
+					}
+				}
+			}
+		}
+	}
+	index.ipv4.finish()
+	index.ipv6.finish()
+	return index, nil
+}
+
+// Len returns the number of distinct prefixes in the index
+func (i *Index) Len() int {
+	return i.numPrefixes
+}
+
+// Lookup returns the matches of the longest prefix containing the address, sorted by
+// provider, region and service. The returned slice is shared and must not be modified.
+func (i *Index) Lookup(addr netip.Addr) []Match {
+	if !addr.IsValid() {
+		return nil
+	}
+	addr = addr.Unmap()
+	return i.longest(i.root(addr), keyOf(addr), addr.BitLen())
+}
+
+// LookupPrefix returns the matches of the longest prefix containing all the addresses
+// of the prefix, sorted by provider, region and service. The returned slice is shared
+// and must not be modified.
+func (i *Index) LookupPrefix(prefix netip.Prefix) []Match {
+	if !prefix.IsValid() {
+		return nil
+	}
+	prefix = unmapPrefix(prefix)
+	return i.longest(i.root(prefix.Addr()), keyOf(prefix.Addr()), prefix.Bits())
+}
+
+// Covering returns the matches of all the prefixes containing all the addresses of the
+// prefix, longest prefix first. Matches of the same prefix are sorted by provider,
+// region and service.
+func (i *Index) Covering(prefix netip.Prefix) []Match {
+	if !prefix.IsValid() {
+		return nil
+	}
+	prefix = unmapPrefix(prefix)
+	var levels [][]Match
+	numMatches := 0
+	i.walk(i.root(prefix.Addr()), keyOf(prefix.Addr()), prefix.Bits(), func(n *node) {
+		levels = append(levels, n.matches)
+		numMatches += len(n.matches)
+	})
+	result := make([]Match, 0, numMatches)
+	for l := len(levels) - 1; l >= 0; l-- {
+		result = append(result, levels[l]...)
+	}
+	return result
+}
+
+func (i *Index) root(addr netip.Addr) *node {
+	if addr.Is4() {
+		return i.ipv4
+	}
+	return i.ipv6
+}
+
+func (i *Index) longest(n *node, k key, numBits int) []Match {
+	// Same as walk, without the cost of calling a function for every published prefix
+	var result []Match
+	for n != nil && n.bits <= numBits && commonPrefixLen(n.key, k) >= n.bits {
+		if n.matches != nil {
+			result = n.matches
+		}
+		if n.bits == numBits {
+			break
+		}
+		n = n.children[k.bit(n.bits)]
+	}
+	return result
+}
+
+// walk calls fn for the nodes of all the published prefixes containing the first
+// numBits of k, shortest prefix first
+func (i *Index) walk(n *node, k key, numBits int, fn func(*node)) {
+	for n != nil && n.bits <= numBits && commonPrefixLen(n.key, k) >= n.bits {
+		if n.matches != nil {
+			fn(n)
+		}
+		if n.bits == numBits {
+			return
+		}
+		n = n.children[k.bit(n.bits)]
+	}
+}
+
+func (i *Index) insert(prefix netip.Prefix, match Match) {
+	k, numBits := keyOf(prefix.Addr()), prefix.Bits()
+	n := i.root(prefix.Addr())
+	for {
+		if n.bits == numBits {
+			if n.matches == nil {
+				i.numPrefixes++
+			}
+			n.matches = append(n.matches, match)
+			return
+		}
+		b := k.bit(n.bits)
+		child := n.children[b]
+		if child == nil {
+			n.children[b] = &node{key: k.truncate(numBits), bits: numBits, matches: []Match{match}}
+			i.numPrefixes++
+			return
+		}
+		shared := commonPrefixLen(child.key, k)
+		if shared >= child.bits && numBits >= child.bits {
+			n = child
+			continue
+		}
+		// The prefix forks from the path to the child, or is a parent of the child
+		if shared > numBits {
+			shared = numBits
+		}
+		split := &node{key: k.truncate(shared), bits: shared}
+		split.children[child.key.bit(shared)] = child
+		n.children[b] = split
+		n = split
+	}
+}
+
+// finish sorts and deduplicates the matches of the node and all its descendants
+func (n *node) finish() {
+	if n == nil {
+		return
+	}
+	if len(n.matches) > 0 {
+		sort.Slice(n.matches, func(a, b int) bool {
+			return n.matches[a].Less(n.matches[b])
+		})
+		deduplicated := n.matches[:1]
+		for _, match := range n.matches[1:] {
+			if match != deduplicated[len(deduplicated)-1] {
+				deduplicated = append(deduplicated, match)
+			}
+		}
+		n.matches = deduplicated[:len(deduplicated):len(deduplicated)]
+	}
+	n.children[0].finish()
+	n.children[1].finish()
+}
+
+// unmapPrefix returns the IPv4 prefix of an IPv4-mapped IPv6 prefix, so that both
+// forms of an IPv4 address match the same prefixes
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix
+}
+
+func keyOf(addr netip.Addr) key {
+	if addr.Is4() {
+		a := addr.As4()
+		return key{hi: uint64(a[0])<<56 | uint64(a[1])<<48 | uint64(a[2])<<40 | uint64(a[3])<<32}
+	}
+	a := addr.As16()
+	var k key
+	for i := 0; i < 8; i++ {
+		k.hi = k.hi<<8 | uint64(a[i])
+		k.lo = k.lo<<8 | uint64(a[i+8])
+	}
+	return k
+}
+
+// bit returns the i-th most significant bit of the key
+func (k key) bit(i int) int {
+	if i < 64 {
+		return int(k.hi>>(63-i)) & 1
+	}
+	return int(k.lo>>(127-i)) & 1
+}
+
+// truncate returns the key with all but the numBits most significant bits cleared
+func (k key) truncate(numBits int) key {
+	switch {
+	case numBits == 0:
+		return key{}
+	case numBits < 64:
+		return key{hi: k.hi &^ (^uint64(0) >> numBits)}
+	case numBits == 64:
+		return key{hi: k.hi}
+	case numBits < 128:
+		return key{hi: k.hi, lo: k.lo &^ (^uint64(0) >> (numBits - 64))}
+	default:
+		return k
+	}
+}
+
+// commonPrefixLen returns the number of most significant bits a and b have in common
+func commonPrefixLen(a, b key) int {
+	if x := a.hi ^ b.hi; x != 0 {
+		return bits.LeadingZeros64(x)
+	}
+	return 64 + bits.LeadingZeros64(a.lo^b.lo)
+}
@@ -0,0 +1,203 @@
+package netindex
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"sort"
+	"testing"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newNetworks(t testing.TB, providers ...*common.ProviderNetworkRanges) *common.ExternalNetworkSources {
+	return &common.ExternalNetworkSources{ProviderNetworks: providers}
+}
+
+func addPrefix(t testing.TB, provider *common.ProviderNetworkRanges, region, service, prefix string) {
+	require.Nil(t, provider.AddIPPrefix(region, service, prefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+}
+
+func match(prefix, provider, region, service string) Match {
+	return Match{Prefix: netip.MustParsePrefix(prefix), Provider: provider, Region: region, Service: service}
+}
+
+func TestIndex(t *testing.T) {
+	google := common.NewProviderNetworkRanges("Google")
+	addPrefix(t, google, "global", "Google Cloud", "34.64.0.0/10")
+	addPrefix(t, google, "asia-east1", "Google Cloud", "34.80.0.0/15")
+	addPrefix(t, google, "us-central1", "Google Cloud", "2600:1900:4000::/44")
+	addPrefix(t, google, "global", "Google Cloud", "0.0.0.0/0")
+	amazon := common.NewProviderNetworkRanges("Amazon")
+	addPrefix(t, amazon, "us-east-1", "EC2", "34.80.0.0/16")
+	addPrefix(t, amazon, "us-east-1", "AMAZON", "34.80.0.0/16")
+	addPrefix(t, amazon, "us-west-1", "S3", "2600:1f15::/32")
+	addPrefix(t, amazon, "us-west-1", "S3", "34.80.0.1/32")
+	index, err := New(newNetworks(t, google, amazon))
+	require.Nil(t, err)
+	require.Equal(t, 7, index.Len())
+
+	require.Equal(t, []Match{
+		match("34.80.0.0/16", "Amazon", "us-east-1", "AMAZON"),
+		match("34.80.0.0/16", "Amazon", "us-east-1", "EC2"),
+	}, index.Lookup(netip.MustParseAddr("34.80.1.2")))
+	require.Equal(t, []Match{
+		match("34.80.0.1/32", "Amazon", "us-west-1", "S3"),
+	}, index.Lookup(netip.MustParseAddr("34.80.0.1")))
+	require.Equal(t, []Match{
+		match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+	}, index.Lookup(netip.MustParseAddr("::ffff:34.120.5.9")))
+	require.Equal(t, []Match{
+		match("0.0.0.0/0", "Google", "global", "Google Cloud"),
+	}, index.Lookup(netip.MustParseAddr("8.8.8.8")))
+	require.Equal(t, []Match{
+		match("2600:1900:4000::/44", "Google", "us-central1", "Google Cloud"),
+	}, index.Lookup(netip.MustParseAddr("2600:1900:4001::1")))
+	require.Empty(t, index.Lookup(netip.MustParseAddr("2001:db8::1")))
+	require.Empty(t, index.Lookup(netip.Addr{}))
+
+	require.Equal(t, []Match{
+		match("34.80.0.0/15", "Google", "asia-east1", "Google Cloud"),
+	}, index.LookupPrefix(netip.MustParsePrefix("34.80.0.0/15")))
+	require.Equal(t, []Match{
+		match("34.80.0.0/15", "Google", "asia-east1", "Google Cloud"),
+		match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+		match("0.0.0.0/0", "Google", "global", "Google Cloud"),
+	}, index.Covering(netip.MustParsePrefix("34.80.0.0/15")))
+	require.Equal(t, []Match{
+		match("34.80.0.1/32", "Amazon", "us-west-1", "S3"),
+		match("34.80.0.0/16", "Amazon", "us-east-1", "AMAZON"),
+		match("34.80.0.0/16", "Amazon", "us-east-1", "EC2"),
+		match("34.80.0.0/15", "Google", "asia-east1", "Google Cloud"),
+		match("34.64.0.0/10", "Google", "global", "Google Cloud"),
+		match("0.0.0.0/0", "Google", "global", "Google Cloud"),
+	}, index.Covering(netip.MustParsePrefix("34.80.0.1/32")))
+	require.Empty(t, index.Covering(netip.MustParsePrefix("2600::/16")))
+
+	invalid := common.NewProviderNetworkRanges("Invalid")
+	invalid.RegionNetworks = []*common.RegionNetworkDetail{{
+		RegionName:      "region",
+		ServiceNetworks: []*common.ServiceIPRanges{{ServiceName: "service", IPv4Prefixes: []string{"34.80.0.0/33"}}},
+	}}
+	_, err = New(newNetworks(t, invalid))
+	require.Error(t, err)
+}
+
+// TestIndexMatchesLinearScan compares the index with checking every prefix on random networks
+func TestIndexMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	networks := randomNetworks(t, r, 2000, 500, 4)
+	index, err := New(networks)
+	require.Nil(t, err)
+
+	var all []Match
+	for _, provider := range networks.ProviderNetworks {
+		for _, region := range provider.RegionNetworks {
+			for _, service := range region.ServiceNetworks {
+				for _, prefix := range append(append([]string{}, service.IPv4Prefixes...), service.IPv6Prefixes...) {
+					all = append(all, match(prefix, provider.ProviderName, region.RegionName, service.ServiceName))
+				}
+			}
+		}
+	}
+	covering := func(query netip.Prefix) []Match {
+		var result []Match
+		for _, m := range all {
+			if m.Prefix.Bits() <= query.Bits() && m.Prefix.Contains(query.Addr()) {
+				result = append(result, m)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].Prefix.Bits() != result[j].Prefix.Bits() {
+				return result[i].Prefix.Bits() > result[j].Prefix.Bits()
+			}
+			return result[i].Less(result[j])
+		})
+		return result
+	}
+
+	for i := 0; i < 5000; i++ {
+		query := randomQuery(r, all)
+		expected := covering(query)
+		actual := index.Covering(query)
+		if len(expected) == 0 {
+			require.Empty(t, actual, query)
+			require.Empty(t, index.LookupPrefix(query), query)
+			continue
+		}
+		require.Equal(t, expected, actual, query)
+		longest := expected[:0:0]
+		for _, m := range expected {
+			if m.Prefix == expected[0].Prefix {
+				longest = append(longest, m)
+			}
+		}
+		require.Equal(t, longest, index.LookupPrefix(query), query)
+		if query.IsSingleIP() {
+			require.Equal(t, longest, index.Lookup(query.Addr()), query)
+		}
+	}
+}
+
+// randomNetworks returns networks of 5 providers, with the given numbers of random
+// IPv4 and IPv6 prefixes. Prefixes are concentrated in numBlocks blocks of addresses
+// with the same most significant byte, so the fewer blocks the more prefixes nest.
+func randomNetworks(t testing.TB, r *rand.Rand, numIPv4, numIPv6, numBlocks int) *common.ExternalNetworkSources {
+	providers := make([]*common.ProviderNetworkRanges, 0, 5)
+	for i := 0; i < 5; i++ {
+		providers = append(providers, common.NewProviderNetworkRanges(fmt.Sprintf("provider%d", i)))
+	}
+	add := func(prefix netip.Prefix) {
+		provider := providers[r.Intn(len(providers))]
+		region := fmt.Sprintf("region%d", r.Intn(30))
+		service := fmt.Sprintf("service%d", r.Intn(5))
+		addPrefix(t, provider, region, service, prefix.Masked().String())
+	}
+	randomPrefix := func(bitLen, minBits, maxBits int) netip.Prefix {
+		var a [16]byte
+		r.Read(a[:])
+		addr := netip.AddrFrom16(a)
+		if bitLen == 32 {
+			addr = netip.AddrFrom4([4]byte{a[0], a[1], a[2], a[3]})
+		}
+		bytes := addr.AsSlice()
+		bytes[0] = byte(r.Intn(numBlocks)) + 32
+		addr, _ = netip.AddrFromSlice(bytes)
+		return netip.PrefixFrom(addr, minBits+r.Intn(maxBits-minBits+1))
+	}
+	for i := 0; i < numIPv4; i++ {
+		add(randomPrefix(32, 12, 32))
+	}
+	for i := 0; i < numIPv6; i++ {
+		add(randomPrefix(128, 16, 64))
+	}
+	return newNetworks(t, providers...)
+}
+
+// randomQuery returns either a random prefix, or a random prefix inside a published one
+func randomQuery(r *rand.Rand, all []Match) netip.Prefix {
+	var a [16]byte
+	r.Read(a[:])
+	base := all[r.Intn(len(all))].Prefix
+	var addr netip.Addr
+	if base.Addr().Is4() {
+		addr = netip.AddrFrom4([4]byte{a[0], a[1], a[2], a[3]})
+	} else {
+		addr = netip.AddrFrom16(a)
+	}
+	if r.Intn(4) > 0 {
+		// Keep the bits of the published prefix
+		baseBytes, bytes := base.Addr().AsSlice(), addr.AsSlice()
+		for i := 0; i < base.Bits(); i++ {
+			mask := byte(0x80) >> (i % 8)
+			bytes[i/8] = bytes[i/8]&^mask | baseBytes[i/8]&mask
+		}
+		addr, _ = netip.AddrFromSlice(bytes)
+	}
+	numBits := addr.BitLen()
+	if r.Intn(2) == 0 {
+		numBits = r.Intn(addr.BitLen() + 1)
+	}
+	return netip.PrefixFrom(addr, numBits).Masked()
+}
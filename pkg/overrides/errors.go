@@ -0,0 +1,10 @@
+package overrides
+
+import (
+	"fmt"
+)
+
+// InvalidRuleError is returned when a rule of an overrides file is incomplete or malformed
+func InvalidRuleError(ruleName, reason string) error {
+	return fmt.Errorf("%s is invalid: %s", ruleName, reason)
+}
@@ -0,0 +1,444 @@
+package overrides
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"gopkg.in/yaml.v3"
+)
+
+// expiresLayout is the layout of the expiry dates of the rules
+const expiresLayout = "2006-01-02"
+
+// File is an overrides file. It lets operators correct the crawled networks without
+// changing the crawlers, for example when an upstream feed is wrong, or to publish
+// ranges that no crawler knows about. It is written in YAML, or in JSON which is a
+// subset of YAML.
+//
+// Excludes are applied first, then renames, then injects. Excludes and renames refer
+// to the crawled names of the regions and services.
+type File struct {
+	// Exclude removes crawled prefixes or whole services
+	Exclude []*ExcludeRule `yaml:"exclude"`
+	// Rename renames crawled regions or services
+	Rename []*RenameRule `yaml:"rename"`
+	// Inject adds prefixes, to a crawled provider or to a provider of its own
+	Inject []*InjectRule `yaml:"inject"`
+}
+
+// Rule contains what every rule carries
+type Rule struct {
+	// Reason explains why the rule exists. It is logged whenever the rule is applied.
+	Reason string `yaml:"reason"`
+	// Expires is the last day the rule is applied on, as YYYY-MM-DD in UTC. Expired
+	// rules are skipped with a warning, so that workarounds do not outlive their cause.
+	Expires string `yaml:"expires"`
+
+	expires time.Time
+}
+
+// ExcludeRule removes the crawled prefixes contained in Prefixes. Without Prefixes,
+// whole services are removed. Empty Region and Service match any region and service
+// of the provider.
+type ExcludeRule struct {
+	Rule     `yaml:",inline"`
+	Provider string   `yaml:"provider"`
+	Region   string   `yaml:"region"`
+	Service  string   `yaml:"service"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// RenameRule moves the matching services to NewRegion and NewService. Empty Region
+// and Service match any region and service of the provider, and empty NewRegion and
+// NewService keep the current names. Services renamed to an existing service are
+// merged into it.
+type RenameRule struct {
+	Rule       `yaml:",inline"`
+	Provider   string `yaml:"provider"`
+	Region     string `yaml:"region"`
+	Service    string `yaml:"service"`
+	NewRegion  string `yaml:"newRegion"`
+	NewService string `yaml:"newService"`
+}
+
+// InjectRule adds Prefixes to the service of the region of the provider. The provider
+// is added if no crawler published it.
+type InjectRule struct {
+	Rule     `yaml:",inline"`
+	Provider string   `yaml:"provider"`
+	Region   string   `yaml:"region"`
+	Service  string   `yaml:"service"`
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// Load reads and validates the overrides file at the path
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read overrides file %s", path)
+	}
+	file, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid overrides file %s", path)
+	}
+	return file, nil
+}
+
+// Parse parses and validates overrides written in YAML or JSON. Unknown fields are
+// rejected, so that a misspelled field does not silently widen a rule.
+func Parse(data []byte) (*File, error) {
+	file := &File{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(file); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to parse overrides")
+	}
+	if err := file.validate(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *File) validate() error {
+	for i, rule := range f.Exclude {
+		name := ruleName("exclude", i)
+		if err := rule.Rule.validate(name, rule.Provider); err != nil {
+			return err
+		}
+		if rule.Service == "" && len(rule.Prefixes) == 0 {
+			return InvalidRuleError(name, "either a service or prefixes are required")
+		}
+		if err := validatePrefixes(name, rule.Prefixes); err != nil {
+			return err
+		}
+	}
+	for i, rule := range f.Rename {
+		name := ruleName("rename", i)
+		if err := rule.Rule.validate(name, rule.Provider); err != nil {
+			return err
+		}
+		if rule.NewRegion == "" && rule.NewService == "" {
+			return InvalidRuleError(name, "either a new region or a new service is required")
+		}
+	}
+	for i, rule := range f.Inject {
+		name := ruleName("inject", i)
+		if err := rule.Rule.validate(name, rule.Provider); err != nil {
+			return err
+		}
+		if rule.Region == "" || rule.Service == "" {
+			return InvalidRuleError(name, "a region and a service are required")
+		}
+		if len(rule.Prefixes) == 0 {
+			return InvalidRuleError(name, "prefixes are required")
+		}
+		if err := validatePrefixes(name, rule.Prefixes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate(name, provider string) error {
+	if provider == "" {
+		return InvalidRuleError(name, "a provider is required")
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		return InvalidRuleError(name, "a reason is required")
+	}
+	if r.Expires == "" {
+		return InvalidRuleError(name, "an expiry date is required")
+	}
+	expires, err := time.Parse(expiresLayout, r.Expires)
+	if err != nil {
+		return InvalidRuleError(name, fmt.Sprintf("expiry date %q is not formatted as YYYY-MM-DD", r.Expires))
+	}
+	r.expires = expires
+	return nil
+}
+
+func validatePrefixes(name string, prefixes []string) error {
+	for _, prefix := range prefixes {
+		if _, err := common.CanonicalIPPrefix(prefix); err != nil {
+			return InvalidRuleError(name, fmt.Sprintf("%q is not a valid prefix", prefix))
+		}
+	}
+	return nil
+}
+
+// isExpired returns true if the rule is not applied anymore at the time
+func (r *Rule) isExpired(now time.Time) bool {
+	return !now.Before(r.expires.AddDate(0, 0, 1))
+}
+
+func ruleName(kind string, index int) string {
+	return fmt.Sprintf("%s rule #%d", kind, index+1)
+}
+
+// Report describes what Apply did
+type Report struct {
+	// NumApplied is the number of rules that excluded, renamed or injected something.
+	// NumUnmatched is the number of rules that did not match anything, for example
+	// because their provider was not crawled, and NumExpired the number of rules skipped.
+	NumApplied   int
+	NumUnmatched int
+	NumExpired   int
+	// InjectedProviders are the providers that no crawler published, and were added
+	// by inject rules
+	InjectedProviders []string
+}
+
+// Apply applies the rules that have not expired at the time to the networks, and logs
+// every rule it applies or skips. The rules are validated first, so that files that
+// were not built by Parse have their expiry dates checked as well.
+func (f *File) Apply(networks *common.ExternalNetworkSources, now time.Time) (*Report, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	isActive := func(name string, rule *Rule) bool {
+		if rule.isExpired(now) {
+			log.Print(color.YellowString("Skipping %s, it expired on %s. Reason was: %s", name, rule.Expires, rule.Reason))
+			report.NumExpired++
+			return false
+		}
+		return true
+	}
+	// countApplied counts the rule as applied only if it changed the networks
+	countApplied := func(name string, rule *Rule, provider string, numChanged int) bool {
+		if numChanged == 0 {
+			log.Print(color.YellowString("%s did not match anything of provider %s. Reason: %s, expires %s",
+				name, provider, rule.Reason, rule.Expires))
+			report.NumUnmatched++
+			return false
+		}
+		report.NumApplied++
+		return true
+	}
+
+	for i, rule := range f.Exclude {
+		name := ruleName("exclude", i)
+		if !isActive(name, &rule.Rule) {
+			continue
+		}
+		numRemoved, err := rule.apply(networks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to apply %s", name)
+		}
+		if !countApplied(name, &rule.Rule, rule.Provider, numRemoved) {
+			continue
+		}
+		log.Printf("Applied %s to provider %s: removed %d prefixes. Reason: %s, expires %s",
+			name, rule.Provider, numRemoved, rule.Reason, rule.Expires)
+	}
+	for i, rule := range f.Rename {
+		name := ruleName("rename", i)
+		if !isActive(name, &rule.Rule) {
+			continue
+		}
+		numRenamed, err := rule.apply(networks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to apply %s", name)
+		}
+		if !countApplied(name, &rule.Rule, rule.Provider, numRenamed) {
+			continue
+		}
+		log.Printf("Applied %s to provider %s: renamed %d services. Reason: %s, expires %s",
+			name, rule.Provider, numRenamed, rule.Reason, rule.Expires)
+	}
+	for i, rule := range f.Inject {
+		name := ruleName("inject", i)
+		if !isActive(name, &rule.Rule) {
+			continue
+		}
+		numAdded, isNewProvider, err := rule.apply(networks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to apply %s", name)
+		}
+		if isNewProvider {
+			report.InjectedProviders = append(report.InjectedProviders, rule.Provider)
+		}
+		if !countApplied(name, &rule.Rule, rule.Provider, numAdded) {
+			continue
+		}
+		log.Printf("Applied %s to provider %s: added %d prefixes to region %s and service %s. Reason: %s, expires %s",
+			name, rule.Provider, numAdded, rule.Region, rule.Service, rule.Reason, rule.Expires)
+	}
+	return report, nil
+}
+
+// findProvider returns the networks of the provider, or nil if there are none
+func findProvider(networks *common.ExternalNetworkSources, providerName string) *common.ProviderNetworkRanges {
+	for _, provider := range networks.ProviderNetworks {
+		if provider.ProviderName == providerName {
+			return provider
+		}
+	}
+	return nil
+}
+
+// matches returns true if the name matches the name of a rule. Empty rule names
+// match any name.
+func matches(ruleName, name string) bool {
+	return ruleName == "" || ruleName == name
+}
+
+// apply removes the matching prefixes and returns how many were removed
+func (r *ExcludeRule) apply(networks *common.ExternalNetworkSources) (int, error) {
+	provider := findProvider(networks, r.Provider)
+	if provider == nil {
+		return 0, nil
+	}
+	excluded := make([]netip.Prefix, 0, len(r.Prefixes))
+	for _, ipPrefix := range r.Prefixes {
+		prefix, err := common.CanonicalIPPrefix(ipPrefix)
+		if err != nil {
+			return 0, err
+		}
+		excluded = append(excluded, prefix)
+	}
+
+	// Collect first, since removing changes the slices being iterated
+	type removal struct {
+		region, service, prefix string
+		wholeService            bool
+	}
+	var removals []removal
+	for _, region := range provider.RegionNetworks {
+		if !matches(r.Region, region.RegionName) {
+			continue
+		}
+		for _, service := range region.ServiceNetworks {
+			if !matches(r.Service, service.ServiceName) {
+				continue
+			}
+			if len(excluded) == 0 {
+				removals = append(removals, removal{region: region.RegionName, service: service.ServiceName, wholeService: true})
+				continue
+			}
+			for _, prefixes := range [][]string{service.IPv4Prefixes, service.IPv6Prefixes} {
+				for _, ipPrefix := range prefixes {
+					if isContainedInAny(ipPrefix, excluded) {
+						removals = append(removals, removal{region: region.RegionName, service: service.ServiceName, prefix: ipPrefix})
+					}
+				}
+			}
+		}
+	}
+
+	numRemoved := 0
+	for _, rm := range removals {
+		if rm.wholeService {
+			service := findService(provider, rm.region, rm.service)
+			numRemoved += len(service.IPv4Prefixes) + len(service.IPv6Prefixes)
+			if err := provider.RemoveService(rm.region, rm.service); err != nil {
+				return numRemoved, err
+			}
+			continue
+		}
+		if err := provider.ReplaceIPPrefix(rm.region, rm.service, rm.prefix, nil); err != nil {
+			return numRemoved, err
+		}
+		numRemoved++
+	}
+	return numRemoved, nil
+}
+
+// isContainedInAny returns true if the prefix is contained in one of the prefixes.
+// Strings that are not valid prefixes are never contained.
+func isContainedInAny(ipPrefix string, prefixes []netip.Prefix) bool {
+	prefix, err := netip.ParsePrefix(ipPrefix)
+	if err != nil {
+		return false
+	}
+	for _, container := range prefixes {
+		if container.Bits() <= prefix.Bits() && container.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func findService(provider *common.ProviderNetworkRanges, regionName, serviceName string) *common.ServiceIPRanges {
+	for _, region := range provider.RegionNetworks {
+		if region.RegionName != regionName {
+			continue
+		}
+		for _, service := range region.ServiceNetworks {
+			if service.ServiceName == serviceName {
+				return service
+			}
+		}
+	}
+	return nil
+}
+
+// apply renames the matching services and returns how many were renamed
+func (r *RenameRule) apply(networks *common.ExternalNetworkSources) (int, error) {
+	provider := findProvider(networks, r.Provider)
+	if provider == nil {
+		return 0, nil
+	}
+	var renamed []common.RegionServicePair
+	for _, region := range provider.RegionNetworks {
+		if !matches(r.Region, region.RegionName) {
+			continue
+		}
+		for _, service := range region.ServiceNetworks {
+			if matches(r.Service, service.ServiceName) {
+				renamed = append(renamed, common.RegionServicePair{Region: region.RegionName, Service: service.ServiceName})
+			}
+		}
+	}
+	for _, pair := range renamed {
+		newRegion, newService := pair.Region, pair.Service
+		if r.NewRegion != "" {
+			newRegion = r.NewRegion
+		}
+		if r.NewService != "" {
+			newService = r.NewService
+		}
+		if err := provider.MoveService(pair.Region, pair.Service, newRegion, newService); err != nil {
+			return 0, err
+		}
+	}
+	return len(renamed), nil
+}
+
+// apply adds the prefixes, and returns how many the service did not have yet and true
+// if the provider was added
+func (r *InjectRule) apply(networks *common.ExternalNetworkSources) (int, bool, error) {
+	provider := findProvider(networks, r.Provider)
+	isNewProvider := provider == nil
+	if isNewProvider {
+		provider = common.NewProviderNetworkRanges(r.Provider)
+	}
+	numPrevious := numServicePrefixes(provider, r.Region, r.Service)
+	for _, prefix := range r.Prefixes {
+		err := provider.AddIPPrefix(r.Region, r.Service, prefix, common.GetDefaultRegionServicePairRedundancyCheck())
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if isNewProvider {
+		networks.ProviderNetworks = append(networks.ProviderNetworks, provider)
+	}
+	return numServicePrefixes(provider, r.Region, r.Service) - numPrevious, isNewProvider, nil
+}
+
+func numServicePrefixes(provider *common.ProviderNetworkRanges, regionName, serviceName string) int {
+	service := findService(provider, regionName, serviceName)
+	if service == nil {
+		return 0
+	}
+	return len(service.IPv4Prefixes) + len(service.IPv6Prefixes)
+}
@@ -0,0 +1,197 @@
+package overrides
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stackrox/external-network-pusher/pkg/common"
+	"github.com/stretchr/testify/require"
+)
+
+const testOverrides = `
+exclude:
+  - provider: Amazon
+    prefixes: [52.94.0.0/16]
+    reason: Upstream feed lists a range that was returned to ARIN
+    expires: 2026-12-31
+  - provider: Amazon
+    region: us-east-1
+    service: ROUTE53_HEALTHCHECKS
+    reason: Not used by our customers
+    expires: 2026-12-31
+rename:
+  - provider: Amazon
+    region: us-east-1
+    newRegion: us-east-1-renamed
+    reason: Region was renamed upstream
+    expires: 2026-12-31
+  - provider: Amazon
+    service: S3
+    newService: EC2
+    reason: Expired rules are skipped
+    expires: 2026-01-01
+inject:
+  - provider: Amazon
+    region: eu-west-1
+    service: EC2
+    prefixes: [3.5.0.0/16]
+    reason: Missing from the upstream feed
+    expires: 2026-12-31
+  - provider: Partner
+    region: global
+    service: vpn
+    prefixes: [198.41.128.0/17, 2400:cb00::/32]
+    reason: Partner ranges
+    expires: 2026-12-31
+`
+
+func newTestNetworks(t *testing.T) *common.ExternalNetworkSources {
+	provider := common.NewProviderNetworkRanges("Amazon")
+	for _, prefix := range []struct{ region, service, prefix string }{
+		{"us-east-1", "EC2", "52.94.1.0/24"},
+		{"us-east-1", "EC2", "3.2.0.0/16"},
+		{"us-east-1", "ROUTE53_HEALTHCHECKS", "107.23.255.0/26"},
+		{"eu-west-1", "S3", "3.4.0.0/16"},
+	} {
+		require.Nil(t, provider.AddIPPrefix(
+			prefix.region, prefix.service, prefix.prefix, common.GetDefaultRegionServicePairRedundancyCheck()))
+	}
+	return &common.ExternalNetworkSources{ProviderNetworks: []*common.ProviderNetworkRanges{provider}}
+}
+
+func TestApply(t *testing.T) {
+	file, err := Parse([]byte(testOverrides))
+	require.Nil(t, err)
+	networks := newTestNetworks(t)
+
+	report, err := file.Apply(networks, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, err)
+	require.Equal(t, &Report{NumApplied: 5, NumExpired: 1, InjectedProviders: []string{"Partner"}}, report)
+
+	require.Len(t, networks.ProviderNetworks, 2)
+	amazon := networks.ProviderNetworks[0]
+	require.ElementsMatch(t, []*common.RegionNetworkDetail{
+		{
+			RegionName: "us-east-1-renamed",
+			ServiceNetworks: []*common.ServiceIPRanges{
+				{ServiceName: "EC2", IPv4Prefixes: []string{"3.2.0.0/16"}},
+			},
+		},
+		{
+			RegionName: "eu-west-1",
+			ServiceNetworks: []*common.ServiceIPRanges{
+				{ServiceName: "S3", IPv4Prefixes: []string{"3.4.0.0/16"}},
+				{ServiceName: "EC2", IPv4Prefixes: []string{"3.5.0.0/16"}},
+			},
+		},
+	}, amazon.RegionNetworks)
+
+	partner := networks.ProviderNetworks[1]
+	require.Equal(t, "Partner", partner.ProviderName)
+	require.Equal(t, []*common.RegionNetworkDetail{{
+		RegionName: "global",
+		ServiceNetworks: []*common.ServiceIPRanges{{
+			ServiceName:  "vpn",
+			IPv4Prefixes: []string{"198.41.128.0/17"},
+			IPv6Prefixes: []string{"2400:cb00::/32"},
+		}},
+	}}, partner.RegionNetworks)
+}
+
+func TestExpiry(t *testing.T) {
+	file, err := Parse([]byte(testOverrides))
+	require.Nil(t, err)
+
+	// Rules are applied through their expiry day
+	report, err := file.Apply(newTestNetworks(t), time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC))
+	require.Nil(t, err)
+	require.Equal(t, 5, report.NumApplied)
+
+	networks := newTestNetworks(t)
+	report, err = file.Apply(networks, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, err)
+	require.Equal(t, &Report{NumExpired: 6}, report)
+	require.Equal(t, newTestNetworks(t), networks)
+}
+
+func TestApplyUnmatchedRules(t *testing.T) {
+	file, err := Parse([]byte(`
+exclude:
+  - provider: Google
+    prefixes: [34.80.0.0/15]
+    reason: Provider is not crawled
+    expires: 2026-12-31
+rename:
+  - provider: Amazon
+    service: LAMBDA
+    newService: EC2
+    reason: Service is not crawled
+    expires: 2026-12-31
+inject:
+  - provider: Amazon
+    region: us-east-1
+    service: EC2
+    prefixes: [3.2.0.0/16]
+    reason: Prefix is crawled already
+    expires: 2026-12-31
+`))
+	require.Nil(t, err)
+	networks := newTestNetworks(t)
+
+	// Rules that do not change anything are not counted as applied
+	report, err := file.Apply(networks, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, err)
+	require.Equal(t, &Report{NumUnmatched: 3}, report)
+	require.Equal(t, newTestNetworks(t), networks)
+}
+
+func TestApplyFileBuiltInCode(t *testing.T) {
+	file := &File{
+		Exclude: []*ExcludeRule{{
+			Rule:     Rule{Reason: "Not used by our customers", Expires: "2026-12-31"},
+			Provider: "Amazon",
+			Region:   "us-east-1",
+			Service:  "ROUTE53_HEALTHCHECKS",
+		}},
+	}
+	networks := newTestNetworks(t)
+
+	// The expiry date is parsed by Apply as well
+	report, err := file.Apply(networks, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, err)
+	require.Equal(t, &Report{NumApplied: 1}, report)
+	require.Nil(t, findService(networks.ProviderNetworks[0], "us-east-1", "ROUTE53_HEALTHCHECKS"))
+
+	file.Exclude[0].Expires = "12/31/2026"
+	_, err = file.Apply(newTestNetworks(t), time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "exclude rule #1")
+}
+
+func TestParse(t *testing.T) {
+	// JSON is accepted as well
+	file, err := Parse([]byte(`{"inject": [{"provider": "Partner", "region": "global", "service": "vpn",
+		"prefixes": ["198.41.128.0/17"], "reason": "Partner ranges", "expires": "2026-12-31"}]}`))
+	require.Nil(t, err)
+	require.Len(t, file.Inject, 1)
+
+	file, err = Parse(nil)
+	require.Nil(t, err)
+	require.Empty(t, file.Exclude)
+
+	for _, invalid := range []string{
+		`exclude: [{provider: Amazon, service: S3, expires: 2026-12-31}]`,
+		`exclude: [{provider: Amazon, service: S3, reason: r}]`,
+		`exclude: [{provider: Amazon, service: S3, reason: r, expires: 31/12/2026}]`,
+		`exclude: [{service: S3, reason: r, expires: 2026-12-31}]`,
+		`exclude: [{provider: Amazon, reason: r, expires: 2026-12-31}]`,
+		`exclude: [{provider: Amazon, prefixes: [not a prefix], reason: r, expires: 2026-12-31}]`,
+		`exclude: [{provider: Amazon, servce: S3, reason: r, expires: 2026-12-31}]`,
+		`rename: [{provider: Amazon, region: us-east-1, reason: r, expires: 2026-12-31}]`,
+		`inject: [{provider: Partner, region: global, prefixes: [3.5.0.0/16], reason: r, expires: 2026-12-31}]`,
+		`inject: [{provider: Partner, region: global, service: vpn, reason: r, expires: 2026-12-31}]`,
+	} {
+		_, err := Parse([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrObjectNotFound is returned when reading an object that does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrPreconditionFailed is returned when a conditional write is rejected because
+// the object has been modified since it was read
+var ErrPreconditionFailed = errors.New("object generation precondition failed")
+
+// InvalidDestination is returned when a destination URL cannot be parsed
+func InvalidDestination(destination, reason string) error {
+	return fmt.Errorf("invalid destination %q: %s", destination, reason)
+}
+
+// UnsupportedScheme is returned when a destination URL uses a scheme with no Store implementation
+func UnsupportedScheme(scheme string) error {
+	return fmt.Errorf("unsupported destination scheme: %s", scheme)
+}
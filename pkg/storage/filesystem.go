@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// tmpFileSuffix marks files that are still being written. They are never
+	// listed as objects.
+	tmpFileSuffix = ".tmp"
+	// lockFileSuffix marks lock files guarding conditional writes. They are
+	// never listed as objects.
+	lockFileSuffix = ".lock"
+	// staleLockAge is the age after which a lock file is assumed to be left behind
+	// by a writer that crashed. Holding the lock only takes a read and a write of
+	// a small file, so live writers release it long before.
+	staleLockAge = 10 * time.Minute
+)
+
+// staleLockFoundHook is called when a stale lock file is found, before taking it over.
+// Tests use it to let another writer get in between.
+var staleLockFoundHook = func() {}
+
+type filesystemStore struct {
+	root string
+}
+
+// NewFilesystemStore returns a Store that mirrors the bucket layout under the
+// specified root directory. Object paths are mapped to file paths relative to
+// root, so the directory can be served by any static web server as a drop-in
+// replacement for a bucket.
+func NewFilesystemStore(root string) (Store, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert %s to absolute path", root)
+	}
+	return &filesystemStore{root: absRoot}, nil
+}
+
+func (s *filesystemStore) String() string {
+	return "file://" + filepath.ToSlash(s.root)
+}
+
+func (s *filesystemStore) toFilePath(objectPath string) string {
+	return filepath.Join(s.root, filepath.FromSlash(objectPath))
+}
+
+// Put writes to a temporary file first and then renames it, so that readers
+// never observe a partially written object.
+func (s *filesystemStore) Put(_ context.Context, objectPath string, data []byte) error {
+	path := s.toFilePath(objectPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %s", objectPath)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+tmpFileSuffix)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file for %s", objectPath)
+	}
+	defer func() {
+		// No-op if the file has already been renamed
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "failed to write %s", objectPath)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "failed to set permissions of %s", objectPath)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", objectPath)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PutIfGenerationMatch guards the check-and-write with a lock file next to the
+// object, so concurrent publishers on the same machine (or shared volume) fail
+// instead of overwriting each other. A lock file older than staleLockAge is
+// assumed to be left behind by a crashed writer and is taken over.
+func (s *filesystemStore) PutIfGenerationMatch(
+	ctx context.Context,
+	objectPath string,
+	data []byte,
+	generation Generation,
+) error {
+	path := s.toFilePath(objectPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %s", objectPath)
+	}
+	lockPath := path + lockFileSuffix
+	if err := lockFile(lockPath); err != nil {
+		return errors.Wrapf(err, "failed to lock %s", objectPath)
+	}
+	defer func() {
+		_ = os.Remove(lockPath)
+	}()
+
+	_, current, err := s.GetWithGeneration(ctx, objectPath)
+	if err != nil && err != ErrObjectNotFound {
+		return err
+	}
+	if current != generation {
+		return ErrPreconditionFailed
+	}
+	return s.Put(ctx, objectPath, data)
+}
+
+// lockFile creates the lock file, which holds the PID of the writer to help
+// operators find out who holds it. ErrPreconditionFailed is returned if another
+// writer holds the lock.
+func lockFile(lockPath string) error {
+	lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrExist) && takeOverStaleLockFile(lockPath) {
+		lock, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return errors.Wrapf(ErrPreconditionFailed,
+			"locked by another writer. If no other writer is running, remove the lock file %s", lockPath)
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(lock, "%d\n", os.Getpid())
+	return lock.Close()
+}
+
+// takeOverStaleLockFile removes the lock file if it is older than staleLockAge, and
+// returns whether it did. Several writers can find the same stale lock file, so it is
+// first renamed to a name of its own, which only one of them can do. If the renamed
+// file turns out to be a lock another writer has taken over in the meantime, it is
+// put back.
+func takeOverStaleLockFile(lockPath string) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil || time.Since(info.ModTime()) < staleLockAge {
+		return false
+	}
+	staleLockFoundHook()
+	stalePath := lockPath + "." + uuid.New().String() + lockFileSuffix
+	if err := os.Rename(lockPath, stalePath); err != nil {
+		// Another writer took it over first
+		return false
+	}
+	defer func() {
+		_ = os.Remove(stalePath)
+	}()
+	renamedInfo, err := os.Stat(stalePath)
+	// Inodes of removed files are reused, so also compare the modification times
+	if err != nil || !os.SameFile(info, renamedInfo) || !renamedInfo.ModTime().Equal(info.ModTime()) {
+		// Linking fails instead of replacing a lock created since
+		_ = os.Link(stalePath, lockPath)
+		return false
+	}
+	return true
+}
+
+func (s *filesystemStore) Get(ctx context.Context, objectPath string) ([]byte, error) {
+	data, _, err := s.GetWithGeneration(ctx, objectPath)
+	return data, err
+}
+
+// GetWithGeneration uses the SHA-256 of the file content as generation
+func (s *filesystemStore) GetWithGeneration(_ context.Context, objectPath string) ([]byte, Generation, error) {
+	data, err := os.ReadFile(s.toFilePath(objectPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, NoGeneration, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, NoGeneration, err
+	}
+	hash := sha256.Sum256(data)
+	return data, Generation(hex.EncodeToString(hash[:])), nil
+}
+
+func (s *filesystemStore) ListPrefixes(_ context.Context, prefix string) ([]string, error) {
+	names, err := s.objectNamesWithPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return uniquePrefixes(names), nil
+}
+
+func (s *filesystemStore) DeletePrefix(_ context.Context, prefix string) error {
+	names, err := s.objectNamesWithPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Remove(s.toFilePath(name)); err != nil {
+			return errors.Wrapf(err, "failed to delete %s under %s. Please clean up manually", name, s.root)
+		}
+		s.removeEmptyParents(name)
+	}
+	return nil
+}
+
+func (s *filesystemStore) Copy(ctx context.Context, srcPath, dstPath string) error {
+	data, err := s.Get(ctx, srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed while copying to %s from %s", dstPath, srcPath)
+	}
+	return s.Put(ctx, dstPath, data)
+}
+
+// removeEmptyParents removes the directories of the object up to root
+// as long as they are empty. Mimics buckets where "folders" do not exist
+// without objects in them.
+func (s *filesystemStore) removeEmptyParents(objectPath string) {
+	for dir := parentPrefix(objectPath); dir != "."; dir = parentPrefix(dir) {
+		// Remove fails on non-empty directories
+		if err := os.Remove(s.toFilePath(dir)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *filesystemStore) objectNamesWithPrefix(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == s.root {
+			// Nothing published yet
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, tmpFileSuffix) || strings.HasSuffix(path, lockFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed while trying to list and traverse files under %s", s.root)
+	}
+	return names, nil
+}
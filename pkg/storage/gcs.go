@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	gstorage "cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	// gCloudClientTimeout is the timeout value we use for
+	// clients connected to Google Cloud
+	gCloudClientTimeout = 3 * time.Minute
+)
+
+type gcsStore struct {
+	bucketName string
+}
+
+// NewGCSStore returns a Store backed by the specified Google Cloud Storage bucket
+func NewGCSStore(bucketName string) Store {
+	return &gcsStore{bucketName: bucketName}
+}
+
+func (s *gcsStore) String() string {
+	return "gs://" + s.bucketName
+}
+
+func (s *gcsStore) withBucket(ctx context.Context, do func(ctx context.Context, bucket *gstorage.BucketHandle) error) error {
+	ctx, cancel := context.WithTimeout(ctx, gCloudClientTimeout)
+	defer cancel()
+	client, err := gstorage.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return do(ctx, client.Bucket(s.bucketName))
+}
+
+func (s *gcsStore) Put(ctx context.Context, objectPath string, data []byte) error {
+	return s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		return write(ctx, bucket.Object(objectPath), data)
+	})
+}
+
+func (s *gcsStore) PutIfGenerationMatch(ctx context.Context, objectPath string, data []byte, generation Generation) error {
+	conditions := gstorage.Conditions{DoesNotExist: true}
+	if generation != NoGeneration {
+		parsed, err := strconv.ParseInt(string(generation), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid GCS generation %q", generation)
+		}
+		conditions = gstorage.Conditions{GenerationMatch: parsed}
+	}
+
+	return s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		err := write(ctx, bucket.Object(objectPath).If(conditions), data)
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return ErrPreconditionFailed
+		}
+		return err
+	})
+}
+
+func write(ctx context.Context, object *gstorage.ObjectHandle, data []byte) error {
+	writer := object.NewWriter(ctx)
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (s *gcsStore) Get(ctx context.Context, objectPath string) ([]byte, error) {
+	data, _, err := s.GetWithGeneration(ctx, objectPath)
+	return data, err
+}
+
+func (s *gcsStore) GetWithGeneration(ctx context.Context, objectPath string) ([]byte, Generation, error) {
+	var data []byte
+	var generation Generation
+	err := s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		reader, err := bucket.Object(objectPath).NewReader(ctx)
+		if err == gstorage.ErrObjectNotExist {
+			return ErrObjectNotFound
+		}
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		generation = Generation(strconv.FormatInt(reader.Attrs.Generation, 10))
+		data, err = io.ReadAll(reader)
+		return err
+	})
+	if err != nil {
+		return nil, NoGeneration, err
+	}
+	return data, generation, nil
+}
+
+func (s *gcsStore) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		var err error
+		names, err = s.objectNamesWithPrefix(ctx, bucket, prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uniquePrefixes(names), nil
+}
+
+func (s *gcsStore) DeletePrefix(ctx context.Context, prefix string) error {
+	return s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		names, err := s.objectNamesWithPrefix(ctx, bucket, prefix)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := bucket.Object(name).Delete(ctx); err != nil {
+				return errors.Wrapf(
+					err,
+					"failed to delete object with name %s under bucket %s. Please clean up manually",
+					name,
+					s.bucketName)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *gcsStore) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return s.withBucket(ctx, func(ctx context.Context, bucket *gstorage.BucketHandle) error {
+		src := bucket.Object(srcPath)
+		dst := bucket.Object(dstPath)
+		if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+			return errors.Wrapf(err, "failed while copying to %s from %s", dstPath, srcPath)
+		}
+		return nil
+	})
+}
+
+// objectNamesWithPrefix returns all object start with specified prefix
+// under the bucket. If no prefix is supplied, it returns all objects
+// under that bucket.
+func (s *gcsStore) objectNamesWithPrefix(
+	ctx context.Context,
+	bucket *gstorage.BucketHandle,
+	prefix string,
+) ([]string, error) {
+	var query *gstorage.Query
+	if prefix != "" {
+		query = &gstorage.Query{Prefix: prefix}
+	}
+
+	var names []string
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed while trying to list and traverse objects in bucket %s", s.bucketName)
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
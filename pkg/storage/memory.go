@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryStore is a Store that keeps all objects in memory. It is mostly useful
+// for testing publishing logic without any credentials.
+type MemoryStore struct {
+	lock           sync.RWMutex
+	objects        map[string]*memoryObject
+	lastGeneration int64
+}
+
+type memoryObject struct {
+	data       []byte
+	generation Generation
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string]*memoryObject)}
+}
+
+func (s *MemoryStore) String() string {
+	return "memory://"
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(_ context.Context, objectPath string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.put(objectPath, data)
+	return nil
+}
+
+// PutIfGenerationMatch implements Store
+func (s *MemoryStore) PutIfGenerationMatch(
+	_ context.Context,
+	objectPath string,
+	data []byte,
+	generation Generation,
+) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current := NoGeneration
+	if object, ok := s.objects[objectPath]; ok {
+		current = object.generation
+	}
+	if current != generation {
+		return ErrPreconditionFailed
+	}
+	s.put(objectPath, data)
+	return nil
+}
+
+func (s *MemoryStore) put(objectPath string, data []byte) {
+	s.lastGeneration++
+	s.objects[objectPath] = &memoryObject{
+		data:       append([]byte(nil), data...),
+		generation: Generation(strconv.FormatInt(s.lastGeneration, 10)),
+	}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, objectPath string) ([]byte, error) {
+	data, _, err := s.GetWithGeneration(ctx, objectPath)
+	return data, err
+}
+
+// GetWithGeneration implements Store
+func (s *MemoryStore) GetWithGeneration(_ context.Context, objectPath string) ([]byte, Generation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	object, ok := s.objects[objectPath]
+	if !ok {
+		return nil, NoGeneration, ErrObjectNotFound
+	}
+	return append([]byte(nil), object.data...), object.generation, nil
+}
+
+// ListPrefixes implements Store
+func (s *MemoryStore) ListPrefixes(_ context.Context, prefix string) ([]string, error) {
+	return uniquePrefixes(s.ObjectNames(prefix)), nil
+}
+
+// DeletePrefix implements Store
+func (s *MemoryStore) DeletePrefix(_ context.Context, prefix string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			delete(s.objects, name)
+		}
+	}
+	return nil
+}
+
+// Copy implements Store
+func (s *MemoryStore) Copy(_ context.Context, srcPath, dstPath string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	object, ok := s.objects[srcPath]
+	if !ok {
+		return errors.Wrapf(ErrObjectNotFound, "failed while copying to %s from %s", dstPath, srcPath)
+	}
+	s.put(dstPath, object.data)
+	return nil
+}
+
+// ObjectNames returns the sorted names of all objects starting with prefix
+func (s *MemoryStore) ObjectNames(prefix string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
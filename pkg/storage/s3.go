@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const (
+	// s3ClientTimeout is the timeout value we use for
+	// requests sent to S3 compatible object stores
+	s3ClientTimeout = 3 * time.Minute
+
+	defaultS3Endpoint = "s3.amazonaws.com"
+)
+
+// S3Options configures a Store backed by an S3 compatible object store
+type S3Options struct {
+	// BucketName is the name of the bucket to publish to
+	BucketName string
+	// Endpoint is the host (and optionally port) of the object store.
+	// Defaults to AWS S3.
+	Endpoint string
+	// Region of the bucket. Optional for most S3 compatible stores.
+	Region string
+	// PathStyle forces path-style addressing (http://endpoint/bucket/object),
+	// which is usually needed by MinIO and Ceph RGW.
+	PathStyle bool
+	// Insecure uses plain HTTP instead of HTTPS to talk to the endpoint
+	Insecure bool
+}
+
+type s3Store struct {
+	opts   S3Options
+	client *minio.Client
+}
+
+// NewS3Store returns a Store backed by an S3 compatible object store.
+// Credentials are read from the standard AWS (AWS_ACCESS_KEY_ID, ~/.aws/credentials, IAM)
+// or MinIO (MINIO_ACCESS_KEY) sources.
+func NewS3Store(opts S3Options) (Store, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultS3Endpoint
+	}
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !opts.Insecure,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create S3 client for endpoint %s", opts.Endpoint)
+	}
+	return &s3Store{opts: opts, client: client}, nil
+}
+
+// s3OptionsFromURL parses destinations of the form:
+//
+//	s3://<bucket name>?endpoint=<host:port>&region=<region>&path-style=true&insecure=true
+func s3OptionsFromURL(u *url.URL) (S3Options, error) {
+	opts := S3Options{
+		BucketName: u.Host,
+		Endpoint:   u.Query().Get("endpoint"),
+		Region:     u.Query().Get("region"),
+	}
+	if opts.BucketName == "" {
+		return opts, InvalidDestination(u.String(), "bucket name is empty")
+	}
+	for param, target := range map[string]*bool{"path-style": &opts.PathStyle, "insecure": &opts.Insecure} {
+		value := u.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, InvalidDestination(u.String(), "invalid value for "+param)
+		}
+		*target = parsed
+	}
+	return opts, nil
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.opts.BucketName
+}
+
+func (s *s3Store) Put(ctx context.Context, objectPath string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+	_, err := s.client.PutObject(
+		ctx,
+		s.opts.BucketName,
+		objectPath,
+		bytes.NewReader(data),
+		int64(len(data)),
+		minio.PutObjectOptions{})
+	return err
+}
+
+// PutIfGenerationMatch uses the object's ETag as generation and relies on
+// conditional writes (If-Match/If-None-Match), which are supported by AWS S3 and MinIO.
+func (s *s3Store) PutIfGenerationMatch(ctx context.Context, objectPath string, data []byte, generation Generation) error {
+	opts := minio.PutObjectOptions{}
+	if generation == NoGeneration {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(string(generation))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, s.opts.BucketName, objectPath, bytes.NewReader(data), int64(len(data)), opts)
+	return s.translateError(err)
+}
+
+func (s *s3Store) Get(ctx context.Context, objectPath string) ([]byte, error) {
+	data, _, err := s.GetWithGeneration(ctx, objectPath)
+	return data, err
+}
+
+func (s *s3Store) GetWithGeneration(ctx context.Context, objectPath string) ([]byte, Generation, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+	object, err := s.client.GetObject(ctx, s.opts.BucketName, objectPath, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, NoGeneration, s.translateError(err)
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		return nil, NoGeneration, s.translateError(err)
+	}
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, NoGeneration, s.translateError(err)
+	}
+	return data, Generation(info.ETag), nil
+}
+
+func (s *s3Store) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	names, err := s.objectNamesWithPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return uniquePrefixes(names), nil
+}
+
+func (s *s3Store) DeletePrefix(ctx context.Context, prefix string) error {
+	names, err := s.objectNamesWithPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+	for _, name := range names {
+		if err := s.client.RemoveObject(ctx, s.opts.BucketName, name, minio.RemoveObjectOptions{}); err != nil {
+			return errors.Wrapf(
+				err,
+				"failed to delete object with name %s under bucket %s. Please clean up manually",
+				name,
+				s.opts.BucketName)
+		}
+	}
+	return nil
+}
+
+func (s *s3Store) Copy(ctx context.Context, srcPath, dstPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+	_, err := s.client.CopyObject(
+		ctx,
+		minio.CopyDestOptions{Bucket: s.opts.BucketName, Object: dstPath},
+		minio.CopySrcOptions{Bucket: s.opts.BucketName, Object: srcPath})
+	if err != nil {
+		return errors.Wrapf(s.translateError(err), "failed while copying to %s from %s", dstPath, srcPath)
+	}
+	return nil
+}
+
+func (s *s3Store) objectNamesWithPrefix(ctx context.Context, prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3ClientTimeout)
+	defer cancel()
+
+	var names []string
+	for object := range s.client.ListObjects(ctx, s.opts.BucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, errors.Wrapf(
+				object.Err,
+				"failed while trying to list and traverse objects in bucket %s",
+				s.opts.BucketName)
+		}
+		names = append(names, object.Key)
+	}
+	return names, nil
+}
+
+func (s *s3Store) translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case minio.NoSuchKey:
+		return ErrObjectNotFound
+	case minio.PreconditionFailed:
+		return ErrPreconditionFailed
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Store abstracts the object storage the crawler publishes network definitions to.
+// Object paths are always "/" separated, regardless of the backend.
+type Store interface {
+	// Put writes data to the object at objectPath, overwriting any existing content.
+	Put(ctx context.Context, objectPath string, data []byte) error
+	// Get returns the content of the object at objectPath. ErrObjectNotFound is
+	// returned if the object does not exist.
+	Get(ctx context.Context, objectPath string) ([]byte, error)
+	// ListPrefixes returns all the prefixes (sub-folders at the bottom most layer)
+	// of objects whose name starts with prefix.
+	ListPrefixes(ctx context.Context, prefix string) ([]string, error)
+	// DeletePrefix deletes every object whose name starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// Copy copies the object at srcPath to dstPath.
+	Copy(ctx context.Context, srcPath, dstPath string) error
+	// GetWithGeneration returns the content of the object at objectPath along
+	// with its current generation. ErrObjectNotFound is returned if the object
+	// does not exist.
+	GetWithGeneration(ctx context.Context, objectPath string) ([]byte, Generation, error)
+	// PutIfGenerationMatch writes data to the object at objectPath only if the
+	// object's current generation is the specified generation. NoGeneration
+	// requires the object to not exist. ErrPreconditionFailed is returned if
+	// the precondition does not hold.
+	PutIfGenerationMatch(ctx context.Context, objectPath string, data []byte, generation Generation) error
+	// String returns the destination URL of the store.
+	String() string
+}
+
+// Generation identifies a version of an object. Its format is specific to each
+// Store implementation and should be treated as opaque.
+type Generation string
+
+// NoGeneration is the generation of an object that does not exist
+const NoGeneration Generation = ""
+
+// New returns the Store selected by the specified destination URL.
+// Supported formats are:
+//   - gs://<bucket name>
+//   - s3://<bucket name>?endpoint=<host:port>&region=<region>&path-style=true&insecure=true
+//     (all query parameters are optional)
+//   - file://<directory>
+func New(destination string) (Store, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, InvalidDestination(destination, err.Error())
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "gs":
+		if u.Host == "" {
+			return nil, InvalidDestination(destination, "bucket name is empty")
+		}
+		return NewGCSStore(u.Host), nil
+	case "s3":
+		opts, err := s3OptionsFromURL(u)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(opts)
+	case "file":
+		root := u.Host + u.Path
+		if root == "" {
+			return nil, InvalidDestination(destination, "directory is empty")
+		}
+		return NewFilesystemStore(root)
+	case "":
+		return nil, InvalidDestination(destination, "no scheme specified")
+	default:
+		return nil, UnsupportedScheme(u.Scheme)
+	}
+}
+
+// JoinPath joins object path elements with "/", ignoring empty elements.
+func JoinPath(elems ...string) string {
+	filtered := make([]string, 0, len(elems))
+	for _, e := range elems {
+		e = strings.Trim(e, "/")
+		if e != "" {
+			filtered = append(filtered, e)
+		}
+	}
+	return strings.Join(filtered, "/")
+}
+
+// parentPrefix returns the "folder" an object lives under.
+func parentPrefix(objectPath string) string {
+	i := strings.LastIndex(objectPath, "/")
+	if i < 0 {
+		return "."
+	}
+	return objectPath[:i]
+}
+
+// uniquePrefixes returns the distinct parent prefixes of the given object names.
+func uniquePrefixes(objectNames []string) []string {
+	prefixes := make(map[string]struct{})
+	for _, name := range objectNames {
+		prefixes[parentPrefix(name)] = struct{}{}
+	}
+
+	result := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		result = append(result, prefix)
+	}
+	return result
+}
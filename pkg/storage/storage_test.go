@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	store, err := New("gs://some-bucket")
+	require.Nil(t, err)
+	require.Equal(t, "gs://some-bucket", store.String())
+
+	_, err = New("gs://")
+	require.NotNil(t, err)
+
+	_, err = New("some-bucket")
+	require.NotNil(t, err)
+
+	_, err = New("ftp://some-bucket")
+	require.NotNil(t, err)
+	require.Equal(t, UnsupportedScheme("ftp").Error(), err.Error())
+}
+
+func TestJoinPath(t *testing.T) {
+	require.Equal(t, "a/b/c", JoinPath("a", "b", "c"))
+	require.Equal(t, "a/c", JoinPath("a/", "", "/c"))
+	require.Equal(t, "", JoinPath())
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), "root")
+}
+
+func TestFilesystemStore(t *testing.T) {
+	root := t.TempDir()
+	store, err := New("file://" + root)
+	require.Nil(t, err)
+	require.Equal(t, "file://"+filepath.ToSlash(root), store.String())
+	testStore(t, store, "root")
+
+	// Deleting all objects should not leave empty directories behind
+	entries, err := os.ReadDir(root)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	// Objects map to plain files so the directory can be served as is
+	require.Nil(t, store.Put(context.Background(), "root/run/data", []byte("data")))
+	data, err := os.ReadFile(filepath.Join(root, "root", "run", "data"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("data"), data)
+
+	// Listing a directory that does not exist yet is not an error
+	store, err = New("file://" + filepath.Join(root, "missing"))
+	require.Nil(t, err)
+	prefixes, err := store.ListPrefixes(context.Background(), "root")
+	require.Nil(t, err)
+	require.Empty(t, prefixes)
+}
+
+func TestFilesystemStoreLock(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewFilesystemStore(root)
+	require.Nil(t, err)
+
+	// Another writer holds the lock
+	lockPath := filepath.Join(root, "latest") + lockFileSuffix
+	require.Nil(t, os.WriteFile(lockPath, []byte("1\n"), 0644))
+	err = store.PutIfGenerationMatch(ctx, "latest", []byte("run1"), NoGeneration)
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(err))
+	require.Contains(t, err.Error(), lockPath)
+
+	// The lock has been left behind by a writer that crashed
+	staleTime := time.Now().Add(-staleLockAge - time.Minute)
+	require.Nil(t, os.Chtimes(lockPath, staleTime, staleTime))
+	require.Nil(t, store.PutIfGenerationMatch(ctx, "latest", []byte("run1"), NoGeneration))
+	data, err := store.Get(ctx, "latest")
+	require.Nil(t, err)
+	require.Equal(t, []byte("run1"), data)
+	_, err = os.Stat(lockPath)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestFilesystemStoreStaleLockTakenOverMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewFilesystemStore(root)
+	require.Nil(t, err)
+	lockPath := filepath.Join(root, "latest") + lockFileSuffix
+	require.Nil(t, os.WriteFile(lockPath, []byte("1\n"), 0644))
+	staleTime := time.Now().Add(-staleLockAge - time.Minute)
+	require.Nil(t, os.Chtimes(lockPath, staleTime, staleTime))
+
+	// Another writer takes over the stale lock right after this writer found it stale
+	defer func(hook func()) {
+		staleLockFoundHook = hook
+	}(staleLockFoundHook)
+	staleLockFoundHook = func() {
+		require.Nil(t, os.Remove(lockPath))
+		require.Nil(t, os.WriteFile(lockPath, []byte("2\n"), 0644))
+	}
+	err = store.PutIfGenerationMatch(ctx, "latest", []byte("run1"), NoGeneration)
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(err))
+	_, err = store.Get(ctx, "latest")
+	require.Equal(t, ErrObjectNotFound, err)
+
+	// The other writer still holds its lock
+	data, err := os.ReadFile(lockPath)
+	require.Nil(t, err)
+	require.Equal(t, []byte("2\n"), data)
+	entries, err := os.ReadDir(root)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestFilesystemStoreStaleLockConcurrentWriters(t *testing.T) {
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		root := t.TempDir()
+		store, err := NewFilesystemStore(root)
+		require.Nil(t, err)
+		lockPath := filepath.Join(root, "latest") + lockFileSuffix
+		require.Nil(t, os.WriteFile(lockPath, []byte("1\n"), 0644))
+		staleTime := time.Now().Add(-staleLockAge - time.Minute)
+		require.Nil(t, os.Chtimes(lockPath, staleTime, staleTime))
+
+		// Every writer expects the object not to exist, so only one of them can write it
+		// if they hold the lock one at a time
+		const numWriters = 8
+		errs := make([]error, numWriters)
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for j := 0; j < numWriters; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				<-start
+				errs[j] = store.PutIfGenerationMatch(ctx, "latest", []byte(fmt.Sprintf("run%d", j)), NoGeneration)
+			}(j)
+		}
+		close(start)
+		wg.Wait()
+		numWritten := 0
+		for _, err := range errs {
+			if err == nil {
+				numWritten++
+				continue
+			}
+			require.Equal(t, ErrPreconditionFailed, errors.Cause(err))
+		}
+		require.Equal(t, 1, numWritten)
+	}
+}
+
+// TestGCSStore runs against a GCS emulator such as fake-gcs-server, which the GCS client
+// picks up through STORAGE_EMULATOR_HOST:
+//
+//	mkdir -p /tmp/gcs/<bucket>
+//	docker run -p 4443:4443 -v /tmp/gcs:/data fsouza/fake-gcs-server -scheme http
+//	STORAGE_EMULATOR_HOST=localhost:4443 GCS_TEST_BUCKET=<bucket> go test ./pkg/storage
+func TestGCSStore(t *testing.T) {
+	bucketName := os.Getenv("GCS_TEST_BUCKET")
+	if bucketName == "" || os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("GCS_TEST_BUCKET or STORAGE_EMULATOR_HOST not set")
+	}
+	testStore(t, NewGCSStore(bucketName), "test-"+uuid.New().String())
+}
+
+// TestS3Store runs against a real S3 compatible store, for example a local MinIO container:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_DESTINATION="s3://<bucket>?endpoint=localhost:9000&path-style=true&insecure=true" go test ./pkg/storage
+func TestS3Store(t *testing.T) {
+	destination := os.Getenv("S3_TEST_DESTINATION")
+	if destination == "" {
+		t.Skip("S3_TEST_DESTINATION not set")
+	}
+	store, err := New(destination)
+	require.Nil(t, err)
+	testStore(t, store, "test-"+uuid.New().String())
+}
+
+func TestS3OptionsFromURL(t *testing.T) {
+	u, err := url.Parse("s3://bucket?endpoint=localhost:9000&region=us-east-1&path-style=true&insecure=1")
+	require.Nil(t, err)
+	opts, err := s3OptionsFromURL(u)
+	require.Nil(t, err)
+	require.Equal(t, S3Options{
+		BucketName: "bucket",
+		Endpoint:   "localhost:9000",
+		Region:     "us-east-1",
+		PathStyle:  true,
+		Insecure:   true,
+	}, opts)
+
+	u, err = url.Parse("s3://bucket?path-style=maybe")
+	require.Nil(t, err)
+	_, err = s3OptionsFromURL(u)
+	require.NotNil(t, err)
+
+	store, err := New("s3://bucket")
+	require.Nil(t, err)
+	require.Equal(t, "s3://bucket", store.String())
+}
+
+// testStore checks the behavior every Store implementation should have.
+// All objects are created under root.
+func testStore(t *testing.T, store Store, root string) {
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, JoinPath(root, "missing"))
+	require.Equal(t, ErrObjectNotFound, err)
+
+	require.Nil(t, store.Put(ctx, JoinPath(root, "latest"), []byte("root/run1")))
+	require.Nil(t, store.Put(ctx, JoinPath(root, "run1/data"), []byte("data1")))
+	require.Nil(t, store.Put(ctx, JoinPath(root, "run1/checksum"), []byte("cksum1")))
+	require.Nil(t, store.Put(ctx, JoinPath(root, "run2/data"), []byte("data2")))
+
+	data, err := store.Get(ctx, JoinPath(root, "run1/data"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("data1"), data)
+
+	prefixes, err := store.ListPrefixes(ctx, root)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{root, JoinPath(root, "run1"), JoinPath(root, "run2")}, prefixes)
+
+	require.Nil(t, store.Copy(ctx, JoinPath(root, "run2/data"), JoinPath(root, "run3/data")))
+	data, err = store.Get(ctx, JoinPath(root, "run3/data"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("data2"), data)
+
+	require.Nil(t, store.DeletePrefix(ctx, JoinPath(root, "run1")+"/"))
+	prefixes, err = store.ListPrefixes(ctx, root)
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{root, JoinPath(root, "run2"), JoinPath(root, "run3")}, prefixes)
+	_, err = store.Get(ctx, JoinPath(root, "run1/data"))
+	require.Equal(t, ErrObjectNotFound, err)
+
+	// Conditional writes
+	latest := JoinPath(root, "latest")
+	_, generation, err := store.GetWithGeneration(ctx, latest)
+	require.Nil(t, err)
+	require.NotEqual(t, NoGeneration, generation)
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(store.PutIfGenerationMatch(ctx, latest, []byte("x"), NoGeneration)))
+	require.Nil(t, store.PutIfGenerationMatch(ctx, latest, []byte("root/run2"), generation))
+	// The generation we read is now outdated
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(store.PutIfGenerationMatch(ctx, latest, []byte("x"), generation)))
+	data, newGeneration, err := store.GetWithGeneration(ctx, latest)
+	require.Nil(t, err)
+	require.Equal(t, []byte("root/run2"), data)
+	require.NotEqual(t, generation, newGeneration)
+
+	created := JoinPath(root, "created")
+	require.Nil(t, store.PutIfGenerationMatch(ctx, created, []byte("created"), NoGeneration))
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(store.PutIfGenerationMatch(ctx, created, []byte("x"), NoGeneration)))
+
+	require.Nil(t, store.DeletePrefix(ctx, root+"/"))
+	prefixes, err = store.ListPrefixes(ctx, root)
+	require.Nil(t, err)
+	require.Empty(t, prefixes)
+}
@@ -0,0 +1,10 @@
+package version
+
+// version is set at build time through
+// -ldflags "-X github.com/stackrox/external-network-pusher/pkg/version.version=<version>"
+var version = "development"
+
+// Version returns the version of the crawler binary
+func Version() string {
+	return version
+}